@@ -0,0 +1,295 @@
+package zplgfa
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+func init() {
+	image.RegisterFormat("bmp", "BM", DecodeBMP, DecodeBMPConfig)
+}
+
+// encodeBMP writes packed one bit per dot rows as an uncompressed monochrome
+// BMP file. Palette index 1 is black, so the rows can be copied unchanged.
+func encodeBMP(raw []byte, bytesPerRow, width, height int) []byte {
+	const headerSize = 14 + 40 + 2*4
+	stride := (width + 31) / 32 * 4
+	out := make([]byte, headerSize+stride*height)
+
+	copy(out, "BM")
+	binary.LittleEndian.PutUint32(out[2:], uint32(len(out)))
+	binary.LittleEndian.PutUint32(out[10:], headerSize)
+	binary.LittleEndian.PutUint32(out[14:], 40)
+	binary.LittleEndian.PutUint32(out[18:], uint32(width))
+	binary.LittleEndian.PutUint32(out[22:], uint32(height))
+	binary.LittleEndian.PutUint16(out[26:], 1)
+	binary.LittleEndian.PutUint16(out[28:], 1)
+	binary.LittleEndian.PutUint32(out[34:], uint32(stride*height))
+	binary.LittleEndian.PutUint32(out[46:], 2)
+	binary.LittleEndian.PutUint32(out[50:], 2)
+	copy(out[54:], []byte{0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00})
+
+	// BMP rows are stored bottom-up.
+	for y := 0; y < height; y++ {
+		offset := headerSize + (height-1-y)*stride
+		copy(out[offset:offset+stride], raw[y*bytesPerRow:(y+1)*bytesPerRow])
+	}
+	return out
+}
+
+// DecodeBMP reads an uncompressed 1, 4, 8, 16, 24 or 32 bit BMP file.
+// Palette based files return an *image.Paletted, all others an *image.NRGBA.
+func DecodeBMP(r io.Reader) (image.Image, error) {
+	reader := bufio.NewReader(r)
+	header, err := readBMPHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := reader.Discard(header.dataOffset - header.headerLen); err != nil {
+		return nil, fmt.Errorf("BMP pixel data missing: %w", err)
+	}
+
+	bounds := image.Rect(0, 0, header.width, header.height)
+	stride := (header.width*header.bitsPerPixel + 31) / 32 * 4
+	row := make([]byte, stride)
+
+	var img image.Image
+	var setRow func(y int)
+	if header.palette != nil {
+		paletted := image.NewPaletted(bounds, header.palette)
+		img = paletted
+		setRow = func(y int) {
+			pix := paletted.Pix[y*paletted.Stride : y*paletted.Stride+header.width]
+			for x := range pix {
+				index := bmpBits(row, x, header.bitsPerPixel)
+				if int(index) >= len(header.palette) {
+					index = 0
+				}
+				pix[x] = index
+			}
+		}
+	} else {
+		nrgba := image.NewNRGBA(bounds)
+		img = nrgba
+		bytesPerPixel := header.bitsPerPixel / 8
+		setRow = func(y int) {
+			pix := nrgba.Pix[y*nrgba.Stride : y*nrgba.Stride+4*header.width]
+			for x := 0; x < header.width; x++ {
+				var value uint32
+				for i := 0; i < bytesPerPixel; i++ {
+					value |= uint32(row[x*bytesPerPixel+i]) << (8 * uint(i))
+				}
+				pix[4*x+0] = header.masks[0].extract(value, 0)
+				pix[4*x+1] = header.masks[1].extract(value, 0)
+				pix[4*x+2] = header.masks[2].extract(value, 0)
+				pix[4*x+3] = header.masks[3].extract(value, 0xff)
+			}
+		}
+	}
+
+	for i := 0; i < header.height; i++ {
+		if _, err := io.ReadFull(reader, row); err != nil {
+			return nil, fmt.Errorf("BMP pixel data too short: %w", err)
+		}
+		y := header.height - 1 - i
+		if header.topDown {
+			y = i
+		}
+		setRow(y)
+	}
+	return img, nil
+}
+
+// DecodeBMPConfig returns the dimensions of a BMP file without decoding the pixel data.
+func DecodeBMPConfig(r io.Reader) (image.Config, error) {
+	header, err := readBMPHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	var model color.Model = color.NRGBAModel
+	if header.palette != nil {
+		model = header.palette
+	}
+	return image.Config{ColorModel: model, Width: header.width, Height: header.height}, nil
+}
+
+type bmpHeader struct {
+	width, height int
+	topDown       bool
+	bitsPerPixel  int
+	dataOffset    int
+	headerLen     int
+	palette       color.Palette
+	masks         [4]bmpMask
+}
+
+// bmpMask describes where a color channel is stored in a 16 or 32 bit pixel.
+type bmpMask struct {
+	mask  uint32
+	shift uint
+	max   uint32
+}
+
+func newBMPMask(mask uint32) bmpMask {
+	if mask == 0 {
+		return bmpMask{}
+	}
+	shift := uint(0)
+	for mask>>shift&1 == 0 {
+		shift++
+	}
+	return bmpMask{mask: mask, shift: shift, max: mask >> shift}
+}
+
+// extract scales the channel to 8 bits, returning fallback for missing channels.
+func (m bmpMask) extract(value uint32, fallback uint8) uint8 {
+	if m.mask == 0 {
+		return fallback
+	}
+	return uint8(uint64((value&m.mask)>>m.shift) * 0xff / uint64(m.max))
+}
+
+func readBMPHeader(reader *bufio.Reader) (bmpHeader, error) {
+	var header bmpHeader
+	fileHeader := make([]byte, 18)
+	if _, err := io.ReadFull(reader, fileHeader); err != nil {
+		return header, fmt.Errorf("invalid BMP header: %w", err)
+	}
+	if string(fileHeader[:2]) != "BM" {
+		return header, fmt.Errorf("invalid BMP header")
+	}
+	header.dataOffset = int(binary.LittleEndian.Uint32(fileHeader[10:]))
+
+	infoLen := int(binary.LittleEndian.Uint32(fileHeader[14:]))
+	if infoLen != 12 && (infoLen < 40 || infoLen > 124) {
+		return header, fmt.Errorf("unsupported BMP header size %d", infoLen)
+	}
+	info := make([]byte, infoLen)
+	copy(info, fileHeader[14:])
+	if _, err := io.ReadFull(reader, info[4:]); err != nil {
+		return header, fmt.Errorf("invalid BMP header: %w", err)
+	}
+	header.headerLen = 14 + infoLen
+
+	paletteEntrySize := 4
+	compression := uint32(0)
+	colorsUsed := 0
+	if infoLen == 12 {
+		header.width = int(binary.LittleEndian.Uint16(info[4:]))
+		header.height = int(int16(binary.LittleEndian.Uint16(info[6:])))
+		header.bitsPerPixel = int(binary.LittleEndian.Uint16(info[10:]))
+		paletteEntrySize = 3
+	} else {
+		header.width = int(int32(binary.LittleEndian.Uint32(info[4:])))
+		header.height = int(int32(binary.LittleEndian.Uint32(info[8:])))
+		header.bitsPerPixel = int(binary.LittleEndian.Uint16(info[14:]))
+		compression = binary.LittleEndian.Uint32(info[16:])
+		colorsUsed = int(binary.LittleEndian.Uint32(info[32:]))
+	}
+	if header.height < 0 {
+		header.height = -header.height
+		header.topDown = true
+	}
+	if header.width <= 0 || header.height <= 0 {
+		return header, fmt.Errorf("invalid BMP dimensions %dx%d", header.width, header.height)
+	}
+	if err := checkImageSize(header.width, header.height); err != nil {
+		return header, fmt.Errorf("BMP too large: %w", err)
+	}
+
+	switch header.bitsPerPixel {
+	case 1, 4, 8:
+		if compression != bmpRGB {
+			return header, fmt.Errorf("unsupported BMP compression %d", compression)
+		}
+		if colorsUsed == 0 || colorsUsed > 1<<uint(header.bitsPerPixel) {
+			colorsUsed = 1 << uint(header.bitsPerPixel)
+		}
+	case 16, 24, 32:
+		masks, err := readBMPMasks(reader, &header, info, compression)
+		if err != nil {
+			return header, err
+		}
+		for i, mask := range masks {
+			header.masks[i] = newBMPMask(mask)
+		}
+		colorsUsed = 0
+	default:
+		return header, fmt.Errorf("unsupported BMP bit depth %d", header.bitsPerPixel)
+	}
+
+	if colorsUsed > 0 {
+		entries := make([]byte, colorsUsed*paletteEntrySize)
+		if _, err := io.ReadFull(reader, entries); err != nil {
+			return header, fmt.Errorf("invalid BMP palette: %w", err)
+		}
+		header.headerLen += len(entries)
+		header.palette = make(color.Palette, colorsUsed)
+		for i := range header.palette {
+			entry := entries[i*paletteEntrySize:]
+			header.palette[i] = color.RGBA{entry[2], entry[1], entry[0], 0xff}
+		}
+	}
+	if header.dataOffset < header.headerLen {
+		return header, fmt.Errorf("invalid BMP pixel data offset %d", header.dataOffset)
+	}
+	return header, nil
+}
+
+const (
+	bmpRGB            = 0
+	bmpBitFields      = 3
+	bmpAlphaBitFields = 6
+)
+
+// readBMPMasks returns the red, green, blue and alpha masks of a 16, 24 or 32 bit BMP.
+func readBMPMasks(reader *bufio.Reader, header *bmpHeader, info []byte, compression uint32) ([4]uint32, error) {
+	switch {
+	case compression == bmpRGB && header.bitsPerPixel == 16:
+		return [4]uint32{0x7c00, 0x03e0, 0x001f, 0}, nil
+	case compression == bmpRGB:
+		// the fourth byte of 32 bit pixels is unused without bit fields
+		return [4]uint32{0xff0000, 0x00ff00, 0x0000ff, 0}, nil
+	case compression == bmpBitFields || compression == bmpAlphaBitFields:
+		if header.bitsPerPixel == 24 {
+			return [4]uint32{}, fmt.Errorf("unsupported BMP compression %d for 24 bit images", compression)
+		}
+		fields := 3
+		if compression == bmpAlphaBitFields {
+			fields = 4
+		}
+		raw := info[40:]
+		if len(info) == 40 {
+			raw = make([]byte, 4*fields)
+			if _, err := io.ReadFull(reader, raw); err != nil {
+				return [4]uint32{}, fmt.Errorf("invalid BMP bit fields: %w", err)
+			}
+			header.headerLen += len(raw)
+		} else if len(raw) >= 16 {
+			fields = 4
+		}
+		var masks [4]uint32
+		for i := 0; i < fields && 4*i+4 <= len(raw); i++ {
+			masks[i] = binary.LittleEndian.Uint32(raw[4*i:])
+		}
+		return masks, nil
+	default:
+		return [4]uint32{}, fmt.Errorf("unsupported BMP compression %d", compression)
+	}
+}
+
+// bmpBits returns the palette index of pixel x in a 1, 4 or 8 bit row.
+func bmpBits(row []byte, x, bitsPerPixel int) uint8 {
+	switch bitsPerPixel {
+	case 1:
+		return row[x/8] >> (7 - uint(x)%8) & 1
+	case 4:
+		return row[x/2] >> (4 * (1 - uint(x)%2)) & 0x0f
+	default:
+		return row[x]
+	}
+}
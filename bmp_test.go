@@ -0,0 +1,115 @@
+package zplgfa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// buildBMP creates a BMP file with a 40 byte info header. A negative height stores rows top-down.
+func buildBMP(width, height, bitsPerPixel int, compression uint32, extra []byte, rows [][]byte) []byte {
+	stride := (width*bitsPerPixel + 31) / 32 * 4
+	dataOffset := 14 + 40 + len(extra)
+	out := make([]byte, dataOffset, dataOffset+stride*len(rows))
+	copy(out, "BM")
+	binary.LittleEndian.PutUint32(out[10:], uint32(dataOffset))
+	binary.LittleEndian.PutUint32(out[14:], 40)
+	binary.LittleEndian.PutUint32(out[18:], uint32(int32(width)))
+	binary.LittleEndian.PutUint32(out[22:], uint32(int32(height)))
+	binary.LittleEndian.PutUint16(out[26:], 1)
+	binary.LittleEndian.PutUint16(out[28:], uint16(bitsPerPixel))
+	binary.LittleEndian.PutUint32(out[30:], compression)
+	copy(out[54:], extra)
+	for _, row := range rows {
+		padded := make([]byte, stride)
+		copy(padded, row)
+		out = append(out, padded...)
+	}
+	binary.LittleEndian.PutUint32(out[2:], uint32(len(out)))
+	return out
+}
+
+func Test_DecodeBMP(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []color.NRGBA
+	}{
+		{
+			name: "1 bit",
+			data: encodeBMP([]byte{0x80}, 1, 2, 1),
+			want: []color.NRGBA{{0, 0, 0, 0xff}, {0xff, 0xff, 0xff, 0xff}},
+		},
+		{
+			name: "4 bit top-down",
+			data: buildBMP(3, -1, 4, bmpRGB, make([]byte, 16*4), [][]byte{{0x00, 0x00}}),
+			want: []color.NRGBA{{0, 0, 0, 0xff}, {0, 0, 0, 0xff}, {0, 0, 0, 0xff}},
+		},
+		{
+			name: "24 bit",
+			data: buildBMP(2, 1, 24, bmpRGB, nil, [][]byte{{0x01, 0x02, 0x03, 0xff, 0x00, 0x80}}),
+			want: []color.NRGBA{{0x03, 0x02, 0x01, 0xff}, {0x80, 0x00, 0xff, 0xff}},
+		},
+		{
+			name: "32 bit bit fields",
+			data: buildBMP(1, 1, 32, bmpAlphaBitFields, []byte{0, 0, 0xff, 0, 0, 0xff, 0, 0, 0xff, 0, 0, 0, 0, 0, 0, 0xff}, [][]byte{{0x10, 0x20, 0x30, 0x40}}),
+			want: []color.NRGBA{{0x30, 0x20, 0x10, 0x40}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, format, err := image.DecodeConfig(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("DecodeConfig failed: %s", err)
+			}
+			if format != "bmp" || config.Width != len(tt.want) || config.Height != 1 {
+				t.Fatalf("DecodeConfig failed: got %s %dx%d", format, config.Width, config.Height)
+			}
+
+			img, _, err := image.Decode(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("Decode failed: %s", err)
+			}
+			for x, want := range tt.want {
+				if got := color.NRGBAModel.Convert(img.At(x, 0)).(color.NRGBA); got != want {
+					t.Fatalf("pixel %d failed: got %v, want %v", x, got, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_DecodeBMPBottomUp(t *testing.T) {
+	raw := []byte{0x80, 0x40}
+	img, err := DecodeBMP(bytes.NewReader(encodeBMP(raw, 1, 8, 2)))
+	if err != nil {
+		t.Fatalf("DecodeBMP failed: %s", err)
+	}
+	if packed, _ := packImage(img); !bytes.Equal(packed, raw) {
+		t.Fatalf("DecodeBMP rows failed: got % X, want % X", packed, raw)
+	}
+}
+
+func Test_DecodeBMPError(t *testing.T) {
+	if _, err := DecodeBMP(bytes.NewReader(buildBMP(1, 1, 8, 1, nil, nil))); err == nil {
+		t.Fatal("DecodeBMP should fail for RLE compression")
+	}
+	data := encodeBMP([]byte{0x80, 0x40}, 1, 8, 2)
+	if _, err := DecodeBMP(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Fatal("DecodeBMP should fail for truncated data")
+	}
+	// forged headers must fail before the pixels are allocated
+	for _, data := range [][]byte{
+		buildBMP(0x7fffffff, 0x7fffffff, 24, 0, nil, nil),
+		buildBMP(0x7fffffff, 0x7fffffff, 1, 0, make([]byte, 8), nil),
+		buildBMP(20000, 20000, 32, 0, nil, nil),
+	} {
+		if _, err := DecodeBMP(bytes.NewReader(data)); err == nil || !strings.Contains(err.Error(), "maximum") {
+			t.Fatalf("DecodeBMP failed: got %v, want a size error", err)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package zplgfa
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// CCITT run length codes from ITU-T T.4, indexed by run length for the
+// terminating codes and by run length / 64 - 1 for the makeup codes.
+var (
+	ccittWhiteTerminating = [64]string{
+		"00110101", "000111", "0111", "1000", "1011", "1100", "1110", "1111",
+		"10011", "10100", "00111", "01000", "001000", "000011", "110100", "110101",
+		"101010", "101011", "0100111", "0001100", "0001000", "0010111", "0000011", "0000100",
+		"0101000", "0101011", "0010011", "0100100", "0011000", "00000010", "00000011", "00011010",
+		"00011011", "00010010", "00010011", "00010100", "00010101", "00010110", "00010111", "00101000",
+		"00101001", "00101010", "00101011", "00101100", "00101101", "00000100", "00000101", "00001010",
+		"00001011", "01010010", "01010011", "01010100", "01010101", "00100100", "00100101", "01011000",
+		"01011001", "01011010", "01011011", "01001010", "01001011", "00110010", "00110011", "00110100",
+	}
+	ccittWhiteMakeup = [27]string{
+		"11011", "10010", "010111", "0110111", "00110110", "00110111", "01100100", "01100101",
+		"01101000", "01100111", "011001100", "011001101", "011010010", "011010011", "011010100", "011010101",
+		"011010110", "011010111", "011011000", "011011001", "011011010", "011011011", "010011000", "010011001",
+		"010011010", "011000", "010011011",
+	}
+	ccittBlackTerminating = [64]string{
+		"0000110111", "010", "11", "10", "011", "0011", "0010", "00011",
+		"000101", "000100", "0000100", "0000101", "0000111", "00000100", "00000111", "000011000",
+		"0000010111", "0000011000", "0000001000", "00001100111", "00001101000", "00001101100", "00000110111", "00000101000",
+		"00000010111", "00000011000", "000011001010", "000011001011", "000011001100", "000011001101", "000001101000", "000001101001",
+		"000001101010", "000001101011", "000011010010", "000011010011", "000011010100", "000011010101", "000011010110", "000011010111",
+		"000001101100", "000001101101", "000011011010", "000011011011", "000001010100", "000001010101", "000001010110", "000001010111",
+		"000001100100", "000001100101", "000001010010", "000001010011", "000000100100", "000000110111", "000000111000", "000000100111",
+		"000000101000", "000001011000", "000001011001", "000000101011", "000000101100", "000001011010", "000001100110", "000001100111",
+	}
+	ccittBlackMakeup = [27]string{
+		"0000001111", "000011001000", "000011001001", "000001011011", "000000110011", "000000110100", "000000110101", "0000001101100",
+		"0000001101101", "0000001001010", "0000001001011", "0000001001100", "0000001001101", "0000001110010", "0000001110011", "0000001110100",
+		"0000001110101", "0000001110110", "0000001110111", "0000001010010", "0000001010011", "0000001010100", "0000001010101", "0000001011010",
+		"0000001011011", "0000001100100", "0000001100101",
+	}
+	// ccittExtendedMakeup holds the makeup codes for 1792 to 2560 dots shared by both colors.
+	ccittExtendedMakeup = [13]string{
+		"00000001000", "00000001100", "00000001101", "000000010010", "000000010011", "000000010100", "000000010101",
+		"000000010110", "000000010111", "000000011100", "000000011101", "000000011110", "000000011111",
+	}
+)
+
+// CCITT two-dimensional coding modes from ITU-T T.4 and T.6.
+const (
+	ccittPass = iota
+	ccittHorizontal
+	ccittVertical0
+	ccittVerticalR1
+	ccittVerticalR2
+	ccittVerticalR3
+	ccittVerticalL1
+	ccittVerticalL2
+	ccittVerticalL3
+	ccittExtension
+)
+
+var ccittModeCodes = map[string]int{
+	"0001":    ccittPass,
+	"001":     ccittHorizontal,
+	"1":       ccittVertical0,
+	"011":     ccittVerticalR1,
+	"000011":  ccittVerticalR2,
+	"0000011": ccittVerticalR3,
+	"010":     ccittVerticalL1,
+	"000010":  ccittVerticalL2,
+	"0000010": ccittVerticalL3,
+	"0000001": ccittExtension,
+}
+
+// ccittVerticalOffsets maps the vertical modes to the offset of a1 from b1.
+var ccittVerticalOffsets = map[int]int{
+	ccittVertical0:  0,
+	ccittVerticalR1: 1,
+	ccittVerticalR2: 2,
+	ccittVerticalR3: 3,
+	ccittVerticalL1: -1,
+	ccittVerticalL2: -2,
+	ccittVerticalL3: -3,
+}
+
+const ccittMaxCodeLen = 13
+
+// ccittTable maps a code, keyed by its length and bits, to its value.
+type ccittTable map[uint32]int
+
+var ccittWhiteRuns, ccittBlackRuns, ccittModes ccittTable
+
+func init() {
+	ccittWhiteRuns = newCCITTRunTable(ccittWhiteTerminating[:], ccittWhiteMakeup[:])
+	ccittBlackRuns = newCCITTRunTable(ccittBlackTerminating[:], ccittBlackMakeup[:])
+	ccittModes = ccittTable{}
+	for code, mode := range ccittModeCodes {
+		ccittModes.add(code, mode)
+	}
+}
+
+func newCCITTRunTable(terminating, makeup []string) ccittTable {
+	table := ccittTable{}
+	for run, code := range terminating {
+		table.add(code, run)
+	}
+	for i, code := range makeup {
+		table.add(code, (i+1)*64)
+	}
+	for i, code := range ccittExtendedMakeup {
+		table.add(code, 1792+i*64)
+	}
+	return table
+}
+
+func (t ccittTable) add(code string, value int) {
+	var bits uint32
+	for _, c := range code {
+		bits = bits<<1 | uint32(c-'0')
+	}
+	t[uint32(len(code))<<16|bits] = value
+}
+
+// ccittReader reads CCITT codes bit by bit, most significant bit first.
+type ccittReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *ccittReader) bit() (uint32, bool) {
+	if r.pos >= len(r.data)*8 {
+		return 0, false
+	}
+	b := uint32(r.data[r.pos/8]>>(7-uint(r.pos)%8)) & 1
+	r.pos++
+	return b, true
+}
+
+func (r *ccittReader) readCode(table ccittTable) (int, error) {
+	var code uint32
+	for length := uint32(1); length <= ccittMaxCodeLen; length++ {
+		b, ok := r.bit()
+		if !ok {
+			return 0, fmt.Errorf("CCITT data too short")
+		}
+		code = code<<1 | b
+		if value, ok := table[length<<16|code]; ok {
+			return value, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid CCITT code at bit %d", r.pos)
+}
+
+// readRun reads makeup codes followed by a terminating code and returns the run length.
+func (r *ccittReader) readRun(white bool) (int, error) {
+	table := ccittBlackRuns
+	if white {
+		table = ccittWhiteRuns
+	}
+	total := 0
+	for {
+		run, err := r.readCode(table)
+		if err != nil {
+			return 0, err
+		}
+		total += run
+		if run < 64 {
+			return total, nil
+		}
+	}
+}
+
+// skipEOL consumes an end-of-line code and any fill bits before it.
+func (r *ccittReader) skipEOL() {
+	start := r.pos
+	zeros := 0
+	for {
+		b, ok := r.bit()
+		if !ok {
+			break
+		}
+		if b == 1 {
+			if zeros >= 11 {
+				return
+			}
+			break
+		}
+		zeros++
+	}
+	r.pos = start
+}
+
+func (r *ccittReader) alignByte() {
+	r.pos = (r.pos + 7) / 8 * 8
+}
+
+// decode1D decodes a one-dimensional coded row and returns its changing elements.
+func (r *ccittReader) decode1D(width int) ([]int, error) {
+	var changes []int
+	white := true
+	for pos := 0; pos < width; white = !white {
+		run, err := r.readRun(white)
+		if err != nil {
+			return nil, err
+		}
+		pos += run
+		if pos > width {
+			return nil, fmt.Errorf("CCITT row longer than %d dots", width)
+		}
+		if pos < width {
+			changes = append(changes, pos)
+		}
+	}
+	return changes, nil
+}
+
+// decode2D decodes a two-dimensional coded row against the changing elements
+// of the reference row and returns the changing elements of the decoded row.
+func (r *ccittReader) decode2D(reference []int, width int) ([]int, error) {
+	var changes []int
+	a0 := -1
+	white := true
+	for a0 < width {
+		b1, b2 := ccittReferenceChanges(reference, a0, white, width)
+		mode, err := r.readCode(ccittModes)
+		if err != nil {
+			return nil, err
+		}
+
+		switch mode {
+		case ccittPass:
+			a0 = b2
+		case ccittHorizontal:
+			start := a0
+			if start < 0 {
+				start = 0
+			}
+			run1, err := r.readRun(white)
+			if err != nil {
+				return nil, err
+			}
+			run2, err := r.readRun(!white)
+			if err != nil {
+				return nil, err
+			}
+			a1, a2 := start+run1, start+run1+run2
+			if a2 > width {
+				return nil, fmt.Errorf("CCITT row longer than %d dots", width)
+			}
+			changes = append(changes, a1, a2)
+			a0 = a2
+		case ccittExtension:
+			return nil, fmt.Errorf("unsupported CCITT uncompressed mode")
+		default:
+			a1 := b1 + ccittVerticalOffsets[mode]
+			if a1 < 0 || a1 > width || a1 < a0 {
+				return nil, fmt.Errorf("invalid CCITT vertical mode at dot %d", a1)
+			}
+			changes = append(changes, a1)
+			a0 = a1
+			white = !white
+		}
+	}
+
+	// Changing elements at the row end only end the row and are dropped so
+	// that every remaining element switches the color.
+	for len(changes) > 0 && changes[len(changes)-1] >= width {
+		changes = changes[:len(changes)-1]
+	}
+	return changes, nil
+}
+
+// ccittReferenceChanges returns b1, the first changing element of the reference
+// row right of a0 with the opposite color of a0, and the following element b2.
+func ccittReferenceChanges(reference []int, a0 int, white bool, width int) (int, int) {
+	i := 0
+	for i < len(reference) && reference[i] <= a0 {
+		i++
+	}
+	// even changing elements switch to black, odd ones back to white
+	if (i%2 == 1) == white {
+		i++
+	}
+	b1, b2 := width, width
+	if i < len(reference) {
+		b1 = reference[i]
+	}
+	if i+1 < len(reference) {
+		b2 = reference[i+1]
+	}
+	return b1, b2
+}
+
+// decodeCCITT decodes CCITT modified Huffman (TIFF compression 2), Group 3
+// (compression 3) or Group 4 (compression 4) data into packed rows with set
+// bits for black dots.
+func decodeCCITT(data []byte, width, height int, compression uint32, t4Options uint32, reverseBits bool) ([]byte, error) {
+	if reverseBits {
+		reversed := make([]byte, len(data))
+		for i, b := range data {
+			reversed[i] = bits.Reverse8(b)
+		}
+		data = reversed
+	}
+
+	bytesPerRow := (width + 7) / 8
+	raw := make([]byte, bytesPerRow*height)
+	reader := &ccittReader{data: data}
+	var reference []int
+	for y := 0; y < height; y++ {
+		var changes []int
+		var err error
+		switch compression {
+		case tiffCompressionCCITTRLE:
+			changes, err = reader.decode1D(width)
+			reader.alignByte()
+		case tiffCompressionGroup3:
+			reader.skipEOL()
+			twoDimensional := false
+			if t4Options&1 != 0 {
+				tag, ok := reader.bit()
+				if !ok {
+					return nil, fmt.Errorf("CCITT data too short")
+				}
+				twoDimensional = tag == 0
+			}
+			if twoDimensional {
+				changes, err = reader.decode2D(reference, width)
+			} else {
+				changes, err = reader.decode1D(width)
+			}
+		case tiffCompressionGroup4:
+			changes, err = reader.decode2D(reference, width)
+		default:
+			return nil, fmt.Errorf("unsupported CCITT compression %d", compression)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", y, err)
+		}
+
+		changes = cancelCCITTChanges(changes)
+		fillCCITTRow(raw[y*bytesPerRow:(y+1)*bytesPerRow], changes, width)
+		reference = changes
+	}
+	return raw, nil
+}
+
+// cancelCCITTChanges removes pairs of changing elements at the same position
+// left by zero length runs, so the reference row only holds real color changes.
+func cancelCCITTChanges(changes []int) []int {
+	out := changes[:0]
+	for _, change := range changes {
+		if len(out) > 0 && out[len(out)-1] == change {
+			out = out[:len(out)-1]
+			continue
+		}
+		out = append(out, change)
+	}
+	return out
+}
+
+// fillCCITTRow sets the bits of the black spans between pairs of changing elements.
+func fillCCITTRow(row []byte, changes []int, width int) {
+	for i := 0; i < len(changes); i += 2 {
+		end := width
+		if i+1 < len(changes) {
+			end = changes[i+1]
+		}
+		for x := changes[i]; x < end && x < width; x++ {
+			row[x/8] |= 1 << (7 - uint(x)%8)
+		}
+	}
+}
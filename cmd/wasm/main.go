@@ -37,6 +37,52 @@ func isLineOutput(s string) bool {
 	return strings.ToUpper(strings.TrimSpace(s)) == "LINES"
 }
 
+// boolOption reads a boolean property of an optional JS options object.
+func boolOption(options js.Value, name string) bool {
+	if options.Type() != js.TypeObject {
+		return false
+	}
+	value := options.Get(name)
+	return value.Type() == js.TypeBoolean && value.Bool()
+}
+
+// stringOption reads a string property of an optional JS options object.
+func stringOption(options js.Value, name string) string {
+	if options.Type() != js.TypeObject {
+		return ""
+	}
+	value := options.Get(name)
+	if value.Type() != js.TypeString {
+		return ""
+	}
+	return value.String()
+}
+
+// flattenOptions reads the background, alpha and alphaThreshold properties of an optional JS options object.
+func flattenOptions(options js.Value) (zplgfa.FlattenOptions, error) {
+	var flatten zplgfa.FlattenOptions
+	if background := stringOption(options, "background"); background != "" {
+		c, err := zplgfa.ParseHexColor(background)
+		if err != nil {
+			return flatten, err
+		}
+		flatten.Background = c
+	}
+
+	mode, err := zplgfa.ParseAlphaMode(stringOption(options, "alpha"))
+	if err != nil {
+		return flatten, err
+	}
+	flatten.Alpha = mode
+
+	if options.Type() == js.TypeObject {
+		if threshold := options.Get("alphaThreshold"); threshold.Type() == js.TypeNumber {
+			flatten.AlphaThreshold = uint8(min(max(threshold.Int(), 0), 255))
+		}
+	}
+	return flatten, flatten.Validate()
+}
+
 // jsUint8ArrayToBytes copies a JavaScript Uint8Array into a Go []byte.
 func jsUint8ArrayToBytes(arr js.Value) []byte {
 	length := arr.Get("length").Int()
@@ -54,7 +100,9 @@ func makeError(format string, a ...interface{}) map[string]interface{} {
 
 // convertImage is the main entry point exported to JavaScript.
 //
-// JS signature: zplgfaConvert(bytes: Uint8Array, graphicType?: string), returning {zpl, width, height} or {error}.
+// JS signature: zplgfaConvert(bytes: Uint8Array, graphicType?: string, options?: {ignoreOrientation?: boolean, filters?: string,
+// background?: string, alpha?: string, alphaThreshold?: number}),
+// returning {zpl, width, height, orientation} or {error}.
 func convertImage(this js.Value, args []js.Value) interface{} {
 	if len(args) < 1 {
 		return makeError("zplgfaConvert: expected at least one argument (Uint8Array)")
@@ -78,32 +126,52 @@ func convertImage(this js.Value, args []js.Value) interface{} {
 		}
 	}
 
-	var zpl string
-	if lines {
-		img, _, err := image.Decode(bytes.NewReader(data))
-		if err != nil {
-			return makeError("zplgfaConvert: %s", err)
-		}
-		zpl = zplgfa.ConvertToZPLLines(zplgfa.FlattenImage(img))
-	} else {
-		var err error
-		zpl, err = zplgfa.ConvertReaderToZPL(bytes.NewReader(data), gt)
-		if err != nil {
-			return makeError("zplgfaConvert: %s", err)
+	var options js.Value
+	if len(args) >= 3 && args[2].Type() == js.TypeObject {
+		options = args[2]
+	}
+
+	filters, err := zplgfa.ParseFilters(stringOption(options, "filters"))
+	if err != nil {
+		return makeError("zplgfaConvert: %s", err)
+	}
+	flatten, err := flattenOptions(options)
+	if err != nil {
+		return makeError("zplgfaConvert: %s", err)
+	}
+
+	// Multi-page TIFF files are converted to one label per page.
+	pages, _, err := zplgfa.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return makeError("zplgfaConvert: %s", err)
+	}
+
+	orientation := zplgfa.OrientationNormal
+	if !boolOption(options, "ignoreOrientation") {
+		if o, err := zplgfa.ReadOrientation(bytes.NewReader(data)); err == nil {
+			orientation = o
 		}
 	}
 
-	// Decode the config again purely to expose width/height to the UI;
-	// ignore errors here because the conversion above already succeeded.
-	width, height := 0, 0
-	if cfg, _, cerr := image.DecodeConfig(bytes.NewReader(data)); cerr == nil {
-		width, height = cfg.Width, cfg.Height
+	var zpl strings.Builder
+	for i, page := range pages {
+		pages[i] = filters.Apply(zplgfa.ApplyOrientation(page, orientation))
+		flat := zplgfa.FlattenImageWithOptions(pages[i], flatten)
+		if lines {
+			zpl.WriteString(zplgfa.ConvertToZPLLines(flat))
+		} else {
+			zpl.WriteString(zplgfa.ConvertToZPL(flat, gt))
+		}
 	}
 
+	// Expose the size of the first page to the UI.
+	width, height := pages[0].Bounds().Dx(), pages[0].Bounds().Dy()
+
 	return map[string]interface{}{
-		"zpl":    zpl,
-		"width":  width,
-		"height": height,
+		"zpl":         zpl.String(),
+		"width":       width,
+		"height":      height,
+		"orientation": orientation.String(),
 	}
 }
 
@@ -111,10 +179,12 @@ func convertImage(this js.Value, args []js.Value) interface{} {
 // plus width and height, allowing the in-browser editor to send canvas pixels
 // directly without re-encoding to PNG first.
 //
-// JS signature: zplgfaConvertRGBA(rgba: Uint8Array, width: number, height: number, graphicType?: string), returning {zpl, width, height} or {error}.
+// JS signature: zplgfaConvertRGBA(rgba: Uint8Array, width: number, height: number, graphicType?: string,
+// options?: {filters?: string, background?: string, alpha?: string, alphaThreshold?: number}),
+// returning {zpl, width, height} or {error}.
 func convertRGBA(this js.Value, args []js.Value) interface{} {
 	if len(args) < 3 {
-		return makeError("zplgfaConvertRGBA: expected (rgba, width, height[, graphicType])")
+		return makeError("zplgfaConvertRGBA: expected (rgba, width, height[, graphicType[, options]])")
 	}
 	width := args[1].Int()
 	height := args[2].Int()
@@ -145,7 +215,21 @@ func convertRGBA(this js.Value, args []js.Value) interface{} {
 		}
 	}
 
-	flat := zplgfa.FlattenImage(img)
+	var options js.Value
+	if len(args) >= 5 && args[4].Type() == js.TypeObject {
+		options = args[4]
+	}
+	filters, err := zplgfa.ParseFilters(stringOption(options, "filters"))
+	if err != nil {
+		return makeError("zplgfaConvertRGBA: %s", err)
+	}
+	flatten, err := flattenOptions(options)
+	if err != nil {
+		return makeError("zplgfaConvertRGBA: %s", err)
+	}
+
+	flat := zplgfa.FlattenImageWithOptions(filters.Apply(img), flatten)
+	width, height = flat.Bounds().Dx(), flat.Bounds().Dy()
 	var zpl string
 	if lines {
 		zpl = zplgfa.ConvertToZPLLines(flat)
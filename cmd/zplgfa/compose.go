@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"simonwaldherr.de/go/zplgfa"
+)
+
+// mergeMain runs "zplgfa merge", which combines ZPL files into one format and returns the exit code.
+// A file written as name@x,y is moved by x,y dots first.
+func mergeMain(args []string) int {
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	output := flags.String("out", "", "write the merged ZPL to this file instead of the standard output")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: zplgfa merge [-out merged.zpl] label.zpl logo.zpl@600,40 ...\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 1
+	}
+
+	var documents []string
+	for _, arg := range flags.Args() {
+		filename, offset, moved := arg, "", false
+		if _, err := os.Stat(arg); err != nil {
+			if i := strings.LastIndex(arg, "@"); i != -1 {
+				filename, offset, moved = arg[:i], arg[i+1:], true
+			}
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			log.Printf("Warning: could not read the file \"%s\": %s\n", filename, err)
+			return 1
+		}
+		zpl := string(data)
+		if moved {
+			var options zplgfa.TranslateOptions
+			if options.Offset, err = parseOffset(offset); err != nil {
+				log.Printf("Warning: %s\n", err)
+				return 1
+			}
+			result, err := zplgfa.TranslateZPL(zpl, options)
+			if err != nil {
+				log.Printf("Warning: %s\nin \"%s\"\n", errorMessage(err), filename)
+				return 1
+			}
+			zpl = result.ZPL
+		}
+		documents = append(documents, zpl)
+	}
+	return writeComposed(*output, zplgfa.MergeZPL(documents...))
+}
+
+// nupMain runs "zplgfa nup", which lays out the labels of ZPL files side by side on wide media and returns the
+// exit code.
+func nupMain(args []string) int {
+	flags := flag.NewFlagSet("nup", flag.ExitOnError)
+	output := flags.String("out", "", "write the ZPL to this file instead of the standard output")
+	var options zplgfa.NUpOptions
+	flags.IntVar(&options.Columns, "columns", 2, "number of labels side by side")
+	flags.IntVar(&options.Width, "width", 0, "width of a label in dots, 0 uses the widest ^PW of the labels")
+	flags.IntVar(&options.Gap, "gap", 0, "space between the labels in dots")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: zplgfa nup [-columns n] [-width dots] [-gap dots] [-out nup.zpl] labels.zpl ...\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 1
+	}
+
+	var zpl strings.Builder
+	for _, filename := range flags.Args() {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			log.Printf("Warning: could not read the file \"%s\": %s\n", filename, err)
+			return 1
+		}
+		zpl.Write(data)
+	}
+	result, err := zplgfa.NUpZPL(zpl.String(), options)
+	if err != nil {
+		log.Printf("Warning: %s\n", errorMessage(err))
+		return 1
+	}
+	return writeComposed(*output, result)
+}
+
+// writeComposed writes zpl to the file output, or to the standard output if it is empty.
+func writeComposed(output, zpl string) int {
+	if output == "" {
+		fmt.Print(zpl)
+		return 0
+	}
+	if err := os.WriteFile(output, []byte(zpl), 0644); err != nil {
+		log.Printf("Warning: %s\n", err)
+		return 1
+	}
+	return 0
+}
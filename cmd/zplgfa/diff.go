@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"simonwaldherr.de/go/zplgfa"
+)
+
+// diffMain runs "zplgfa diff", which compares two ZPL files. Like diff it returns 0 for equal files,
+// 1 for different ones and 2 if they can't be compared.
+func diffMain(args []string) int {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	output := flags.String("out", "", "write an image of the added and removed dots, numbered if there are several labels")
+	contextLines := flags.Int("context", 2, "number of unchanged commands shown around changed ones")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: zplgfa diff [-out diff.png] [-context n] a.zpl b.zpl\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	var documents [2]string
+	for i, filename := range flags.Args() {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			log.Printf("Warning: could not read the file \"%s\": %s\n", filename, err)
+			return 2
+		}
+		documents[i] = string(data)
+	}
+	diff, err := zplgfa.DiffZPL(documents[0], documents[1])
+	if err != nil {
+		log.Printf("Warning: %s\n", diffErrorMessage(documents, flags.Args(), err))
+		return 2
+	}
+
+	for _, label := range diff.Labels {
+		fmt.Printf("label %d: %d dots added, %d removed, %.2f%% of %dx%d dots\n", label.Label+1, label.Added, label.Removed,
+			label.Percent(), label.Image.Rect.Dx(), label.Image.Rect.Dy())
+		if *output == "" {
+			continue
+		}
+		if err := writeImageFile(pageFilename(*output, label.Label, len(diff.Labels)), label.Image); err != nil {
+			log.Printf("Warning: %s\n", err)
+			return 2
+		}
+	}
+	fmt.Print(diff.CommandDiff(*contextLines))
+	if diff.Equal() {
+		return 0
+	}
+	return 1
+}
+
+// diffErrorMessage describes an error of comparing documents, with its location in the document it belongs to.
+func diffErrorMessage(documents [2]string, filenames []string, err error) string {
+	for i, document := range documents {
+		if _, renderErr := zplgfa.RenderLabelsContext(context.Background(), document, zplgfa.Limits{}); renderErr != nil {
+			message := errorMessage(renderErr)
+			if location := errorLocation(document, renderErr); location != "" {
+				message += fmt.Sprintf("\nin \"%s\", %s", filenames[i], location)
+			}
+			return message
+		}
+	}
+	return errorMessage(err)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"simonwaldherr.de/go/zplgfa"
+)
+
+// formatMain runs "zplgfa fmt", which formats the ZPL files given as arguments or the standard input
+// and returns the exit code.
+func formatMain(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	keep := flags.Bool("keep", false, "keep the data of graphic fields instead of a summary of its size")
+	write := flags.Bool("w", false, "write the result to the files instead of the standard output, graphic data is kept")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: zplgfa fmt [-keep] [-w] [file ...]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	options := zplgfa.FormatOptions{KeepGraphics: *keep || *write}
+
+	if flags.NArg() == 0 {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Printf("Warning: could not read the standard input: %s\n", err)
+			return 1
+		}
+		fmt.Print(zplgfa.FormatZPL(string(data), options))
+		return 0
+	}
+
+	code := 0
+	for _, filename := range flags.Args() {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			log.Printf("Warning: could not read the file \"%s\": %s\n", filename, err)
+			code = 1
+			continue
+		}
+		formatted := zplgfa.FormatZPL(string(data), options)
+		if !*write {
+			fmt.Print(formatted)
+			continue
+		}
+		if formatted == string(data) {
+			continue
+		}
+		if err := os.WriteFile(filename, []byte(formatted), 0644); err != nil {
+			log.Printf("Warning: %s\n", err)
+			code = 1
+		}
+	}
+	return code
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
@@ -8,12 +11,13 @@ import (
 	_ "image/jpeg"
 	"image/png"
 	"log"
+	"math"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/anthonynsimon/bild/blur"
-	"github.com/anthonynsimon/bild/effect"
-	"github.com/anthonynsimon/bild/segment"
 	"github.com/nfnt/resize"
 
 	"simonwaldherr.de/go/zplgfa"
@@ -59,65 +63,135 @@ func handleZebraCommands(cmd, ip, port string) bool {
 	return false
 }
 
-func parseFlags() (string, string, string, string, string, string, string, float64, bool, bool) {
-	var filename, zebraCmd, graphicType, imageEdit, ip, port, output string
-	var resizeFactor float64
-	var lines, decode bool
+// cliOptions holds the parsed command line flags.
+type cliOptions struct {
+	filename, zebraCmd, graphicType, imageEdit, ip, port, output, frames, morph, resize, hybridMask, background, alpha, serve, transcode, dpi, offset string
+	thin, alphaThreshold, workers                                                                                                                     int
+	maxPixels, maxOutput, maxInflate, maxBody                                                                                                         int64
+	timeout                                                                                                                                           time.Duration
+	lines, decode, all, exif, hybrid, optimize, clip                                                                                                  bool
+}
+
+func parseFlags() cliOptions {
+	var opts cliOptions
 
-	flag.StringVar(&filename, "file", "", "filename to convert to zpl")
-	flag.StringVar(&zebraCmd, "cmd", "", "send special command to printer [cancel,calib,feed,info,config,diag]")
-	flag.StringVar(&graphicType, "type", "CompressedASCII", "type of graphic field encoding [ASCII,Binary,CompressedASCII,Z64]")
-	flag.StringVar(&imageEdit, "edit", "", "manipulate the image [invert,monochrome]")
-	flag.StringVar(&ip, "ip", "", "send zpl to printer")
-	flag.StringVar(&port, "port", "9100", "network port of printer")
-	flag.StringVar(&output, "out", "", "output filename for decoded PNG")
-	flag.Float64Var(&resizeFactor, "resize", 1.0, "zoom/resize the image")
-	flag.BoolVar(&lines, "lines", false, "output black pixel runs as ZPL line commands instead of a graphic field")
-	flag.BoolVar(&decode, "decode", false, "convert a ZPL file containing a ^GF field to PNG")
+	flag.StringVar(&opts.filename, "file", "", "filename to convert to zpl")
+	flag.StringVar(&opts.zebraCmd, "cmd", "", "send special command to printer [cancel,calib,feed,info,config,diag]")
+	flag.StringVar(&opts.graphicType, "type", "CompressedASCII", "type of graphic field encoding [ASCII,Binary,CompressedASCII,Z64,B64,DPL,DPLBMP,DPLPCX]")
+	flag.StringVar(&opts.imageEdit, "edit", "", "comma separated image filters, e.g. invert,blur:2 [invert,monochrome,segment,blur,edge,sharpen,brightness,contrast,gamma,crop,pad]")
+	flag.StringVar(&opts.ip, "ip", "", "send zpl to printer")
+	flag.StringVar(&opts.port, "port", "9100", "network port of printer")
+	flag.StringVar(&opts.output, "out", "", "output filename, .grf and .pcx files are written as such, other images as PNG")
+	flag.StringVar(&opts.frames, "frames", "", "convert every frame of an animated GIF [labels,dg]")
+	flag.StringVar(&opts.morph, "morph", "", "morphological operations on the bitmap, e.g. despeckle,close:1:disk [erode,dilate,open,close,despeckle]")
+	flag.StringVar(&opts.background, "background", "ffffff", "background color behind transparent pixels")
+	flag.StringVar(&opts.alpha, "alpha", "legacy", "handling of transparent pixels [legacy,composite,mask]")
+	flag.IntVar(&opts.alphaThreshold, "alphathreshold", 0, "alpha value above which pixels print black with -alpha mask")
+	flag.BoolVar(&opts.hybrid, "hybrid", false, "dither photos and threshold text, line art and barcodes within the same image")
+	flag.StringVar(&opts.hybridMask, "hybridmask", "", "write the regions found by -hybrid to this PNG file, black for dithered regions")
+	flag.IntVar(&opts.thin, "thin", 0, "thin black lines by this many dots to compensate for dot gain")
+	flag.IntVar(&opts.workers, "workers", 1, "encode the rows of large graphics on this many goroutines, -1 uses all CPUs")
+	flag.StringVar(&opts.resize, "resize", "1", "zoom/resize the image by a factor, append :bilevel to keep the bars of barcodes, e.g. 1.5:bilevel")
+	flag.BoolVar(&opts.lines, "lines", false, "output black pixel runs as ZPL line commands instead of a graphic field")
+	flag.BoolVar(&opts.decode, "decode", false, "convert a ZPL file containing a ^GF field to PNG")
+	flag.BoolVar(&opts.all, "all", false, "with -decode, write every ^GF field to numbered files named after -out or the input file")
+	flag.BoolVar(&opts.exif, "exif", true, "rotate or mirror the image according to its EXIF orientation")
+	flag.Int64Var(&opts.maxPixels, "maxpixels", 0, "reject images with more pixels, 0 means no limit")
+	flag.Int64Var(&opts.maxOutput, "maxoutput", 0, "stop when the ZPL output gets larger than this many bytes, 0 means no limit")
+	flag.Int64Var(&opts.maxInflate, "maxinflate", 0, "stop decoding Z64 data that inflates to more than this many bytes, 0 means no limit")
+	flag.Int64Var(&opts.maxBody, "maxbody", 32<<20, "with -serve, reject request bodies larger than this many bytes, 0 means no limit")
+	flag.DurationVar(&opts.timeout, "timeout", 0, "stop conversions that take longer, e.g. 10s, 0 means no limit")
+	flag.StringVar(&opts.serve, "serve", "", "serve conversions over HTTP on this address, e.g. :8080")
+	flag.BoolVar(&opts.optimize, "optimize", false, "remove comments, whitespace and redundant commands from a ZPL file and merge its boxes")
+	flag.StringVar(&opts.dpi, "dpi", "", "rewrite a ZPL file made for one resolution for another one, e.g. 203:300")
+	flag.StringVar(&opts.offset, "offset", "", "move the fields of a ZPL file by x,y dots, e.g. 40,0")
+	flag.BoolVar(&opts.clip, "clip", false, "remove the fields of a ZPL file outside of ^PW and ^LL and crop graphics at the border")
+	flag.StringVar(&opts.transcode, "transcode", "", "rewrite the ^GF fields of a ZPL file with another encoding [ASCII,Binary,CompressedASCII,Z64,B64,smallest]")
 
 	flag.Parse()
-	return filename, zebraCmd, graphicType, imageEdit, ip, port, output, resizeFactor, lines, decode
+	return opts
 }
 
-func openImageFile(filename string) (image.Image, image.Config, error) {
-	file, err := os.Open(filename)
+func openImageFile(filename string, exif, frames bool, limits zplgfa.Limits) ([]image.Image, error) {
+	data, err := os.ReadFile(filename)
 	if err != nil {
-		return nil, image.Config{}, fmt.Errorf("could not open the file \"%s\": %s", filename, err)
+		return nil, fmt.Errorf("could not open the file \"%s\": %s", filename, err)
+	}
+	if err := limits.CheckImage(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("could not open the file \"%s\": %s", filename, errorMessage(err))
 	}
-	defer file.Close()
 
-	config, format, err := image.DecodeConfig(file)
+	pages, format, err := zplgfa.DecodeAll(bytes.NewReader(data))
 	if err != nil {
-		return nil, config, fmt.Errorf("image not compatible, format: %s, config: %v, error: %s", format, config, err)
+		return nil, fmt.Errorf("could not decode the file, format: %s, error: %s", format, err)
 	}
 
-	file.Seek(0, 0)
-	img, _, err := image.Decode(file)
-	if err != nil {
-		return nil, config, fmt.Errorf("could not decode the file, %s", err)
+	if frames && format == "gif" {
+		pages, err = zplgfa.DecodeGIFFrames(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("could not decode the GIF frames, error: %s", err)
+		}
+	}
+
+	if exif {
+		orientation, err := zplgfa.ReadOrientation(bytes.NewReader(data))
+		if err != nil {
+			log.Printf("Warning: ignoring EXIF orientation, %s\n", err)
+		} else if orientation != zplgfa.OrientationNormal {
+			log.Printf("Info: applying EXIF orientation: %s\n", orientation)
+			for i, page := range pages {
+				pages[i] = zplgfa.ApplyOrientation(page, orientation)
+			}
+		}
 	}
 
-	return img, config, nil
+	return pages, nil
 }
 
-func processImage(img image.Image, editFlag string, resizeFactor float64, config image.Config) image.Image {
-	if strings.Contains(editFlag, "monochrome") {
-		img = editImageMonochrome(img)
+// parseResize parses the -resize flag, a factor optionally followed by ":bilevel" or ":smooth".
+func parseResize(value string) (float64, zplgfa.Resampling, error) {
+	factorValue, mode, _ := strings.Cut(value, ":")
+	factor, err := strconv.ParseFloat(factorValue, 64)
+	if err != nil || factor <= 0 {
+		return 0, 0, fmt.Errorf("invalid resize factor \"%s\"", factorValue)
 	}
-	if strings.Contains(editFlag, "blur") {
-		img = blur.Gaussian(img, float64(config.Width)/300)
+	switch strings.ToLower(mode) {
+	case "", "smooth":
+		return factor, zplgfa.ResampleSmooth, nil
+	case "bilevel":
+		return factor, zplgfa.ResampleBilevel, nil
+	default:
+		return 0, 0, fmt.Errorf("unknown resampling \"%s\"", mode)
 	}
-	if strings.Contains(editFlag, "edge") {
-		img = effect.Sobel(img)
+}
+
+func parseFlattenOptions(background, alpha string, threshold int) (zplgfa.FlattenOptions, error) {
+	bg, err := zplgfa.ParseHexColor(background)
+	if err != nil {
+		return zplgfa.FlattenOptions{}, err
+	}
+	mode, err := zplgfa.ParseAlphaMode(alpha)
+	if err != nil {
+		return zplgfa.FlattenOptions{}, err
 	}
-	if strings.Contains(editFlag, "segment") {
-		img = segment.Threshold(img, 128)
+	if err := (zplgfa.FlattenOptions{Background: bg, Alpha: mode}).Validate(); err != nil {
+		return zplgfa.FlattenOptions{}, fmt.Errorf("-alpha legacy always blends onto white, use -alpha composite or mask with -background %s", background)
 	}
-	if strings.Contains(editFlag, "invert") {
-		img = editImageInvert(img)
+	if threshold < 0 || threshold > 255 {
+		return zplgfa.FlattenOptions{}, fmt.Errorf("alpha threshold %d is out of range 0-255", threshold)
 	}
+	return zplgfa.FlattenOptions{Background: bg, Alpha: mode, AlphaThreshold: uint8(threshold)}, nil
+}
 
-	if resizeFactor != 1.0 {
+func processImage(img image.Image, filters zplgfa.Filter, resizeFactor float64, resampling zplgfa.Resampling, flatten zplgfa.FlattenOptions) image.Image {
+	img = filters.Apply(img)
+
+	if resizeFactor != 1.0 && resampling == zplgfa.ResampleBilevel {
+		width := max(int(math.Round(float64(img.Bounds().Dx())*resizeFactor)), 1)
+		height := max(int(math.Round(float64(img.Bounds().Dy())*resizeFactor)), 1)
+		img = zplgfa.ResizeBilevel(zplgfa.FlattenImageWithOptions(img, flatten), width, height)
+	} else if resizeFactor != 1.0 {
+		config := image.Config{Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}
 		img = resize.Resize(uint(float64(config.Width)*resizeFactor), uint(float64(config.Height)*resizeFactor), img, resize.MitchellNetravali)
 	}
 
@@ -134,67 +208,394 @@ func getGraphicType(typeFlag string) zplgfa.GraphicType {
 		return zplgfa.CompressedASCII
 	case "Z64":
 		return zplgfa.Z64
+	case "B64":
+		return zplgfa.B64
 	default:
 		return zplgfa.CompressedASCII
 	}
 }
 
-func decodeZPLFile(filename, output string) error {
+func getDPLFormat(typeFlag string) (zplgfa.DPLFormat, bool) {
+	switch strings.ToUpper(typeFlag) {
+	case "DPL":
+		return zplgfa.DPLImage, true
+	case "DPLBMP":
+		return zplgfa.DPLBMP, true
+	case "DPLPCX":
+		return zplgfa.DPLPCX, true
+	default:
+		return 0, false
+	}
+}
+
+func decodeZPLFile(ctx context.Context, filename, output string, limits zplgfa.Limits) error {
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return fmt.Errorf("could not read the file \"%s\": %s", filename, err)
 	}
-	img, err := zplgfa.ConvertZPLToImage(string(data))
+	img, err := zplgfa.ConvertZPLToImageContext(ctx, string(data), limits)
 	if err != nil {
-		return err
+		if location := errorLocation(string(data), err); location != "" {
+			return fmt.Errorf("%s\nin \"%s\", %s", errorMessage(err), filename, location)
+		}
+		return errors.New(errorMessage(err))
 	}
 	if output == "" {
 		return png.Encode(os.Stdout, img)
 	}
-	file, err := os.Create(output)
+	return writeImageFile(output, img)
+}
+
+// extractZPLFile writes every ^GF field of a ZPL file to a numbered image file and logs what it found.
+// The files are named after output, or after filename with the .png extension if output is empty.
+func extractZPLFile(ctx context.Context, filename, output string, limits zplgfa.Limits) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("could not read the file \"%s\": %s", filename, err)
+	}
+	fields, err := zplgfa.ScanGraphicFieldsContext(ctx, string(data), limits)
+	if err != nil {
+		return errors.New(errorMessage(err))
+	}
+	if len(fields) == 0 {
+		return fmt.Errorf("no ^GF field found in \"%s\"", filename)
+	}
+
+	if output == "" {
+		output = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".png"
+	}
+	ext := filepath.Ext(output)
+	for _, field := range fields {
+		position := "without position"
+		if field.OriginCommand != "" {
+			position = fmt.Sprintf("at ^%s%d,%d", field.OriginCommand, field.Origin.X, field.Origin.Y)
+		}
+		log.Printf("Info: field %d of label %d: %s %dx%d %s, %d data bytes, %d declared, bytes %d-%d\n",
+			field.Index+1, field.Label+1, field.Encoding, field.Width, field.Height, position, field.DataBytes, field.DeclaredBytes, field.Offset, field.End)
+		if field.Err != nil {
+			message := errorMessage(field.Err)
+			if location := errorLocation(string(data), field.Err); location != "" {
+				message += "\n" + location
+			}
+			log.Printf("Warning: %s\n", message)
+			continue
+		}
+		name := fmt.Sprintf("%s-%d%s", strings.TrimSuffix(output, ext), field.Index+1, ext)
+		if err := writeImageFile(name, field.Image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transcodeTypes returns the encodings for the -transcode flag, smallest picks the smaller of CompressedASCII and Z64.
+func transcodeTypes(name string) ([]zplgfa.GraphicType, error) {
+	switch strings.ToUpper(name) {
+	case "SMALLEST":
+		return []zplgfa.GraphicType{zplgfa.CompressedASCII, zplgfa.Z64}, nil
+	case "ASCII", "BINARY", "COMPRESSEDASCII", "Z64", "B64":
+		return []zplgfa.GraphicType{getGraphicType(name)}, nil
+	}
+	return nil, fmt.Errorf("unknown encoding \"%s\" for -transcode", name)
+}
+
+// parseDPI returns the resolutions of the -dpi flag, written as from:to.
+func parseDPI(value string) (int, int, error) {
+	from, to, ok := strings.Cut(value, ":")
+	fromDPI, fromErr := strconv.Atoi(from)
+	toDPI, toErr := strconv.Atoi(to)
+	if !ok || fromErr != nil || toErr != nil {
+		return 0, 0, fmt.Errorf("invalid resolutions \"%s\" for -dpi, e.g. 203:300", value)
+	}
+	return fromDPI, toDPI, nil
+}
+
+// parseOffset returns the x,y dots of the -offset flag.
+func parseOffset(value string) (image.Point, error) {
+	x, y, ok := strings.Cut(value, ",")
+	dx, xErr := strconv.Atoi(strings.TrimSpace(x))
+	dy, yErr := strconv.Atoi(strings.TrimSpace(y))
+	if !ok || xErr != nil || yErr != nil {
+		return image.Point{}, fmt.Errorf("invalid offset \"%s\", e.g. 40,0", value)
+	}
+	return image.Pt(dx, dy), nil
+}
+
+// rewriteZPLFile scales a ZPL file for the -dpi resolution, moves and clips its fields with -offset and -clip,
+// transcodes its ^GF fields with the -transcode encoding and optimizes it with -optimize, logging what changed.
+func rewriteZPLFile(ctx context.Context, opts cliOptions, limits zplgfa.Limits) error {
+	data, err := os.ReadFile(opts.filename)
+	if err != nil {
+		return fmt.Errorf("could not read the file \"%s\": %s", opts.filename, err)
+	}
+	zpl := string(data)
+	// errors are located in the file as long as no step has changed it
+	locate := func(err error) error {
+		message := errorMessage(err)
+		if zpl != string(data) {
+			return errors.New(message)
+		}
+		if location := errorLocation(string(data), err); location != "" {
+			message += fmt.Sprintf("\nin \"%s\", %s", opts.filename, location)
+		}
+		return errors.New(message)
+	}
+
+	if opts.dpi != "" {
+		fromDPI, toDPI, err := parseDPI(opts.dpi)
+		if err != nil {
+			return err
+		}
+		retargeted, err := zplgfa.RetargetZPLContext(ctx, zpl, fromDPI, toDPI, limits)
+		if err != nil {
+			return locate(err)
+		}
+		zpl = retargeted
+		log.Printf("Info: scaled from %d to %d dpi\n", fromDPI, toDPI)
+	}
+	if opts.offset != "" || opts.clip {
+		var options zplgfa.TranslateOptions
+		if opts.offset != "" {
+			if options.Offset, err = parseOffset(opts.offset); err != nil {
+				return err
+			}
+		}
+		options.Clip = opts.clip
+		result, err := zplgfa.TranslateZPLContext(ctx, zpl, options, limits)
+		if err != nil {
+			return locate(err)
+		}
+		log.Printf("Info: moved %d fields, cropped %d and removed %d\n", result.Moved, result.Cropped, result.Removed)
+		zpl = result.ZPL
+	}
+	if opts.transcode != "" {
+		graphicTypes, err := transcodeTypes(opts.transcode)
+		if err != nil {
+			return err
+		}
+		result, err := zplgfa.TranscodeGraphicFieldsContext(ctx, zpl, limits, graphicTypes...)
+		if err != nil {
+			return locate(err)
+		}
+		for _, field := range result.Fields {
+			log.Printf("Info: field %d: %s %d bytes -> %s %d bytes\n", field.Index+1, field.From, field.Before, field.To, field.After)
+		}
+		zpl = result.ZPL
+	}
+	if opts.optimize {
+		result, err := zplgfa.OptimizeZPLContext(ctx, zpl, zplgfa.OptimizeOptions{Verify: true}, limits)
+		if err != nil {
+			return locate(err)
+		}
+		log.Printf("Info: removed %d comments and %d redundant commands, merged %d boxes\n", result.Comments, result.Commands, result.Boxes)
+		zpl = result.ZPL
+	}
+	log.Printf("Info: %d bytes -> %d bytes, saved %d bytes\n", len(data), len(zpl), len(data)-len(zpl))
+	writeZPL(opts, zpl)
+	return nil
+}
+
+func isImageFile(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".png", ".grf", ".pcx":
+		return true
+	default:
+		return false
+	}
+}
+
+func writeImageFile(filename string, img image.Image) error {
+	file, err := os.Create(filename)
 	if err != nil {
-		return fmt.Errorf("could not create the file \"%s\": %s", output, err)
+		return fmt.Errorf("could not create the file \"%s\": %s", filename, err)
 	}
 	defer file.Close()
-	return png.Encode(file, img)
+
+	ext := filepath.Ext(filename)
+	switch strings.ToLower(ext) {
+	case ".grf":
+		return zplgfa.EncodeGRF(file, img, strings.TrimSuffix(filepath.Base(filename), ext))
+	case ".pcx":
+		return zplgfa.EncodePCX(file, img)
+	default:
+		return png.Encode(file, img)
+	}
+}
+
+// pageFilename numbers the output files of multi-page input, e.g. label-2.png for the second page.
+func pageFilename(filename string, page, pages int) string {
+	if pages == 1 {
+		return filename
+	}
+	ext := filepath.Ext(filename)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(filename, ext), page+1, ext)
+}
+
+func convertPage(ctx context.Context, flat image.Image, graphicTypeFlag string, lines bool, options zplgfa.ConvertOptions) (string, error) {
+	if format, ok := getDPLFormat(graphicTypeFlag); ok {
+		return zplgfa.ConvertToDPLLabel(flat, zplgfa.DPLOptions{Format: format})
+	}
+	if lines {
+		return zplgfa.ConvertToZPLLines(flat), nil
+	}
+	if flat.Bounds().Empty() {
+		return "", nil
+	}
+	options.GraphicType = getGraphicType(graphicTypeFlag)
+	zpl, err := zplgfa.ConvertToZPLContext(ctx, flat, options)
+	if err != nil {
+		return "", errors.New(errorMessage(err))
+	}
+	return zpl, nil
 }
 
 func main() {
-	filename, zebraCmd, graphicTypeFlag, imageEdit, ip, port, output, resizeFactor, lines, decode := parseFlags()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "fmt":
+			os.Exit(formatMain(os.Args[2:]))
+		case "diff":
+			os.Exit(diffMain(os.Args[2:]))
+		case "merge":
+			os.Exit(mergeMain(os.Args[2:]))
+		case "nup":
+			os.Exit(nupMain(os.Args[2:]))
+		}
+	}
+	opts := parseFlags()
 
-	if handleZebraCommands(zebraCmd, ip, port) && filename == "" {
+	if handleZebraCommands(opts.zebraCmd, opts.ip, opts.port) && opts.filename == "" {
 		return
 	}
 
-	if filename == "" {
+	limits := zplgfa.Limits{MaxPixels: opts.maxPixels, MaxOutputBytes: opts.maxOutput, MaxInflatedBytes: opts.maxInflate}
+	ctx := context.Background()
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+
+	if opts.serve == "" && opts.filename == "" {
 		log.Printf("Warning: no input file specified\n")
 		return
 	}
 
-	if decode {
-		if err := decodeZPLFile(filename, output); err != nil {
+	if opts.dpi != "" || opts.offset != "" || opts.clip || opts.transcode != "" || opts.optimize {
+		if err := rewriteZPLFile(ctx, opts, limits); err != nil {
+			log.Printf("Warning: %s\n", err)
+		}
+		return
+	}
+	if opts.decode && opts.all {
+		if err := extractZPLFile(ctx, opts.filename, opts.output, limits); err != nil {
 			log.Printf("Warning: %s\n", err)
 		}
 		return
 	}
+	if opts.decode {
+		if err := decodeZPLFile(ctx, opts.filename, opts.output, limits); err != nil {
+			log.Printf("Warning: %s\n", err)
+		}
+		return
+	}
+
+	filters, err := zplgfa.ParseFilters(opts.imageEdit)
+	if err != nil {
+		log.Printf("Warning: %s\n", err)
+		return
+	}
 
-	img, config, err := openImageFile(filename)
+	resizeFactor, resampling, err := parseResize(opts.resize)
 	if err != nil {
 		log.Printf("Warning: %s\n", err)
 		return
 	}
 
-	img = processImage(img, imageEdit, resizeFactor, config)
+	flatten, err := parseFlattenOptions(opts.background, opts.alpha, opts.alphaThreshold)
+	if err != nil {
+		log.Printf("Warning: %s\n", err)
+		return
+	}
 
-	flat := zplgfa.FlattenImage(img)
-	gfimg := zplgfa.ConvertToZPL(flat, getGraphicType(graphicTypeFlag))
-	if lines {
-		gfimg = zplgfa.ConvertToZPLLines(flat)
+	morphology, err := zplgfa.ParseMorphology(opts.morph)
+	if err != nil {
+		log.Printf("Warning: %s\n", err)
+		return
+	}
+	options := zplgfa.ConvertOptions{Morphology: morphology, ThinBlack: opts.thin, Workers: opts.workers, Limits: limits}
+
+	if opts.serve != "" {
+		options.GraphicType = getGraphicType(opts.graphicType)
+		options.Flatten = flatten
+		options.IgnoreOrientation = !opts.exif
+		log.Fatal(serve(opts.serve, options, opts.timeout, opts.maxBody))
+	}
+
+	pages, err := openImageFile(opts.filename, opts.exif, opts.frames != "", limits)
+	if err != nil {
+		log.Printf("Warning: %s\n", err)
+		return
+	}
+
+	var labels strings.Builder
+	flats := make([]image.Image, len(pages))
+	for i, img := range pages {
+		img = processImage(img, filters, resizeFactor, resampling, flatten)
+
+		var flat image.Image = zplgfa.FlattenImageWithOptions(img, flatten)
+		if opts.hybrid {
+			if opts.hybridMask != "" {
+				mask := zplgfa.ClassifyRegions(flat, zplgfa.HybridOptions{})
+				if err := writeImageFile(pageFilename(opts.hybridMask, i, len(pages)), mask); err != nil {
+					log.Printf("Warning: %s\n", err)
+					return
+				}
+			}
+			flat = zplgfa.ConvertHybrid(flat, zplgfa.HybridOptions{})
+		}
+		flats[i] = flat
+		if opts.output != "" && isImageFile(opts.output) {
+			if err := writeImageFile(pageFilename(opts.output, i, len(pages)), flat); err != nil {
+				log.Printf("Warning: %s\n", err)
+				return
+			}
+			continue
+		}
+		if strings.EqualFold(opts.frames, "dg") {
+			continue
+		}
+
+		label, err := convertPage(ctx, flat, opts.graphicType, opts.lines, options)
+		if err != nil {
+			log.Printf("Warning: %s\n", err)
+			return
+		}
+		labels.WriteString(label)
+	}
+	if opts.output != "" && isImageFile(opts.output) {
+		return
 	}
+	if strings.EqualFold(opts.frames, "dg") {
+		graphics, err := zplgfa.ConvertPagesToDownloadGraphics(flats, "FRAME", getGraphicType(opts.graphicType))
+		if err != nil {
+			log.Printf("Warning: %s\n", err)
+			return
+		}
+		labels.WriteString(graphics)
+	}
+	writeZPL(opts, labels.String())
+}
 
-	if ip != "" {
-		sendDataToZebra(ip, port, gfimg)
+// writeZPL sends zpl to the printer given with -ip, writes it to the -out file or prints it.
+func writeZPL(opts cliOptions, zpl string) {
+	if opts.ip != "" {
+		sendDataToZebra(opts.ip, opts.port, zpl)
+	} else if opts.output != "" {
+		if err := os.WriteFile(opts.output, []byte(zpl), 0644); err != nil {
+			log.Printf("Warning: %s\n", err)
+		}
 	} else {
-		fmt.Println(gfimg)
+		fmt.Println(zpl)
 	}
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"simonwaldherr.de/go/zplgfa"
+)
+
+// errorMessage explains limit, decode and timeout errors, other errors are returned as they are.
+func errorMessage(err error) string {
+	var limitErr *zplgfa.LimitError
+	var decodeErr *zplgfa.DecodeError
+	var bodyErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &bodyErr):
+		return fmt.Sprintf("the request body is larger than the limit of %d bytes", bodyErr.Limit)
+	case errors.As(err, &decodeErr):
+		message := decodeErr.Kind.String()
+		if decodeErr.Row >= 0 {
+			message += fmt.Sprintf(" in row %d", decodeErr.Row+1)
+		}
+		if decodeErr.Err != nil {
+			message += ": " + decodeErr.Err.Error()
+		}
+		return message
+	case errors.As(err, &limitErr):
+		switch limitErr.Kind {
+		case zplgfa.LimitPixels:
+			return fmt.Sprintf("the image has %d pixels, the limit is %d", limitErr.Size, limitErr.Limit)
+		case zplgfa.LimitOutputBytes:
+			return fmt.Sprintf("the ZPL output is larger than the limit of %d bytes", limitErr.Limit)
+		case zplgfa.LimitInflatedBytes:
+			return fmt.Sprintf("the Z64 graphic data inflates to more than the limit of %d bytes", limitErr.Limit)
+		case zplgfa.LimitInputBytes:
+			return fmt.Sprintf("the input is larger than the limit of %d bytes", limitErr.Limit)
+		}
+	case errors.Is(err, context.DeadlineExceeded):
+		return "the conversion took longer than the time limit"
+	case errors.Is(err, context.Canceled):
+		return "the conversion was canceled"
+	}
+	return err.Error()
+}
+
+// errorLocation shows the line of src a *zplgfa.DecodeError points to, with a caret under the offset.
+// Long lines, like the data of a graphic field, are cut around the offset. Other errors have no location.
+func errorLocation(src string, err error) string {
+	var decodeErr *zplgfa.DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Offset < 0 || decodeErr.Offset > len(src) {
+		return ""
+	}
+	const context = 32
+
+	offset := decodeErr.Offset
+	lineStart := strings.LastIndexByte(src[:offset], '\n') + 1
+	lineEnd := len(src)
+	if end := strings.IndexByte(src[offset:], '\n'); end != -1 {
+		lineEnd = offset + end
+	}
+	line := strings.Count(src[:offset], "\n") + 1
+	column := offset - lineStart + 1
+
+	start, end := max(lineStart, offset-context), min(lineEnd, offset+context)
+	prefix, suffix := "", ""
+	if start > lineStart {
+		prefix = "..."
+	}
+	if end < lineEnd {
+		suffix = "..."
+	}
+	snippet := strings.TrimRight(prefix+src[start:end], "\r") + suffix
+	caret := strings.Repeat(" ", len(prefix)+offset-start) + "^"
+	return fmt.Sprintf("line %d, column %d:\n  %s\n  %s", line, column, snippet, caret)
+}
+
+// httpStatus maps conversion errors to HTTP status codes.
+func httpStatus(err error) int {
+	var limitErr *zplgfa.LimitError
+	var bodyErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &bodyErr):
+		return http.StatusRequestEntityTooLarge
+	case errors.As(err, &limitErr):
+		if limitErr.Kind == zplgfa.LimitOutputBytes {
+			return http.StatusUnprocessableEntity
+		}
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, image.ErrFormat):
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
+
+// withTimeout returns the context of r, limited to timeout if it is positive.
+func withTimeout(r *http.Request, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(r.Context(), timeout)
+	}
+	return context.WithCancel(r.Context())
+}
+
+// limitBody makes reading the body of r fail with a *http.MaxBytesError after maxBody bytes if maxBody is positive.
+func limitBody(w http.ResponseWriter, r *http.Request, maxBody int64) {
+	if maxBody > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+	}
+}
+
+// serve converts images posted to /convert to ZPL and ZPL posted to /decode to PNG.
+// Request bodies larger than maxBody bytes are rejected, 0 means no limit.
+func serve(addr string, options zplgfa.ConvertOptions, timeout time.Duration, maxBody int64) error {
+	converter := zplgfa.NewConverter(options)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /convert", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := withTimeout(r, timeout)
+		defer cancel()
+		limitBody(w, r, maxBody)
+
+		result, err := converter.ConvertReaderToZPLContext(ctx, r.Body)
+		if err != nil {
+			log.Printf("Warning: %s\n", errorMessage(err))
+			http.Error(w, errorMessage(err), httpStatus(err))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, result.ZPL)
+	})
+	mux.HandleFunc("POST /decode", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := withTimeout(r, timeout)
+		defer cancel()
+		limitBody(w, r, maxBody)
+
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, errorMessage(err), httpStatus(err))
+			return
+		}
+		img, err := zplgfa.ConvertZPLToImageContext(ctx, string(data), options.Limits)
+		if err != nil {
+			message := errorMessage(err)
+			if location := errorLocation(string(data), err); location != "" {
+				message += "\n" + location
+			}
+			log.Printf("Warning: %s\n", message)
+			http.Error(w, message, httpStatus(err))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, img.Paletted())
+	})
+
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	log.Printf("Info: listening on %s\n", addr)
+	return server.ListenAndServe()
+}
@@ -0,0 +1,63 @@
+package zplgfa
+
+import (
+	"fmt"
+	"image"
+	"io"
+)
+
+func init() {
+	image.RegisterFormat("zpl", "^XA", Decode, DecodeConfig)
+	image.RegisterFormat("zpl", "^GF", Decode, DecodeConfig)
+}
+
+// Decode reads a ZPL document from r and returns its first ^GF graphic field as a black and white image.
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ConvertZPLToImage(string(data))
+}
+
+// DecodeConfig returns the dimensions of the first ^GF graphic field in a ZPL
+// document, found like Decode finds it, without decoding the graphic data.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	zpl := string(data)
+	command, err := firstGraphicField(zpl)
+	if err != nil {
+		return image.Config{}, err
+	}
+	field, err := parseGraphicField(zpl[command.Offset:command.End])
+	if err != nil {
+		return image.Config{}, shiftDecodeError(err, command.Offset)
+	}
+	width, height, err := field.size()
+	if err != nil {
+		return image.Config{}, shiftDecodeError(err, command.Offset)
+	}
+	return image.Config{ColorModel: MonochromeModel, Width: width, Height: height}, nil
+}
+
+// Encode writes the image to w as a ZPL label.
+// A nil opts encodes the image as CompressedASCII at the label origin.
+func Encode(w io.Writer, img image.Image, opts *ConvertOptions) error {
+	if img == nil || img.Bounds().Dx() == 0 || img.Bounds().Dy() == 0 {
+		return fmt.Errorf("empty image")
+	}
+	options := ConvertOptions{GraphicType: CompressedASCII}
+	if opts != nil {
+		options = *opts
+	}
+
+	zpl, err := convertToZPL(img, options)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, zpl)
+	return err
+}
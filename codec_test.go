@@ -0,0 +1,80 @@
+package zplgfa
+
+import (
+	"bytes"
+	"image"
+	"strings"
+	"testing"
+)
+
+func Test_EncodeDecode(t *testing.T) {
+	img := checkerImage(11, 3)
+
+	for _, graphicType := range []GraphicType{ASCII, Binary, CompressedASCII, Z64} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, &ConvertOptions{GraphicType: graphicType, X: 10, Y: 20}); err != nil {
+			t.Fatalf("Encode failed: %s", err)
+		}
+
+		config, format, err := image.DecodeConfig(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("DecodeConfig failed: %s", err)
+		}
+		if format != "zpl" || config.Width != 16 || config.Height != 3 {
+			t.Fatalf("DecodeConfig failed: got %s %dx%d", format, config.Width, config.Height)
+		}
+
+		decoded, format, err := image.Decode(&buf)
+		if err != nil {
+			t.Fatalf("Decode failed: %s", err)
+		}
+		if format != "zpl" {
+			t.Fatalf("Decode format failed: got %s", format)
+		}
+		assertBilevelEqual(t, decoded, img)
+	}
+}
+
+func Test_EncodeDefaults(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 2)), nil); err != nil {
+		t.Fatalf("Encode failed: %s", err)
+	}
+	want := "^XA,^FS\n^FO0,0\n^GFA,3,2,1,\nFF:^FS,^XZ\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("Encode failed:\nExpected:\n%s\nGot:\n%s", want, got)
+	}
+
+	if err := Encode(&buf, image.NewGray(image.Rect(0, 0, 0, 0)), nil); err == nil {
+		t.Fatal("Encode should fail for empty images")
+	}
+}
+
+func Test_DecodeConfigGraphicField(t *testing.T) {
+	config, err := DecodeConfig(strings.NewReader("^GFA,6,6,2,\nFFFF::"))
+	if err != nil {
+		t.Fatalf("DecodeConfig failed: %s", err)
+	}
+	if config.Width != 16 || config.Height != 3 {
+		t.Fatalf("DecodeConfig failed: got %dx%d", config.Width, config.Height)
+	}
+	if _, err := DecodeConfig(strings.NewReader("^XA^FO0,0^FS^XZ")); err == nil {
+		t.Fatal("DecodeConfig should fail without a ^GF field")
+	}
+
+	// the field is found like Decode finds it
+	for _, zpl := range []string{
+		"^XA^FO0,0^gfA,4,4,2,\nFFFF\n0000^FS^XZ",
+		"^XA^CC++FO0,0+GFA,4,4,2,\nFFFF\n0000+FS+XZ",
+		"^XA^FX ^GFA,1,1,1,\nFF\n^FS^FO0,0^GFA,4,4,2,\nFFFF\n0000^FS^XZ",
+	} {
+		config, err := DecodeConfig(strings.NewReader(zpl))
+		img, decodeErr := Decode(strings.NewReader(zpl))
+		if err != nil || decodeErr != nil || config.Width != img.Bounds().Dx() || config.Height != img.Bounds().Dy() {
+			t.Fatalf("DecodeConfig failed for %q: got %dx%d (%v), Decode failed with %v", zpl, config.Width, config.Height, err, decodeErr)
+		}
+	}
+	if _, err := DecodeConfig(strings.NewReader("^GFA,536870912,536870912,8192,\n,")); err == nil {
+		t.Fatal("DecodeConfig should fail for a field of 512 MiB")
+	}
+}
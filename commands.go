@@ -0,0 +1,149 @@
+package zplgfa
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Command is a ZPL command as it appears in a document.
+type Command struct {
+	// Prefix is the character the command is written with. It is ^ or ~ unless the document
+	// changes them with ^CC or ^CT.
+	Prefix byte
+	// Control is set for commands written with the control prefix, ~ by default.
+	Control bool
+	// Name is the upper case name of the command, e.g. "FO" or "GF". Font commands are named "A" and
+	// their font is the first character of Params. Text outside of commands, like before the first one,
+	// has no prefix and no name.
+	Name string
+	// Params is everything after the name up to the next command, including the data of ^FD and ^GF.
+	Params string
+	// Offset and End are the byte offsets of the command in the document.
+	Offset, End int
+}
+
+// String returns the command as ZPL, with the name in upper case.
+func (c Command) String() string {
+	if c.Prefix == 0 {
+		return c.Params
+	}
+	return string([]byte{c.Prefix}) + c.Name + c.Params
+}
+
+// Is reports whether c is the format command ^name or, for names starting with ~, the control command.
+func (c Command) Is(name string) bool {
+	if control := strings.HasPrefix(name, "~"); control || strings.HasPrefix(name, "^") {
+		return c.Control == control && c.Name == name[1:]
+	}
+	return false
+}
+
+// hasData reports whether the parameters of c are data whose whitespace matters: field data, comments,
+// serialization, prefix changes, download commands and binary graphic fields.
+func (c Command) hasData() bool {
+	switch {
+	case c.Name == "CC", c.Name == "CT":
+		return true
+	case c.Control:
+		return strings.HasPrefix(c.Name, "D")
+	case c.Name == "GF":
+		return strings.HasPrefix(strings.ToUpper(c.Params), "B")
+	}
+	return slices.Contains([]string{"FD", "FV", "FX", "SN", "SF"}, c.Name)
+}
+
+// ParseCommands splits a ZPL document into its commands. Concatenating the commands gives the document again.
+// The prefixes changed by ^CC, ~CC, ^CT and ~CT are followed, and the data of binary ^GF fields is
+// skipped by its byte count, so it may contain prefix characters.
+func ParseCommands(zpl string) []Command {
+	caret, tilde := byte('^'), byte('~')
+	isPrefix := func(c byte) bool { return c == caret || c == tilde }
+	nextPrefix := func(from int) int {
+		for i := from; i < len(zpl); i++ {
+			if isPrefix(zpl[i]) {
+				return i
+			}
+		}
+		return len(zpl)
+	}
+
+	var commands []Command
+	for pos := 0; pos < len(zpl); {
+		if !isPrefix(zpl[pos]) {
+			end := nextPrefix(pos)
+			commands = append(commands, Command{Params: zpl[pos:end], Offset: pos, End: end})
+			pos = end
+			continue
+		}
+
+		command := Command{Prefix: zpl[pos], Control: zpl[pos] == tilde, Offset: pos}
+		nameEnd := pos + 1
+		for nameEnd < min(pos+3, len(zpl)) && !isPrefix(zpl[nameEnd]) {
+			nameEnd++
+		}
+		command.Name = upperASCII(zpl[pos+1 : nameEnd])
+		if !command.Control && len(command.Name) == 2 && command.Name[0] == 'A' {
+			command.Name, nameEnd = "A", pos+2
+		}
+
+		end := nextPrefix(nameEnd)
+		switch command.Name {
+		case "CC", "CT":
+			if nameEnd < len(zpl) {
+				end = nameEnd + 1
+				if command.Name == "CC" {
+					caret = zpl[nameEnd]
+				} else {
+					tilde = zpl[nameEnd]
+				}
+			}
+		case "GF":
+			if !command.Control {
+				end = max(end, binaryGraphicFieldEnd(zpl, nameEnd))
+			}
+		}
+		command.Params = zpl[nameEnd:end]
+		command.End = end
+		commands = append(commands, command)
+		pos = end
+	}
+	return commands
+}
+
+// upperASCII upper-cases the ASCII letters of s and keeps all other bytes, unlike strings.ToUpper
+// which replaces invalid UTF-8.
+func upperASCII(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'a' <= c && c <= 'z' {
+			b[i] -= 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// binaryGraphicFieldEnd returns the end of the data of a binary ^GF field whose parameters start at
+// start, or -1 if it is no binary field with a valid byte count. Like parseGraphicField it allows a
+// line break before the data.
+func binaryGraphicFieldEnd(zpl string, start int) int {
+	params := zpl[start:]
+	if len(params) == 0 || (params[0] != 'B' && params[0] != 'b') {
+		return -1
+	}
+	parts := strings.SplitN(params, ",", 5)
+	if len(parts) != 5 {
+		return -1
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil || count < 0 {
+		return -1
+	}
+	data := parts[4]
+	data = strings.TrimPrefix(data, "\r\n")
+	data = strings.TrimPrefix(data, "\n")
+	if count > len(data) {
+		return -1
+	}
+	return len(zpl) - len(data) + count
+}
@@ -0,0 +1,40 @@
+package zplgfa
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_ParseCommands(t *testing.T) {
+	binary := "^GFB,4,4,2,\n^~^X"
+	zpl := "\xef\xbb\xbf^XA\n^fo10,20^A0N,30,30^FDHello^FS\n" + binary + "^FS^CC+~CT#+FO1,2+FDa^b~c+FS#JA+XZ"
+	commands := ParseCommands(zpl)
+
+	var joined strings.Builder
+	var names []string
+	for _, command := range commands {
+		joined.WriteString(zpl[command.Offset:command.End])
+		name := string(command.Prefix) + command.Name
+		if command.Name == "" {
+			name = "text"
+		}
+		names = append(names, name)
+	}
+	if joined.String() != zpl {
+		t.Fatalf("ParseCommands failed: the commands don't cover the document")
+	}
+	want := "text ^XA ^FO ^A ^FD ^FS ^GF ^FS ^CC ~CT +FO +FD +FS #JA +XZ"
+	if got := strings.Join(names, " "); got != want {
+		t.Fatalf("ParseCommands failed:\nExpected: %s\nGot:      %s", want, got)
+	}
+
+	if commands[3].Params != "0N,30,30" || commands[6].Params != binary[3:] || commands[11].Params != "a^b~c" {
+		t.Fatalf("ParseCommands failed: got params %q, %q and %q", commands[3].Params, commands[6].Params, commands[11].Params)
+	}
+	if !commands[9].Control || !commands[13].Control || commands[10].Control || !commands[10].Is("^FO") || commands[10].Is("~FO") {
+		t.Fatalf("ParseCommands failed: control commands not recognized after ^CC and ~CT")
+	}
+	if got := commands[2].String(); got != "^FO10,20" {
+		t.Fatalf("Command.String failed: got %q, want ^FO10,20", got)
+	}
+}
@@ -0,0 +1,520 @@
+package zplgfa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// unclipped is the area of a label without a size, fields are only kept at positions that are not negative.
+var unclipped = image.Rect(0, 0, math.MaxInt32, math.MaxInt32)
+
+// TranslateOptions controls TranslateZPL.
+type TranslateOptions struct {
+	// Offset moves every field by this many dots to the right and down, negative values move fields left and up.
+	Offset image.Point
+	// Clip removes the fields outside of the label size set with ^PW and ^LL. Without Clip only fields moved
+	// left of or above the label home are removed.
+	Clip bool
+}
+
+// TranslateResult is a document rewritten by TranslateZPL.
+type TranslateResult struct {
+	ZPL string
+	// Moved counts the fields written at a new position, Cropped the graphics and boxes cut at the border of the
+	// label and Removed the fields outside of it.
+	Moved, Cropped, Removed int
+}
+
+// TranslateZPL moves the fields of a ZPL document, from ^FO or ^FT to ^FS, by rewriting their positions.
+// Fields that end up outside of the label are removed and ^GF graphics and filled ^GB boxes that cross its
+// border are cropped, the graphics keep their encoding. Text, barcodes and other fields are kept as long as
+// their position is on the label, they may still be cut off by the printer. A graphic field that has to be
+// cropped but can't be decoded stops the rewrite with its *DecodeError.
+func TranslateZPL(zpl string, options TranslateOptions) (TranslateResult, error) {
+	return TranslateZPLContext(context.Background(), zpl, options, Limits{})
+}
+
+// TranslateZPLContext is TranslateZPL with limits for decoding the cropped fields and for the size of the
+// rewritten document, stopping with the error of ctx once ctx is done.
+func TranslateZPLContext(ctx context.Context, zpl string, options TranslateOptions, limits Limits) (TranslateResult, error) {
+	layout := newFieldLayout(ctx, limits)
+	defer sharedBuffers.Put(layout.buffers)
+
+	commands := ParseCommands(zpl)
+	var home image.Point
+	width, length := 0, 0
+	for start := 0; start < len(commands); {
+		end := formatEnd(commands, start)
+		width, length = labelSize(commands[start:end], width, length)
+		clip := unclipped
+		if options.Clip && width > 0 {
+			clip.Max.X = width
+		}
+		if options.Clip && length > 0 {
+			clip.Max.Y = length
+		}
+		var err error
+		if home, err = layout.writeCommands(zpl, commands[start:end], home, options.Offset, clip); err != nil {
+			return TranslateResult{}, err
+		}
+		start = end
+	}
+
+	if err := limits.checkOutput(layout.out.Len()); err != nil {
+		return TranslateResult{}, err
+	}
+	layout.result.ZPL = layout.out.String()
+	return layout.result, nil
+}
+
+// MergeZPL combines the formats of several ZPL documents into one format, for example a shipping label and a
+// logo from ConvertToGraphicField placed with ^FO. The commands of all formats are written between one ^XA and
+// ^XZ in the order of the documents, a document without ^XA is taken as the content of a format. Commands outside
+// of formats, like downloads, are written before the format. The label home, field orientation, default font
+// and barcode defaults set by ^LH, ^FW, ^CF and ^BY are reset before a document if an earlier one changed them,
+// and prefix changes with ^CC and ^CT are undone after every document. Other settings like ^PW carry over.
+func MergeZPL(documents ...string) string {
+	var before, body strings.Builder
+	caret, tilde := byte('^'), byte('~')
+	changed := map[string]bool{}
+	for _, zpl := range documents {
+		for _, setting := range mergeDefaults {
+			if changed[setting.name] {
+				body.WriteString("^" + setting.name + setting.params)
+				changed[setting.name] = false
+			}
+		}
+
+		commands := ParseCommands(zpl)
+		hasFormat := false
+		for _, command := range commands {
+			hasFormat = hasFormat || command.Is("^XA")
+		}
+
+		// the prefixes of the document are followed, its formats are written with the ones in effect
+		docCaret, docTilde := byte('^'), byte('~')
+		inFormat := !hasFormat
+		for _, command := range commands {
+			switch {
+			case command.Is("^XA"):
+				inFormat = true
+				continue
+			case command.Is("^XZ"):
+				inFormat = false
+				continue
+			}
+			changesPrefix := command.Name == "CC" || command.Name == "CT"
+			switch {
+			case inFormat:
+				body.WriteString(prefixChange(caret, tilde, docCaret, docTilde))
+				body.WriteString(zpl[command.Offset:command.End])
+				if !command.Control {
+					changed[command.Name] = true
+				}
+			case changesPrefix:
+			case command.Prefix != 0:
+				// outside of the format, commands are written with the default prefixes
+				command.Prefix = '^'
+				if command.Control {
+					command.Prefix = '~'
+				}
+				before.WriteString(command.String())
+			case strings.Trim(command.Params, asciiSpace) != "":
+				before.WriteString(command.Params)
+			}
+			if changesPrefix {
+				docCaret, docTilde = prefixesAfter(command, docCaret, docTilde)
+			}
+			if inFormat {
+				caret, tilde = docCaret, docTilde
+			}
+		}
+		body.WriteString(prefixChange(caret, tilde, '^', '~'))
+		caret, tilde = '^', '~'
+	}
+	return before.String() + "^XA" + body.String() + "^XZ\n"
+}
+
+// mergeDefaults are the settings MergeZPL resets between documents, with the values of a printer
+// that was not configured otherwise.
+var mergeDefaults = []struct{ name, params string }{
+	{"LH", "0,0"}, {"FW", "N"}, {"CF", "A,9,5"}, {"BY", "2,3.0,10"},
+}
+
+// prefixesAfter returns the prefixes after a ^CC or ^CT command.
+func prefixesAfter(command Command, caret, tilde byte) (byte, byte) {
+	if command.Params == "" {
+		return caret, tilde
+	}
+	if command.Name == "CC" {
+		return command.Params[0], tilde
+	}
+	return caret, command.Params[0]
+}
+
+// prefixChange returns the commands that change the prefixes caret and tilde to newCaret and newTilde.
+func prefixChange(caret, tilde, newCaret, newTilde byte) string {
+	change := ""
+	if tilde != newTilde {
+		change += string([]byte{caret}) + "CT" + string([]byte{newTilde})
+	}
+	if caret != newCaret {
+		change += string([]byte{caret}) + "CC" + string([]byte{newCaret})
+	}
+	return change
+}
+
+// NUpOptions controls NUpZPL.
+type NUpOptions struct {
+	// Columns is the number of labels side by side on the media.
+	Columns int
+	// Width is the width of a label in dots, 0 uses the widest ^PW of the labels.
+	Width int
+	// Gap is the space between two labels in dots.
+	Gap int
+}
+
+// NUpZPL lays out the formats of a ZPL document side by side on wide media, Columns labels in every format.
+// The fields of every label are moved into its column and clipped to its ^PW and ^LL, or to Width if the label
+// is wider, and the formats get a ^PW of all columns and the longest ^LL of the labels. Every label starts with
+// the ^LH that was in effect for it. Commands outside of formats are kept where they are.
+func NUpZPL(zpl string, options NUpOptions) (string, error) {
+	return NUpZPLContext(context.Background(), zpl, options, Limits{})
+}
+
+// NUpZPLContext is NUpZPL with limits for decoding the cropped fields and for the size of the rewritten
+// document, stopping with the error of ctx once ctx is done.
+func NUpZPLContext(ctx context.Context, zpl string, options NUpOptions, limits Limits) (string, error) {
+	if options.Columns <= 0 || options.Width < 0 || options.Gap < 0 {
+		return "", fmt.Errorf("invalid layout of %d columns of %d dots with a gap of %d dots", options.Columns, options.Width, options.Gap)
+	}
+	commands := ParseCommands(zpl)
+
+	// the size of every label, like on a printer ^PW and ^LL carry over to the next formats
+	type label struct{ width, length int }
+	var labels []label
+	cellWidth, length := options.Width, 0
+	width, labelLength := 0, 0
+	for start := 0; start < len(commands); {
+		end := formatEnd(commands, start)
+		width, labelLength = labelSize(commands[start:end], width, labelLength)
+		if formatStart(commands[start:end]) != -1 {
+			labels = append(labels, label{width, labelLength})
+			length = max(length, labelLength)
+			if options.Width == 0 {
+				cellWidth = max(cellWidth, width)
+			}
+		}
+		start = end
+	}
+	if len(labels) == 0 {
+		return "", fmt.Errorf("no ^XA format to lay out")
+	}
+	if cellWidth == 0 {
+		return "", fmt.Errorf("the labels have no ^PW, the width of a label is needed")
+	}
+
+	layout := newFieldLayout(ctx, limits)
+	defer sharedBuffers.Put(layout.buffers)
+	write := func(name, params string) {
+		layout.out.WriteString(string([]byte{layout.caret}) + name + params)
+	}
+	mediaWidth := options.Columns*cellWidth + (options.Columns-1)*options.Gap
+	var home image.Point
+	n := 0
+	for start := 0; start < len(commands); {
+		end := formatEnd(commands, start)
+		format := commands[start:end]
+		start = end
+		// commands outside of the format are written as they are
+		xa := formatStart(format)
+		outside := format
+		if xa != -1 {
+			outside = format[:xa]
+		}
+		var err error
+		if home, err = layout.writeCommands(zpl, outside, home, image.Point{}, unclipped); err != nil {
+			return "", err
+		}
+		if xa == -1 {
+			continue
+		}
+
+		column := n % options.Columns
+		if column == 0 {
+			write("XA", "")
+			write("PW", strconv.Itoa(mediaWidth))
+			if length > 0 {
+				write("LL", strconv.Itoa(length))
+			}
+		}
+		write("LH", strconv.Itoa(home.X)+","+strconv.Itoa(home.Y))
+
+		var body []Command
+		for _, command := range format[xa+1:] {
+			if !command.Is("^XZ") && !command.Is("^PW") && !command.Is("^LL") {
+				body = append(body, command)
+			}
+		}
+		offset := image.Pt(column*(cellWidth+options.Gap), 0)
+		clip := image.Rect(offset.X, 0, offset.X+cellWidth, math.MaxInt32)
+		if labels[n].width > 0 {
+			clip.Max.X = offset.X + min(labels[n].width, cellWidth)
+		}
+		if labels[n].length > 0 {
+			clip.Max.Y = labels[n].length
+		}
+		if home, err = layout.writeCommands(zpl, body, home, offset, clip); err != nil {
+			return "", err
+		}
+
+		n++
+		if column == options.Columns-1 || n == len(labels) {
+			write("XZ", "\n")
+		}
+	}
+
+	if err := limits.checkOutput(layout.out.Len()); err != nil {
+		return "", err
+	}
+	return layout.out.String(), nil
+}
+
+// formatEnd returns the index after the ^XZ that ends the format starting at start, or the number of commands.
+func formatEnd(commands []Command, start int) int {
+	for i := start; i < len(commands); i++ {
+		if commands[i].Is("^XZ") {
+			return i + 1
+		}
+	}
+	return len(commands)
+}
+
+// formatStart returns the index of the ^XA in commands, or -1.
+func formatStart(commands []Command) int {
+	for i, command := range commands {
+		if command.Is("^XA") {
+			return i
+		}
+	}
+	return -1
+}
+
+// labelSize returns the ^PW and ^LL in effect at the end of commands.
+func labelSize(commands []Command, width, length int) (int, int) {
+	for _, command := range commands {
+		switch {
+		case command.Is("^PW"):
+			width = parseNumber(command.Params, 0)
+		case command.Is("^LL"):
+			length = parseNumber(command.Params, 0)
+		}
+	}
+	return width, length
+}
+
+// fieldLayout writes commands with their fields moved and clipped.
+type fieldLayout struct {
+	ctx     context.Context
+	limits  Limits
+	buffers *encodeBuffers
+	out     strings.Builder
+	result  TranslateResult
+	// caret is the format prefix in effect after the written commands
+	caret byte
+	// graphics counts the ^GF commands for errors
+	graphics int
+}
+
+func newFieldLayout(ctx context.Context, limits Limits) *fieldLayout {
+	return &fieldLayout{ctx: ctx, limits: limits, buffers: getSharedBuffers(), caret: '^'}
+}
+
+// writeCommands writes commands, moving the fields from ^FO or ^FT to ^FS by offset and keeping them within clip.
+// home is the label home before the commands, the returned home the one after them.
+func (l *fieldLayout) writeCommands(zpl string, commands []Command, home, offset image.Point, clip image.Rectangle) (image.Point, error) {
+	var field []Command
+	flush := func() error {
+		if len(field) == 0 {
+			return nil
+		}
+		err := l.writeField(zpl, field, home, offset, clip)
+		field = nil
+		return err
+	}
+
+	for _, command := range commands {
+		if err := canceled(l.ctx); err != nil {
+			return home, err
+		}
+		switch {
+		case command.Is("^FO"), command.Is("^FT"):
+			// a ^FR before the position belongs to the field
+			if fieldPosition(field) != -1 {
+				if err := flush(); err != nil {
+					return home, err
+				}
+			}
+		case command.Is("^XA"), command.Is("^XZ"), command.Is("^LH"):
+			if err := flush(); err != nil {
+				return home, err
+			}
+		}
+		if command.Is("^FO") || command.Is("^FT") || command.Is("^FR") || len(field) > 0 {
+			field = append(field, command)
+			if command.Is("^FS") {
+				if err := flush(); err != nil {
+					return home, err
+				}
+			}
+			continue
+		}
+
+		switch {
+		case command.Is("^LH"):
+			home = parsePoint(command.Params)
+		case command.Is("^GF"):
+			l.graphics++
+		case command.Name == "CC":
+			l.caret, _ = prefixesAfter(command, l.caret, 0)
+		}
+		l.out.WriteString(zpl[command.Offset:command.End])
+	}
+	return home, flush()
+}
+
+// fieldPosition returns the index of the ^FO or ^FT command of field, or -1 if it has none.
+func fieldPosition(field []Command) int {
+	for i, command := range field {
+		if command.Is("^FO") || command.Is("^FT") {
+			return i
+		}
+	}
+	return -1
+}
+
+// writeField writes the commands of a field moved by offset. The field starts at its ^FO or ^FT or at a ^FR
+// before them. It is measured by its graphic or box, or by its position if it has none or several. Fields
+// outside of clip or left of or above home are removed, only their prefix changes and the whitespace after
+// them are written, graphics and filled boxes crossing the border are cropped.
+func (l *fieldLayout) writeField(zpl string, field []Command, home, offset image.Point, clip image.Rectangle) error {
+	start := fieldPosition(field)
+	if start == -1 {
+		for _, command := range field {
+			if command.Is("^GF") {
+				l.graphics++
+			}
+			if command.Name == "CC" {
+				l.caret, _ = prefixesAfter(command, l.caret, 0)
+			}
+			l.out.WriteString(zpl[command.Offset:command.End])
+		}
+		return nil
+	}
+	origin := home.Add(parsePoint(field[start].Params)).Add(offset)
+	bottom := field[start].Name == "FT"
+	shape, size := -1, image.Pt(1, 1)
+	for i, command := range field {
+		if !command.Is("^GF") && !command.Is("^GB") {
+			continue
+		}
+		if shape != -1 {
+			shape = -1
+			break
+		}
+		shape = i
+	}
+	if shape != -1 && field[shape].Name == "GB" {
+		b := parseBox(field[shape].Params)
+		size = image.Pt(b.width, b.height)
+	} else if shape != -1 {
+		graphic, err := parseGraphicField(field[shape].String())
+		width, height, sizeErr := graphic.size()
+		if err != nil || sizeErr != nil {
+			shape = -1
+		} else {
+			size = image.Pt(width, height)
+		}
+	}
+	rect := image.Rectangle{Min: origin, Max: origin.Add(size)}
+	if bottom && shape != -1 {
+		rect = rect.Sub(image.Pt(0, size.Y))
+	}
+	area := clip.Intersect(image.Rectangle{Min: home, Max: unclipped.Max})
+
+	crop := image.Rectangle{}
+	switch {
+	case rect.In(area):
+	case shape != -1 && rect.Overlaps(area) && (field[shape].Name == "GF" || parseBox(field[shape].Params).filled()):
+		crop = rect.Intersect(area)
+		origin = crop.Min
+		if bottom {
+			origin.Y = crop.Max.Y
+		}
+		l.result.Cropped++
+	case !origin.In(area):
+		for _, command := range field {
+			if command.Is("^GF") {
+				l.graphics++
+			}
+			if command.Name == "CC" || command.Name == "CT" {
+				l.caret, _ = prefixesAfter(command, l.caret, 0)
+				l.out.WriteString(zpl[command.Offset:command.End])
+			}
+		}
+		// whitespace after the field belongs to the layout of the document
+		last := zpl[field[len(field)-1].Offset:field[len(field)-1].End]
+		l.out.WriteString(last[len(strings.TrimRight(last, asciiSpace)):])
+		l.result.Removed++
+		return nil
+	}
+
+	position := origin.Sub(home)
+	if position != parsePoint(field[start].Params) {
+		l.result.Moved++
+	}
+	for i, command := range field {
+		text := zpl[command.Offset:command.End]
+		switch {
+		case i == start:
+			text = text[:1+len(command.Name)] + replaceParams(command.Params, map[int]int{0: position.X, 1: position.Y})
+		case i == shape && !crop.Empty():
+			cropped, err := l.cropShape(command, crop.Sub(rect.Min))
+			if err != nil {
+				return err
+			}
+			text = cropped
+		case command.Name == "CC":
+			l.caret, _ = prefixesAfter(command, l.caret, 0)
+		}
+		if command.Is("^GF") {
+			l.graphics++
+		}
+		l.out.WriteString(text)
+	}
+	return nil
+}
+
+// cropShape returns the ^GF or ^GB command cut to r, relative to its top left corner.
+func (l *fieldLayout) cropShape(command Command, r image.Rectangle) (string, error) {
+	if command.Name == "GB" {
+		thickness := min(parseBox(command.Params).thickness, r.Dx(), r.Dy())
+		params := replaceParams(command.Params, map[int]int{0: r.Dx(), 1: r.Dy(), 2: thickness})
+		return string([]byte{command.Prefix}) + command.Name + params, nil
+	}
+	img, err := ConvertGraphicFieldToImageContext(l.ctx, command.String(), l.limits)
+	if err != nil {
+		var decodeErr *DecodeError
+		if errors.As(err, &decodeErr) {
+			decodeErr.Offset += command.Offset
+			decodeErr.Field = l.graphics
+		}
+		return "", err
+	}
+	return l.buffers.reencodeGraphicField(l.ctx, command, img.SubImage(r.Add(img.Rect.Min)), l.limits)
+}
@@ -0,0 +1,144 @@
+package zplgfa
+
+import (
+	"errors"
+	"image"
+	"strings"
+	"testing"
+)
+
+func Test_TranslateZPL(t *testing.T) {
+	tests := []struct {
+		zpl     string
+		options TranslateOptions
+		want    string
+	}{
+		{"^XA^FO10,20,1^FDa^FS^FT5,30^FDb^FS^XZ", TranslateOptions{Offset: image.Pt(40, 0)}, "^XA^FO50,20,1^FDa^FS^FT45,30^FDb^FS^XZ"},
+		{"^XA^LH10,10^fo0,0^FDa^FS\n^FO20,0^GB5,5,5^FS^XZ", TranslateOptions{Offset: image.Pt(-10, 2)}, "^XA^LH10,10\n^FO10,2^GB5,5,5^FS^XZ"},
+		// filled boxes are cropped, others are kept while their corner is on the label
+		{"^XA^PW20^LL10^FO15,0^GB10,4,4^FS^FO15,5^GB10,4,1^FS^FO30,0^FDa^FS^XZ", TranslateOptions{Clip: true}, "^XA^PW20^LL10^FO15,0^GB5,4,4^FS^FO15,5^GB10,4,1^FS^XZ"},
+		{"^XA^LL10^FT0,12^GB4,4,4^FS^XZ", TranslateOptions{Clip: true}, "^XA^LL10^FT0,10^GB4,2,2^FS^XZ"},
+		// removed fields keep their prefix changes
+		{"^XA^FO0,0^CC++FDa+FS+XZ", TranslateOptions{Offset: image.Pt(-1, 0)}, "^XA^CC++XZ"},
+		// a ^FR before ^FO is removed with its field
+		{"^XA^PW100^FO50,0^GB10,10,10^FS^FR^FO0,0^GB10,10,10^FS^FO50,0^GB10,10,10^FS^XZ", TranslateOptions{Offset: image.Pt(-20, 0)}, "^XA^PW100^FO30,0^GB10,10,10^FS^FO30,0^GB10,10,10^FS^XZ"},
+		{"^XA^FR^FO5,0^GB1,1,1^FS^FR^FS^XZ", TranslateOptions{Offset: image.Pt(1, 0)}, "^XA^FR^FO6,0^GB1,1,1^FS^FR^FS^XZ"},
+	}
+	for _, test := range tests {
+		result, err := TranslateZPL(test.zpl, test.options)
+		if err != nil || result.ZPL != test.want {
+			t.Fatalf("TranslateZPL failed for %q: got %q (%v), want %q", test.zpl, result.ZPL, err, test.want)
+		}
+	}
+
+	result, _ := TranslateZPL(tests[2].zpl, tests[2].options)
+	if result.Moved != 0 || result.Cropped != 1 || result.Removed != 1 {
+		t.Fatalf("TranslateZPL failed: got %+v", result)
+	}
+
+	moved, _ := TranslateZPL(tests[5].zpl, tests[5].options)
+	if labels, err := RenderLabels(moved.ZPL); err != nil || len(labels) != 1 || strings.Count(dotsString(labels[0]), "#") != 100 {
+		t.Fatalf("TranslateZPL failed: the ^FR of a removed field reverses the next one (%v)", err)
+	}
+}
+
+func Test_TranslateZPLRenders(t *testing.T) {
+	fields := "^FO2,3^GB12,8,8^FS\n^FO20,10^FR^GB30,4,4^FS\n^FO28,20" + ConvertToGraphicField(checkerImage(21, 13), Z64) + "^FS\n^XZ\n"
+	zpl := "^XA^PW40^LL30\n" + fields
+	// the fields of the original document drawn on a larger label, moved and cut at the label
+	large, err := RenderLabels("^XA^PW80^LL60\n" + fields)
+	if err != nil {
+		t.Fatalf("RenderLabels failed: %v", err)
+	}
+	for _, offset := range []image.Point{{5, 3}, {-4, 0}, {0, -6}, {13, 11}} {
+		clipped, err := TranslateZPL(zpl, TranslateOptions{Offset: offset, Clip: true})
+		if err != nil {
+			t.Fatalf("TranslateZPL failed for %v: %v", offset, err)
+		}
+		got, err := RenderLabels(clipped.ZPL)
+		if err != nil || len(got) != 1 {
+			t.Fatalf("RenderLabels failed for %v: %v", offset, err)
+		}
+		want := NewMonochrome(image.Rect(0, 0, 40, 30))
+		for y := 0; y < want.Rect.Dy(); y++ {
+			for x := 0; x < want.Rect.Dx(); x++ {
+				if from := image.Pt(x, y).Sub(offset); from.In(large[0].Rect) {
+					want.SetBlack(x, y, large[0].BlackAt(from.X, from.Y))
+				}
+			}
+		}
+		if dotsString(got[0]) != dotsString(want) {
+			t.Fatalf("TranslateZPL failed for %v: got\n%s\nwant\n%s", offset, dotsString(got[0]), dotsString(want))
+		}
+	}
+}
+
+func Test_TranslateZPLError(t *testing.T) {
+	zpl := "^XA^PW4^FO0,0^GFA,4,4,2,\nZZZZ^FS^XZ"
+	_, err := TranslateZPL(zpl, TranslateOptions{Clip: true})
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Offset < strings.Index(zpl, "^GF") {
+		t.Fatalf("TranslateZPL failed: got %v, want a DecodeError", err)
+	}
+	// fields that fit aren't decoded
+	if _, err := TranslateZPL(zpl, TranslateOptions{Offset: image.Pt(0, 1)}); err != nil {
+		t.Fatalf("TranslateZPL failed: %v", err)
+	}
+}
+
+func Test_MergeZPL(t *testing.T) {
+	logo := "^FO600,40" + ConvertToGraphicField(checkerImage(8, 2), ASCII) + "^FS"
+	got := MergeZPL("^XA^CC+\n+FO10,10+FDa+FS+XZ\n", "~DGR:X.GRF,2,1,\nFF\n^XA^FO20,20^FDb^FS^XZ", logo)
+	want := "~DGR:X.GRF,2,1,\nFF\n^XA^CC+\n+FO10,10+FDa+FS+CC^^FO20,20^FDb^FS" + logo + "^XZ\n"
+	if got != want {
+		t.Fatalf("MergeZPL failed: got %q, want %q", got, want)
+	}
+
+	labels, err := RenderLabels(MergeZPL("^XA^FO0,0^GB2,2,2^FS^XZ", "^XA^FO4,0^GB2,2,2^FS^XZ"))
+	if err != nil || len(labels) != 1 || dotsString(labels[0]) != "##..##\n##..##" {
+		t.Fatalf("MergeZPL failed: got %d labels (%v)", len(labels), err)
+	}
+
+	// the label home of a document doesn't move the next one
+	got = MergeZPL("^XA^LH10,10^BY3^FO0,0^GB2,2,2^FS^XZ", "^XA^FO0,0^GB1,1,1^FS^XZ", "^XA^FO4,0^GB1,1,1^FS^XZ")
+	want = "^XA^LH10,10^BY3^FO0,0^GB2,2,2^FS^LH0,0^BY2,3.0,10^FO0,0^GB1,1,1^FS^FO4,0^GB1,1,1^FS^XZ\n"
+	if got != want {
+		t.Fatalf("MergeZPL failed: got %q, want %q", got, want)
+	}
+	merged := MergeZPL("^XA^PW20^LL20^LH10,10^FO0,0^GB2,2,2^FS^XZ", ConvertToZPL(checkerImage(8, 2), ASCII))
+	if labels, err = RenderLabels(merged); err != nil || len(labels) != 1 || !labels[0].BlackAt(0, 0) || !labels[0].BlackAt(10, 10) {
+		t.Fatalf("MergeZPL failed: the logo moved with the label home of the first document (%v)", err)
+	}
+}
+
+func Test_NUpZPL(t *testing.T) {
+	labels := "^XA^PW6^LL3^FO0,0^GB8,1,1^FS^XZ\n" +
+		"^XA^LH1,1^FO0,0^GB3,2,2^FS^XZ\n" +
+		"^XA^FO2,0^GB2,2,2^FS^XZ\n"
+	got, err := NUpZPL(labels, NUpOptions{Columns: 2, Gap: 2})
+	if err != nil {
+		t.Fatalf("NUpZPL failed: %v", err)
+	}
+	want := "^XA^PW14^LL3^LH0,0^FO0,0^GB6,1,1^FS^LH0,0^LH1,1^FO8,0^GB3,2,2^FS^XZ\n" +
+		"^XA^PW14^LL3^LH1,1^FO2,0^GB2,2,2^FS^XZ\n"
+	if got != want {
+		t.Fatalf("NUpZPL failed: got %q, want %q", got, want)
+	}
+
+	rendered, err := RenderLabels(got)
+	if err != nil || len(rendered) != 2 {
+		t.Fatalf("RenderLabels failed: got %d labels (%v)", len(rendered), err)
+	}
+	if dots := dotsString(rendered[0]); dots != "######........\n.........###..\n.........###.." {
+		t.Fatalf("NUpZPL failed: got\n%s", dots)
+	}
+
+	for _, options := range []NUpOptions{{}, {Columns: 2, Gap: -1}} {
+		if _, err := NUpZPL(labels, options); err == nil {
+			t.Fatalf("NUpZPL failed: no error for %+v", options)
+		}
+	}
+	if _, err := NUpZPL("^XA^FO0,0^GB2,2,2^FS^XZ", NUpOptions{Columns: 2}); err == nil {
+		t.Fatalf("NUpZPL failed: no error without a label width")
+	}
+}
@@ -0,0 +1,139 @@
+package zplgfa
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"runtime"
+	"sync"
+)
+
+// minRowsPerChunk keeps chunks large enough that scheduling them costs less than encoding them.
+const minRowsPerChunk = 16
+
+// workerCount returns the number of goroutines for ConvertOptions.Workers.
+func workerCount(workers int) int {
+	if workers < 0 {
+		return runtime.NumCPU()
+	}
+	return max(workers, 1)
+}
+
+// rowChunks splits rows into consecutive chunks, a few per worker so that slow chunks are balanced.
+func rowChunks(rows, workers int) [][2]int {
+	size := max((rows+4*workers-1)/(4*workers), minRowsPerChunk)
+	var chunks [][2]int
+	for start := 0; start < rows; start += size {
+		chunks = append(chunks, [2]int{start, min(start+size, rows)})
+	}
+	return chunks
+}
+
+// forEachChunk calls fn for every chunk of rows on a pool of workers and waits for all of them.
+// After the first error no more chunks are started and that error is returned.
+func forEachChunk(chunks [][2]int, workers int, fn func(i, start, end int) error) error {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	failed := make(chan struct{})
+	for w := 0; w < min(workers, len(chunks)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := fn(i, chunks[i][0], chunks[i][1]); err != nil {
+					once.Do(func() {
+						firstErr = err
+						close(failed)
+					})
+				}
+			}
+		}()
+	}
+dispatch:
+	for i := range chunks {
+		select {
+		case jobs <- i:
+		case <-failed:
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return firstErr
+}
+
+// packImageInto is packImage reusing the memory of raw if it is large enough
+// and thresholding and packing the rows on workers goroutines.
+func packImageInto(ctx context.Context, raw []byte, source image.Image, workers int) ([]byte, int, error) {
+	size := source.Bounds().Size()
+	width := (size.X + 7) / 8 // round up division
+	if n := width * size.Y; cap(raw) >= n {
+		raw = raw[:n]
+		clear(raw)
+	} else {
+		raw = make([]byte, n)
+	}
+
+	workers = workerCount(workers)
+	if workers == 1 || size.Y < 2*minRowsPerChunk {
+		return raw, width, packRows(ctx, source, raw, width, 0, size.Y)
+	}
+	// Every chunk writes its own rows and uses its own row reader, so they don't share any state.
+	err := forEachChunk(rowChunks(size.Y, workers), workers, func(_, start, end int) error {
+		return packRows(ctx, source, raw, width, start, end)
+	})
+	return raw, width, err
+}
+
+// writeGraphicRowsConcurrent writes the rows as ASCII or CompressedASCII data to dst,
+// encoding chunks of them on workers goroutines and joining the chunks in order.
+func writeGraphicRowsConcurrent(ctx context.Context, dst *bytes.Buffer, raw []byte, width, height int, graphicType GraphicType, workers int, limits Limits) error {
+	chunks := rowChunks(height, workers)
+	encoded := make([][]byte, len(chunks))
+	err := forEachChunk(chunks, workers, func(i, start, end int) (err error) {
+		encoded[i], err = appendGraphicRows(ctx, nil, raw, width, start, end, graphicType, limits)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, chunk := range encoded {
+		dst.Write(chunk)
+		if err := limits.checkOutput(dst.Len()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendGraphicRows appends the rows start to end as ASCII or CompressedASCII data to dst.
+// A compressed row repeats the previous one if their packed bytes are equal, which is the case exactly
+// when their compressed lines are equal, so a chunk doesn't need the encoded last row of the chunk before it.
+func appendGraphicRows(ctx context.Context, dst, raw []byte, width, start, end int, graphicType GraphicType, limits Limits) ([]byte, error) {
+	hexLine := make([]byte, 2*width+1)
+	for y := start; y < end; y++ {
+		if err := canceled(ctx); err != nil {
+			return nil, err
+		}
+		if err := limits.checkOutput(len(dst)); err != nil {
+			return nil, err
+		}
+		line := raw[y*width : (y+1)*width]
+		switch graphicType {
+		case ASCII:
+			encodeHexLine(hexLine, line)
+			hexLine[2*width] = '\n'
+			dst = append(dst, hexLine...)
+		case CompressedASCII:
+			if y > 0 && bytes.Equal(line, raw[(y-1)*width:y*width]) {
+				dst = append(dst, ':')
+				continue
+			}
+			encodeHexLine(hexLine, line)
+			dst = appendCompressedASCII(dst, hexLine[:2*width])
+		}
+	}
+	return dst, nil
+}
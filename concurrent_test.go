@@ -0,0 +1,67 @@
+package zplgfa
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// repeatedRowsImage returns an image whose rows repeat in blocks of varying height,
+// so that repeated rows fall on both sides of chunk boundaries.
+func repeatedRowsImage(width, height int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	fillGray(img, color.White)
+	for y := 0; y < height; y++ {
+		block := y / (7 + y%3)
+		for x := block % 5; x < width; x += 3 + block%4 {
+			img.Set(x, y, color.Black)
+		}
+	}
+	return img
+}
+
+func Test_ConvertWorkersMatchSequential(t *testing.T) {
+	images := map[string]image.Image{
+		"repeated": repeatedRowsImage(93, 301),
+		"blank":    image.NewGray(image.Rect(0, 0, 40, 100)),
+		"NRGBA":    fastPathImages(77, 150)["NRGBA"],
+		"Mono":     fastPathImages(77, 150)["Monochrome"],
+		"short":    checkerImage(9, 3),
+	}
+	for name, img := range images {
+		for _, graphicType := range []GraphicType{ASCII, CompressedASCII, Binary, Z64} {
+			want, err := ConvertToGraphicFieldWithOptions(img, ConvertOptions{GraphicType: graphicType})
+			if err != nil {
+				t.Fatalf("ConvertToGraphicFieldWithOptions failed: %s", err)
+			}
+			for _, workers := range []int{-1, 2, 3, 8, 64} {
+				got, err := ConvertToGraphicFieldWithOptions(img, ConvertOptions{GraphicType: graphicType, Workers: workers})
+				if err != nil || got != want {
+					t.Fatalf("ConvertToGraphicFieldWithOptions failed for %s, %v with %d workers: output differs from the sequential encoder", name, graphicType, workers)
+				}
+			}
+		}
+	}
+}
+
+func Test_RowChunks(t *testing.T) {
+	chunks := rowChunks(1000, 4)
+	if len(chunks) != 16 || chunks[0] != [2]int{0, 63} || chunks[15] != [2]int{945, 1000} {
+		t.Fatalf("rowChunks failed: got %v", chunks)
+	}
+	if chunks := rowChunks(40, 8); len(chunks) != 3 || chunks[2] != [2]int{32, 40} {
+		t.Fatalf("rowChunks failed: got %v, want chunks of at least %d rows", chunks, minRowsPerChunk)
+	}
+}
+
+func benchmarkWorkers(b *testing.B, workers int) {
+	img := fastPathImages(2400, 3600)["NRGBA"]
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = ConvertToZPLWithOptions(img, ConvertOptions{GraphicType: CompressedASCII, Workers: workers})
+	}
+}
+
+func Benchmark_ConvertToZPLWorkers1(b *testing.B)   { benchmarkWorkers(b, 1) }
+func Benchmark_ConvertToZPLWorkersCPU(b *testing.B) { benchmarkWorkers(b, -1) }
@@ -0,0 +1,334 @@
+package zplgfa
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"io"
+	"sync"
+)
+
+// Converter converts images to ZPL with a fixed set of options. Between calls it keeps the memory of
+// the packed rows, encoded lines, flattened image and zlib writer in a sync.Pool, which saves most
+// allocations when many images are converted. A Converter is safe for concurrent use by multiple goroutines.
+type Converter struct {
+	options ConvertOptions
+	buffers *sync.Pool
+}
+
+// sharedBuffers is the pool of the converters behind the package level functions.
+var sharedBuffers = &sync.Pool{}
+
+// NewConverter returns a Converter for options. Later changes to the Morphology or Hybrid settings
+// passed in options don't affect the Converter.
+func NewConverter(options ConvertOptions) *Converter {
+	return &Converter{options: copyOptions(options), buffers: &sync.Pool{}}
+}
+
+// sharedConverter returns a Converter for options that uses the buffers of the package level functions.
+func sharedConverter(options ConvertOptions) *Converter {
+	return &Converter{options: options, buffers: sharedBuffers}
+}
+
+func copyOptions(options ConvertOptions) ConvertOptions {
+	options.Morphology = append([]Morphology(nil), options.Morphology...)
+	if options.Hybrid != nil {
+		hybrid := *options.Hybrid
+		options.Hybrid = &hybrid
+	}
+	return options
+}
+
+// Options returns a copy of the options of the Converter.
+func (c *Converter) Options() ConvertOptions {
+	return copyOptions(c.options)
+}
+
+// ConvertToZPL converts img to a label with ZPL start and end codes like ConvertToZPLWithOptions.
+func (c *Converter) ConvertToZPL(img image.Image) (string, error) {
+	return c.ConvertToZPLContext(context.Background(), img)
+}
+
+// ConvertToZPLContext is ConvertToZPL, stopping with the error of ctx once ctx is done.
+func (c *Converter) ConvertToZPLContext(ctx context.Context, img image.Image) (string, error) {
+	return c.convertToZPL(ctx, img, false)
+}
+
+// convertToZPL converts img to a label, flattening it even without flatten options if flatten is set.
+func (c *Converter) convertToZPL(ctx context.Context, img image.Image, flatten bool) (string, error) {
+	if img == nil {
+		return "", fmt.Errorf("no image to convert")
+	}
+
+	buffers := c.getBuffers()
+	defer c.buffers.Put(buffers)
+
+	fmt.Fprintf(&buffers.out, "^XA,^FS\n^FO%d,%d\n", c.options.X, c.options.Y)
+	if c.options.Reverse {
+		buffers.out.WriteString("^FR\n")
+	}
+	if err := c.writeGraphicField(ctx, buffers, img, flatten); err != nil {
+		return "", err
+	}
+	buffers.out.WriteString("^FS,^XZ\n")
+	if err := c.options.Limits.checkOutput(buffers.out.Len()); err != nil {
+		return "", err
+	}
+	return buffers.out.String(), nil
+}
+
+// ConvertToGraphicField converts img to a ^GF graphic field like ConvertToGraphicFieldWithOptions.
+func (c *Converter) ConvertToGraphicField(img image.Image) (string, error) {
+	return c.ConvertToGraphicFieldContext(context.Background(), img)
+}
+
+// ConvertToGraphicFieldContext is ConvertToGraphicField, stopping with the error of ctx once ctx is done.
+func (c *Converter) ConvertToGraphicFieldContext(ctx context.Context, img image.Image) (string, error) {
+	if img == nil {
+		return "", fmt.Errorf("no image to convert")
+	}
+
+	buffers := c.getBuffers()
+	defer c.buffers.Put(buffers)
+
+	if err := c.writeGraphicField(ctx, buffers, img, false); err != nil {
+		return "", err
+	}
+	return buffers.out.String(), nil
+}
+
+// ConvertReaderToZPLContext decodes image data from reader and converts it like ConvertReaderToZPLWithOptions.
+// At most MaxInputBytes are read and the size of the image is checked against the pixel limit before it is decoded.
+func (c *Converter) ConvertReaderToZPLContext(ctx context.Context, reader io.Reader) (ConvertResult, error) {
+	data, err := c.options.Limits.readAll(reader)
+	if err != nil {
+		return ConvertResult{}, err
+	}
+	if err := c.options.Limits.CheckImage(bytes.NewReader(data)); err != nil {
+		return ConvertResult{}, err
+	}
+	if err := canceled(ctx); err != nil {
+		return ConvertResult{}, err
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return ConvertResult{}, err
+	}
+
+	orientation := OrientationNormal
+	if !c.options.IgnoreOrientation {
+		// broken metadata must not prevent printing the image itself
+		if o, err := ReadOrientation(bytes.NewReader(data)); err == nil {
+			orientation = o
+		}
+		img = ApplyOrientation(img, orientation)
+	}
+
+	zpl, err := c.convertToZPL(ctx, img, true)
+	if err != nil {
+		return ConvertResult{}, err
+	}
+	return ConvertResult{
+		ZPL:         zpl,
+		Format:      format,
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+		Orientation: orientation,
+	}, nil
+}
+
+func (c *Converter) getBuffers() *encodeBuffers {
+	return poolBuffers(c.buffers)
+}
+
+// getSharedBuffers returns buffers of the package level functions, which go back with sharedBuffers.Put.
+func getSharedBuffers() *encodeBuffers {
+	return poolBuffers(sharedBuffers)
+}
+
+// poolBuffers returns buffers from pool, or new ones if it is empty.
+func poolBuffers(pool *sync.Pool) *encodeBuffers {
+	buffers, _ := pool.Get().(*encodeBuffers)
+	if buffers == nil {
+		buffers = &encodeBuffers{}
+	}
+	buffers.out.Reset()
+	return buffers
+}
+
+// writeGraphicField flattens, scales, dithers, packs and processes source and writes it as ^GF field to buffers.out.
+// Images are flattened if the options say so or flatten is set.
+func (c *Converter) writeGraphicField(ctx context.Context, buffers *encodeBuffers, source image.Image, flatten bool) error {
+	options := c.options
+	limits := options.Limits
+	if err := limits.checkPixels(source.Bounds().Dx(), source.Bounds().Dy()); err != nil {
+		return err
+	}
+
+	var err error
+	if flatten || options.Flatten != (FlattenOptions{}) {
+		if err := options.Flatten.Validate(); err != nil {
+			return err
+		}
+		if buffers.flat, err = flattenImageInto(ctx, buffers.flat, source, options.Flatten); err != nil {
+			return err
+		}
+		source = buffers.flat
+	}
+	if options.Scale > 0 && options.Scale != 1 {
+		if err := limits.checkPixels(scaledSize(source.Bounds().Dx(), source.Bounds().Dy(), options.Scale)); err != nil {
+			return err
+		}
+		if options.Resampling == ResampleSmooth {
+			source = resizeSmooth(source, options.Scale)
+		}
+	}
+	if options.Hybrid != nil {
+		if err := canceled(ctx); err != nil {
+			return err
+		}
+		source = ConvertHybrid(source, *options.Hybrid)
+	}
+
+	var width int
+	if buffers.raw, width, err = packImageInto(ctx, buffers.raw, source, options.Workers); err != nil {
+		return err
+	}
+	raw, width, height := processBitmap(buffers.raw, width, source.Bounds().Dx(), source.Bounds().Dy(), options)
+	if err := canceled(ctx); err != nil {
+		return err
+	}
+	return buffers.writeGraphicCommand(ctx, &buffers.out, raw, width, height, options.GraphicType, options.Workers, limits)
+}
+
+// writeGraphicCommand encodes packed rows as graphic field data of the given type and writes them with their ^GF header to dst.
+func (b *encodeBuffers) writeGraphicCommand(ctx context.Context, dst *bytes.Buffer, raw []byte, width, height int, graphicType GraphicType, workers int, limits Limits) error {
+	if err := b.writeGraphicData(ctx, raw, width, height, graphicType, workers, limits); err != nil {
+		return err
+	}
+
+	gfType := "A"
+	totalBytes := b.data.Len()
+	switch graphicType {
+	case Binary:
+		gfType = "B"
+	case Z64, B64:
+		totalBytes = len(raw)
+	}
+
+	fmt.Fprintf(dst, "^GF%s,%d,%d,%d,\n", gfType, totalBytes, width*height, width)
+	dst.Write(b.data.Bytes())
+	return limits.checkOutput(dst.Len())
+}
+
+// encodeBuffers holds the memory of one conversion so that the next one can reuse it.
+type encodeBuffers struct {
+	flat           *image.NRGBA
+	raw            []byte
+	hexLine        []byte
+	line, lastLine []byte
+	data, out      bytes.Buffer
+	compressed     bytes.Buffer
+	zlib           *zlib.Writer
+}
+
+// writeGraphicData encodes packed rows as graphic field data of the given type to buffers.data.
+// It stops with the error of ctx once ctx is done and with a *LimitError once the data exceeds the output limit.
+func (b *encodeBuffers) writeGraphicData(ctx context.Context, raw []byte, width, height int, graphicType GraphicType, workers int, limits Limits) error {
+	b.data.Reset()
+	switch graphicType {
+	case Z64:
+		if err := b.writeZ64(&b.data, raw); err != nil {
+			return err
+		}
+		return limits.checkOutput(b.data.Len())
+	case B64:
+		writeBase64Payload(&b.data, ":B64:", raw)
+		return limits.checkOutput(b.data.Len())
+	case Binary:
+		b.data.Write(raw[:width*height])
+		return limits.checkOutput(b.data.Len())
+	}
+
+	if workers = workerCount(workers); workers > 1 && width > 0 && height >= 2*minRowsPerChunk {
+		return writeGraphicRowsConcurrent(ctx, &b.data, raw, width, height, graphicType, workers, limits)
+	}
+
+	if cap(b.hexLine) < 2*width+1 {
+		b.hexLine = make([]byte, 2*width+1)
+	}
+	hexLine := b.hexLine[:2*width+1]
+	b.lastLine = b.lastLine[:0]
+	for y := 0; y < height; y++ {
+		if err := canceled(ctx); err != nil {
+			return err
+		}
+		if err := limits.checkOutput(b.data.Len()); err != nil {
+			return err
+		}
+		encodeHexLine(hexLine, raw[y*width:(y+1)*width])
+		switch graphicType {
+		case ASCII:
+			hexLine[2*width] = '\n'
+			b.data.Write(hexLine)
+		case CompressedASCII:
+			b.line = appendCompressedASCII(b.line[:0], hexLine[:2*width])
+			if bytes.Equal(b.line, b.lastLine) {
+				b.data.WriteByte(':')
+			} else {
+				b.data.Write(b.line)
+			}
+			b.line, b.lastLine = b.lastLine, b.line
+		}
+	}
+	return limits.checkOutput(b.data.Len())
+}
+
+// writeZ64 compresses input and writes it as a Z64 payload to dst.
+func (b *encodeBuffers) writeZ64(dst *bytes.Buffer, input []byte) error {
+	b.compressed.Reset()
+	if b.zlib == nil {
+		b.zlib = zlib.NewWriter(&b.compressed)
+	} else {
+		b.zlib.Reset(&b.compressed)
+	}
+	if _, err := b.zlib.Write(input); err != nil {
+		if closeErr := b.zlib.Close(); closeErr != nil {
+			return fmt.Errorf("zlib write failed: %w (close also failed: %v)", err, closeErr)
+		}
+		return err
+	}
+	if err := b.zlib.Close(); err != nil {
+		return err
+	}
+
+	writeBase64Payload(dst, ":Z64:", b.compressed.Bytes())
+	return nil
+}
+
+// writeBase64Payload writes data in base64 between prefix and its CRC to dst.
+func writeBase64Payload(dst *bytes.Buffer, prefix string, data []byte) {
+	dst.WriteString(prefix)
+	dst.Grow(base64.StdEncoding.EncodedLen(len(data)))
+	dst.Write(base64.StdEncoding.AppendEncode(dst.AvailableBuffer(), data))
+
+	crc := crc16CCITT(data)
+	var crcHex [5]byte
+	crcHex[0] = ':'
+	encodeHexLine(crcHex[1:], []byte{byte(crc >> 8), byte(crc)})
+	dst.Write(crcHex[:])
+}
+
+// reuseNRGBA returns an image with bounds r that uses the pixel memory of img if it is large enough.
+// The pixels of a reused image are not cleared.
+func reuseNRGBA(img *image.NRGBA, r image.Rectangle) *image.NRGBA {
+	n := 4 * r.Dx() * r.Dy()
+	if img == nil || cap(img.Pix) < n {
+		return image.NewNRGBA(r)
+	}
+	img.Pix, img.Stride, img.Rect = img.Pix[:n], 4*r.Dx(), r
+	return img
+}
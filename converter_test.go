@@ -0,0 +1,107 @@
+package zplgfa
+
+import (
+	"image/color"
+	"sync"
+	"testing"
+)
+
+func Test_Converter(t *testing.T) {
+	images := fastPathImages(45, 40)
+	for _, options := range []ConvertOptions{
+		{GraphicType: CompressedASCII, X: 10, Y: 20, Reverse: true},
+		{GraphicType: Z64, Flatten: FlattenOptions{Alpha: AlphaComposite, Background: color.Black}},
+		{GraphicType: ASCII, Morphology: []Morphology{{Op: MorphClose}}, ThinBlack: 1},
+		{GraphicType: Binary, Scale: 0.5, Workers: 2},
+	} {
+		converter := NewConverter(options)
+		for name, img := range images {
+			want := ConvertToZPLWithOptions(img, options)
+			// Convert twice to use the pooled buffers of the first call.
+			for i := 0; i < 2; i++ {
+				got, err := converter.ConvertToZPL(img)
+				if err != nil || got != want {
+					t.Fatalf("Converter.ConvertToZPL failed for %s with %+v: output differs from ConvertToZPLWithOptions", name, options)
+				}
+			}
+
+			want, _ = ConvertToGraphicFieldWithOptions(img, options)
+			if got, err := converter.ConvertToGraphicField(img); err != nil || got != want {
+				t.Fatalf("Converter.ConvertToGraphicField failed for %s with %+v", name, options)
+			}
+		}
+	}
+
+	if _, err := NewConverter(ConvertOptions{}).ConvertToZPL(nil); err == nil {
+		t.Fatal("Converter.ConvertToZPL should fail without an image")
+	}
+}
+
+func Test_ConverterCopiesOptions(t *testing.T) {
+	morphology := []Morphology{{Op: MorphDilate}}
+	converter := NewConverter(ConvertOptions{Morphology: morphology})
+	morphology[0].Op = MorphErode
+	converter.Options().Morphology[0].Op = MorphOpen
+	if op := converter.Options().Morphology[0].Op; op != MorphDilate {
+		t.Fatalf("NewConverter failed: got operation %d, want %d", op, MorphDilate)
+	}
+}
+
+func Test_ConverterConcurrent(t *testing.T) {
+	images := fastPathImages(60, 50)
+	converter := NewConverter(ConvertOptions{GraphicType: Z64, Flatten: FlattenOptions{Alpha: AlphaComposite}})
+	want := map[string]string{}
+	for name, img := range images {
+		want[name], _ = converter.ConvertToZPL(img)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 8*len(images))
+	for i := 0; i < 8; i++ {
+		for name, img := range images {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if got, err := converter.ConvertToZPL(img); err != nil || got != want[name] {
+					errs <- name
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for name := range errs {
+		t.Fatalf("Converter.ConvertToZPL failed for %s: concurrent output differs", name)
+	}
+}
+
+func benchmarkConverter(b *testing.B, graphicType GraphicType, reuse bool) {
+	img := fastPathImages(1200, 1800)["NRGBA"]
+	options := ConvertOptions{GraphicType: graphicType, Flatten: FlattenOptions{Alpha: AlphaComposite}}
+	converter := NewConverter(options)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if reuse {
+			_, _ = converter.ConvertToZPL(img)
+		} else {
+			_, _ = NewConverter(options).ConvertToZPL(img)
+		}
+	}
+}
+
+func Benchmark_ConverterFresh(b *testing.B)     { benchmarkConverter(b, CompressedASCII, false) }
+func Benchmark_ConverterPooled(b *testing.B)    { benchmarkConverter(b, CompressedASCII, true) }
+func Benchmark_ConverterZ64Fresh(b *testing.B)  { benchmarkConverter(b, Z64, false) }
+func Benchmark_ConverterZ64Pooled(b *testing.B) { benchmarkConverter(b, Z64, true) }
+func Benchmark_ConverterPooledParallel(b *testing.B) {
+	img := fastPathImages(1200, 1800)["NRGBA"]
+	converter := NewConverter(ConvertOptions{GraphicType: CompressedASCII})
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, _ = converter.ConvertToZPL(img)
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package zplgfa
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors matched by a *DecodeError of the corresponding DecodeKind with errors.Is.
+var (
+	ErrNoGraphicField     = errors.New("no graphic field")
+	ErrInvalidHeader      = errors.New("invalid graphic field header")
+	ErrInvalidDimensions  = errors.New("invalid graphic field dimensions")
+	ErrInvalidCharacter   = errors.New("invalid graphic data character")
+	ErrInvalidRepeatCount = errors.New("invalid repeat count")
+	ErrMisplacedRowMarker = errors.New("misplaced repeat-line marker")
+	ErrSizeMismatch       = errors.New("graphic data size mismatch")
+	ErrInvalidZ64         = errors.New("invalid Z64 data")
+	ErrChecksumMismatch   = errors.New("Z64 checksum mismatch")
+)
+
+// DecodeKind classifies the errors of decoding graphic fields.
+type DecodeKind int
+
+const (
+	// DecodeNoField is a document without a graphic field, ErrNoGraphicField
+	DecodeNoField DecodeKind = iota
+	// DecodeHeader is a malformed or unsupported header, ErrInvalidHeader
+	DecodeHeader
+	// DecodeDimensions is a declared size that is inconsistent or too large, ErrInvalidDimensions
+	DecodeDimensions
+	// DecodeCharacter is a character that is not part of the data grammar, ErrInvalidCharacter
+	DecodeCharacter
+	// DecodeRepeatCount is a repeat count that is malformed or not followed by a hex digit, ErrInvalidRepeatCount
+	DecodeRepeatCount
+	// DecodeRowMarker is a ':' inside a row or without a previous row, ErrMisplacedRowMarker
+	DecodeRowMarker
+	// DecodeSize is data that doesn't fill the declared rows or exceeds them, ErrSizeMismatch
+	DecodeSize
+	// DecodeZ64 is Z64 data that is not valid base64 or zlib, ErrInvalidZ64
+	DecodeZ64
+	// DecodeChecksum is Z64 data with a wrong CRC, ErrChecksumMismatch
+	DecodeChecksum
+)
+
+var decodeKindErrors = []error{
+	DecodeNoField:     ErrNoGraphicField,
+	DecodeHeader:      ErrInvalidHeader,
+	DecodeDimensions:  ErrInvalidDimensions,
+	DecodeCharacter:   ErrInvalidCharacter,
+	DecodeRepeatCount: ErrInvalidRepeatCount,
+	DecodeRowMarker:   ErrMisplacedRowMarker,
+	DecodeSize:        ErrSizeMismatch,
+	DecodeZ64:         ErrInvalidZ64,
+	DecodeChecksum:    ErrChecksumMismatch,
+}
+
+// Err returns the sentinel error of k.
+func (k DecodeKind) Err() error {
+	if k < 0 || int(k) >= len(decodeKindErrors) {
+		return nil
+	}
+	return decodeKindErrors[k]
+}
+
+func (k DecodeKind) String() string {
+	if err := k.Err(); err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("DecodeKind(%d)", int(k))
+}
+
+// DecodeError is returned for graphic fields that can't be decoded. It matches the sentinel
+// error of its Kind with errors.Is and unwraps to the underlying error, if there is one.
+type DecodeError struct {
+	Kind DecodeKind
+	// Offset is the byte offset of the error in the string or data that was decoded.
+	Offset int
+	// Row is the row of the graphic the error is in, or -1 if it isn't in a row.
+	Row int
+	// Field is the index of the graphic field in the document, counting from 0.
+	Field int
+	// Err describes the error in detail.
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	msg := fmt.Sprintf("%s at offset %d (field %d", e.Kind, e.Offset, e.Field)
+	if e.Row >= 0 {
+		msg += fmt.Sprintf(", row %d", e.Row)
+	}
+	msg += ")"
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Is reports whether target is the sentinel error of the Kind of e.
+func (e *DecodeError) Is(target error) bool {
+	return target != nil && target == e.Kind.Err()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// decodeError returns a *DecodeError outside of the rows with a formatted detail.
+func decodeError(kind DecodeKind, offset int, format string, args ...any) *DecodeError {
+	return &DecodeError{Kind: kind, Offset: offset, Row: -1, Err: fmt.Errorf(format, args...)}
+}
+
+// shiftDecodeError moves the offset of a *DecodeError by offset, for errors found in a part of the decoded string.
+func shiftDecodeError(err error, offset int) error {
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) {
+		decodeErr.Offset += offset
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package zplgfa
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_DecodeError(t *testing.T) {
+	z64, err := EncodeZ64([]byte{0xff, 0x00})
+	if err != nil {
+		t.Fatalf("EncodeZ64 failed: %v", err)
+	}
+	badCRC := "^GFA,2,2,1,\n" + z64[:len(z64)-4] + "0000"
+
+	tests := []struct {
+		zpl    string
+		kind   DecodeKind
+		offset int
+		row    int
+	}{
+		{"^XA^FO0,0^XZ", DecodeNoField, 0, -1},
+		{"^XA\n^FO0,0^GFA,4,x,2,\nFFFF\nFFFF^FS^XZ", DecodeHeader, strings.Index("^XA\n^FO0,0^GFA,4,x,2,", "x"), -1},
+		{"^XA\n^FO0,0^GFA,4,4\n^FS^XZ", DecodeHeader, len("^XA\n^FO0,0^GFA,4,"), -1},
+		{"^XA^GFA\n^FS^XZ", DecodeHeader, len("^XA^GFA"), -1},
+		{"^XA^GFA,9,5,2,\nFF^FS^XZ", DecodeDimensions, strings.Index("^XA^GFA,9,5,2,", "5,"), -1},
+		{"^XA^GFA,4,4,2,\nFFFF\nFFZF^FS^XZ", DecodeCharacter, strings.Index("^XA^GFA,4,4,2,\nFFFF\nFFZF", "Z"), 1},
+		{"^XA^GFA,4,4,2,\nFFFF\nGG0F^FS^XZ", DecodeRepeatCount, strings.Index("^XA^GFA,4,4,2,\nFFFF\nGG", "GG") + 1, 1},
+		{"^XA^GFA,4,4,2,\nFF:F\n^FS^XZ", DecodeRowMarker, strings.Index("^XA^GFA,4,4,2,\nFF:", ":"), 0},
+		{"^XA^GFA,4,4,2,\nFFFF\nFF^FS^XZ", DecodeSize, len("^XA^GFA,4,4,2,\nFFFF\nFF"), 1},
+		{"^XA^GFA,4,4,2,\nFFFF\nFFFF,^FS^XZ", DecodeSize, len("^XA^GFA,4,4,2,\nFFFF\nFFFF"), 2},
+		{"^XA^GFB,4,4,2,\nFF", DecodeSize, len("^XA^GFB,4,4,2,\nFF"), 1},
+		{"^XA^GFA,2,2,1,\n:Z64:@@@@:0000^FS^XZ", DecodeZ64, len("^XA^GFA,2,2,1,\n:Z64:"), -1},
+		{badCRC, DecodeChecksum, len(badCRC) - 4, -1},
+	}
+	for _, test := range tests {
+		_, err := ConvertZPLToImage(test.zpl)
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Fatalf("ConvertZPLToImage failed for %q: got %v, want a *DecodeError", test.zpl, err)
+		}
+		if decodeErr.Kind != test.kind || decodeErr.Offset != test.offset || decodeErr.Row != test.row || decodeErr.Field != 0 {
+			t.Fatalf("ConvertZPLToImage failed for %q: got %s at %d in row %d, want %s at %d in row %d",
+				test.zpl, decodeErr.Kind, decodeErr.Offset, decodeErr.Row, test.kind, test.offset, test.row)
+		}
+		if !errors.Is(err, test.kind.Err()) {
+			t.Fatalf("ConvertZPLToImage failed for %q: %v doesn't match %v", test.zpl, err, test.kind.Err())
+		}
+	}
+}
+
+func Test_DecodeErrorMessage(t *testing.T) {
+	_, err := ConvertGraphicFieldToImage("^GFA,2,2,1,\nFF\nZ0")
+	want := `invalid graphic data character at offset 15 (field 0, row 1): 'Z' is no hex digit, repeat count or row marker`
+	if err == nil || err.Error() != want {
+		t.Fatalf("ConvertGraphicFieldToImage failed: got %v, want %s", err, want)
+	}
+	if errors.Is(err, ErrSizeMismatch) {
+		t.Fatalf("DecodeError failed: %v matches %v", err, ErrSizeMismatch)
+	}
+}
+
+func Test_DecodeErrorGRF(t *testing.T) {
+	grf := "\n~DGR:LOGO.GRF,2,1,\nFF\nFZ\n"
+	_, err := DecodeGRF(strings.NewReader(grf))
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Kind != DecodeCharacter || decodeErr.Offset != strings.Index(grf, "Z") {
+		t.Fatalf("DecodeGRF failed: got %v, want an invalid character at %d", err, strings.Index(grf, "Z"))
+	}
+}
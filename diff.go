@@ -0,0 +1,337 @@
+package zplgfa
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DiffPalette colors the images of LabelDiff: unchanged white and black dots are white and light gray,
+// removed dots red and added dots green.
+var DiffPalette = color.Palette{
+	color.White,
+	color.Gray{Y: 0xc8},
+	color.RGBA{R: 0xe0, G: 0x20, B: 0x20, A: 0xff},
+	color.RGBA{R: 0x20, G: 0xa0, B: 0x20, A: 0xff},
+}
+
+// the indices of DiffPalette
+const (
+	diffWhite uint8 = iota
+	diffBlack
+	diffRemoved
+	diffAdded
+)
+
+// maxDiffEdits bounds the edits DiffZPL looks for between the commands of two documents. Commands that need
+// more edits are shown as removed and added, which keeps the memory of the diff quadratic in this bound.
+const maxDiffEdits = 2000
+
+// LabelDiff compares the dots of a label in two documents.
+type LabelDiff struct {
+	// Label counts the labels of the documents from 0.
+	Label int
+	// Added counts the dots that are black only in the second label, Removed the dots that are black
+	// only in the first one.
+	Added, Removed int
+	// Image shows both labels on top of each other in the colors of DiffPalette. It is as large as both labels.
+	Image *image.Paletted
+}
+
+// Changed returns the number of added and removed dots.
+func (d LabelDiff) Changed() int {
+	return d.Added + d.Removed
+}
+
+// Percent returns the changed dots in percent of all dots of Image.
+func (d LabelDiff) Percent() float64 {
+	dots := d.Image.Rect.Dx() * d.Image.Rect.Dy()
+	if dots == 0 {
+		return 0
+	}
+	return 100 * float64(d.Changed()) / float64(dots)
+}
+
+// DiffLabels compares two labels dot by dot, a nil label is empty.
+func DiffLabels(before, after *Monochrome) LabelDiff {
+	bounds := image.Rectangle{}
+	for _, label := range []*Monochrome{before, after} {
+		if label != nil {
+			bounds = bounds.Union(label.Rect)
+		}
+	}
+	diff := LabelDiff{Image: image.NewPaletted(bounds, DiffPalette)}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		pix := diff.Image.Pix[diff.Image.PixOffset(bounds.Min.X, y):]
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			was := before != nil && before.BlackAt(x, y)
+			is := after != nil && after.BlackAt(x, y)
+			switch {
+			case was && is:
+				pix[x-bounds.Min.X] = diffBlack
+			case was:
+				pix[x-bounds.Min.X] = diffRemoved
+				diff.Removed++
+			case is:
+				pix[x-bounds.Min.X] = diffAdded
+				diff.Added++
+			}
+		}
+	}
+	return diff
+}
+
+// CommandEdit is a line of the command diff of two documents.
+type CommandEdit struct {
+	// Op is ' ' for a command of both documents, '-' for a command only of the first one
+	// and '+' for a command only of the second one.
+	Op byte
+	// Command is the command with the parameters DiffZPL compares, long graphic data is summarized like FormatZPL does.
+	Command string
+}
+
+// ZPLDiff is the difference of two ZPL documents.
+type ZPLDiff struct {
+	// Labels compares the rendered labels, a label missing in one document is compared with an empty one.
+	Labels []LabelDiff
+	// Commands lists the commands of both documents with the edits that turn the first into the second.
+	Commands []CommandEdit
+}
+
+// Equal reports whether no dot and no command changed.
+func (d ZPLDiff) Equal() bool {
+	for _, label := range d.Labels {
+		if label.Changed() != 0 {
+			return false
+		}
+	}
+	for _, edit := range d.Commands {
+		if edit.Op != ' ' {
+			return false
+		}
+	}
+	return true
+}
+
+// CommandDiff returns the changed commands, one per line, with up to context unchanged commands around them.
+// Lines start with the Op of the command and a space, skipped unchanged commands are shown as "...".
+func (d ZPLDiff) CommandDiff(context int) string {
+	var out strings.Builder
+	last := -1
+	for i, edit := range d.Commands {
+		near := false
+		for j := max(i-context, 0); j <= min(i+context, len(d.Commands)-1); j++ {
+			near = near || d.Commands[j].Op != ' '
+		}
+		if !near {
+			continue
+		}
+		if i > last+1 {
+			out.WriteString("...\n")
+		}
+		out.WriteByte(edit.Op)
+		out.WriteByte(' ')
+		out.WriteString(edit.Command)
+		out.WriteByte('\n')
+		last = i
+	}
+	if last != -1 && last < len(d.Commands)-1 {
+		out.WriteString("...\n")
+	}
+	return out.String()
+}
+
+// DiffZPL compares two ZPL documents. The labels of both are drawn with RenderLabels and compared dot by dot,
+// and their commands are compared without formatting: whitespace, comments, prefix changes and the letter case of
+// command names are ignored and graphic fields are compared by their dots, so equivalent encodings are equal.
+func DiffZPL(before, after string) (ZPLDiff, error) {
+	return DiffZPLContext(context.Background(), before, after, Limits{})
+}
+
+// DiffZPLContext is DiffZPL with limits for rendering the documents, stopping with the error of ctx once ctx is done.
+func DiffZPLContext(ctx context.Context, before, after string, limits Limits) (ZPLDiff, error) {
+	var diff ZPLDiff
+	beforeLabels, err := RenderLabelsContext(ctx, before, limits)
+	if err != nil {
+		return diff, fmt.Errorf("first document: %w", err)
+	}
+	afterLabels, err := RenderLabelsContext(ctx, after, limits)
+	if err != nil {
+		return diff, fmt.Errorf("second document: %w", err)
+	}
+	for i := 0; i < max(len(beforeLabels), len(afterLabels)); i++ {
+		var a, b *Monochrome
+		if i < len(beforeLabels) {
+			a = beforeLabels[i]
+		}
+		if i < len(afterLabels) {
+			b = afterLabels[i]
+		}
+		label := DiffLabels(a, b)
+		label.Label = i
+		diff.Labels = append(diff.Labels, label)
+	}
+
+	beforeTexts, beforeKeys, err := semanticCommands(ctx, before, limits)
+	if err != nil {
+		return diff, err
+	}
+	afterTexts, afterKeys, err := semanticCommands(ctx, after, limits)
+	if err != nil {
+		return diff, err
+	}
+	i, j := 0, 0
+	for _, op := range diffCommands(beforeKeys, afterKeys) {
+		switch op {
+		case ' ':
+			diff.Commands = append(diff.Commands, CommandEdit{Op: op, Command: afterTexts[j]})
+			i, j = i+1, j+1
+		case '-':
+			diff.Commands = append(diff.Commands, CommandEdit{Op: op, Command: beforeTexts[i]})
+			i++
+		case '+':
+			diff.Commands = append(diff.Commands, CommandEdit{Op: op, Command: afterTexts[j]})
+			j++
+		}
+	}
+	return diff, nil
+}
+
+// semanticCommands returns the commands of a document that change its printout, normalized like OptimizeZPL
+// does and written with the default prefixes, and the keys to compare them by. Graphic fields are keyed by
+// their dots if they can be decoded and by all of their data otherwise.
+func semanticCommands(ctx context.Context, zpl string, limits Limits) ([]string, []string, error) {
+	var texts, keys []string
+	for _, command := range ParseCommands(zpl) {
+		switch {
+		case command.Prefix == 0, command.Is("^FX"),
+			command.Is("^CC"), command.Is("~CC"), command.Is("^CT"), command.Is("~CT"):
+			continue
+		}
+		command.Params = optimizeParams(command)
+		command.Prefix = '^'
+		if command.Control {
+			command.Prefix = '~'
+		}
+		text, _ := formatCommand(command, FormatOptions{})
+		key := text
+		if command.Is("^GF") {
+			key = command.String()
+			img, err := ConvertGraphicFieldToImageContext(ctx, command.String(), limits)
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return nil, nil, err
+			}
+			if err == nil {
+				key = "^GF" + strconv.Itoa(img.Rect.Dx()) + "x" + strconv.Itoa(img.Rect.Dy()) + ":" + string(img.Pix)
+			}
+		}
+		texts = append(texts, text)
+		keys = append(keys, key)
+	}
+	return texts, keys, nil
+}
+
+// diffCommands returns the operations that turn the commands a into b: ' ' keeps a command, '-' removes one
+// of a and '+' adds one of b. Common commands at the start and end are kept before looking for the shortest
+// edit script of the rest.
+func diffCommands(a, b []string) []byte {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ops := bytes.Repeat([]byte{' '}, prefix)
+	middle, ok := editScript(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix], maxDiffEdits)
+	if !ok {
+		middle = append(bytes.Repeat([]byte{'-'}, len(a)-prefix-suffix), bytes.Repeat([]byte{'+'}, len(b)-prefix-suffix)...)
+	}
+	ops = append(ops, middle...)
+	return append(ops, bytes.Repeat([]byte{' '}, suffix)...)
+}
+
+// editScript returns the shortest edit script that turns a into b with the algorithm of Myers, or false if it
+// needs more than maxEdits edits. Round d keeps the furthest x reached on each diagonal k = x-y with d edits,
+// or -1 if the diagonal can't be reached within a and b.
+func editScript(a, b []string, maxEdits int) ([]byte, bool) {
+	n, m := len(a), len(b)
+	furthest := func(v []int, d, k int) int {
+		if k < -d || k > d {
+			return -1
+		}
+		return v[k+d]
+	}
+	var trace [][]int
+	for d := 0; d <= maxEdits; d++ {
+		v := make([]int, 2*d+1)
+		for k := -d; k <= d; k += 2 {
+			x := 0
+			if d > 0 {
+				down, right := furthest(trace[d-1], d-1, k+1), furthest(trace[d-1], d-1, k-1)
+				switch {
+				case down == -1 && right == -1:
+					v[k+d] = -1
+					continue
+				case right < down:
+					x = down
+				default:
+					x = right + 1
+				}
+			}
+			y := x - k
+			if x > n || y > m {
+				v[k+d] = -1
+				continue
+			}
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[k+d] = x
+			if x == n && y == m {
+				return backtrackEdits(append(trace, v), n, m, furthest), true
+			}
+		}
+		trace = append(trace, v)
+	}
+	return nil, false
+}
+
+// backtrackEdits follows the rounds of editScript back from the end of both sequences.
+func backtrackEdits(trace [][]int, n, m int, furthest func(v []int, d, k int) int) []byte {
+	ops := make([]byte, 0, n+m)
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		k := x - y
+		down, right := furthest(trace[d-1], d-1, k+1), furthest(trace[d-1], d-1, k-1)
+		if right < down {
+			// moved down from diagonal k+1, adding b[y-1] after the snake
+			for x > down {
+				x, y = x-1, y-1
+				ops = append(ops, ' ')
+			}
+			ops = append(ops, '+')
+			y--
+		} else {
+			// moved right from diagonal k-1, removing a[x-1] after the snake
+			for y > right-k+1 {
+				x, y = x-1, y-1
+				ops = append(ops, ' ')
+			}
+			ops = append(ops, '-')
+			x--
+		}
+	}
+	for ; x > 0; x-- {
+		ops = append(ops, ' ')
+	}
+	slices.Reverse(ops)
+	return ops
+}
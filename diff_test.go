@@ -0,0 +1,130 @@
+package zplgfa
+
+import (
+	"image"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func Test_EditScript(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	sequence := func() []string {
+		s := make([]string, random.Intn(12))
+		for i := range s {
+			s[i] = string(rune('a' + random.Intn(3)))
+		}
+		return s
+	}
+	for round := 0; round < 500; round++ {
+		a, b := sequence(), sequence()
+		ops, ok := editScript(a, b, len(a)+len(b))
+		if !ok {
+			t.Fatalf("editScript failed for %v and %v: no script", a, b)
+		}
+
+		// the operations must turn a into b with as few edits as the longest common subsequence allows
+		var got []string
+		i, j, edits := 0, 0, 0
+		for _, op := range ops {
+			switch op {
+			case ' ':
+				if a[i] != b[j] {
+					t.Fatalf("editScript failed for %v and %v: keeps %s for %s", a, b, a[i], b[j])
+				}
+				got = append(got, a[i])
+				i, j = i+1, j+1
+			case '-':
+				i, edits = i+1, edits+1
+			case '+':
+				got = append(got, b[j])
+				j, edits = j+1, edits+1
+			}
+		}
+		lcs := make([][]int, len(a)+1)
+		for x := range lcs {
+			lcs[x] = make([]int, len(b)+1)
+		}
+		for x := len(a) - 1; x >= 0; x-- {
+			for y := len(b) - 1; y >= 0; y-- {
+				if a[x] == b[y] {
+					lcs[x][y] = lcs[x+1][y+1] + 1
+				} else {
+					lcs[x][y] = max(lcs[x+1][y], lcs[x][y+1])
+				}
+			}
+		}
+		if i != len(a) || strings.Join(got, "") != strings.Join(b, "") || edits != len(a)+len(b)-2*lcs[0][0] {
+			t.Fatalf("editScript failed for %v and %v: got %q with %d edits", a, b, ops, edits)
+		}
+	}
+
+	if _, ok := editScript([]string{"a", "b", "c"}, []string{"x", "y", "z"}, 5); ok {
+		t.Fatalf("editScript failed: found a script with more than 5 edits")
+	}
+	ops := diffCommands([]string{"a", "b", "c", "d"}, []string{"a", "x", "y", "d"})
+	if string(ops) != " --++ " {
+		t.Fatalf("diffCommands failed: got %q", ops)
+	}
+}
+
+func Test_DiffLabels(t *testing.T) {
+	before := NewMonochrome(image.Rect(0, 0, 4, 2))
+	after := NewMonochrome(image.Rect(0, 0, 5, 2))
+	before.SetBlack(0, 0, true)
+	before.SetBlack(1, 0, true)
+	after.SetBlack(1, 0, true)
+	after.SetBlack(4, 1, true)
+
+	diff := DiffLabels(before, after)
+	if diff.Added != 1 || diff.Removed != 1 || diff.Image.Rect != image.Rect(0, 0, 5, 2) || diff.Percent() != 20 {
+		t.Fatalf("DiffLabels failed: got %d added and %d removed of %v, %.1f%%", diff.Added, diff.Removed, diff.Image.Rect, diff.Percent())
+	}
+	want := []uint8{diffRemoved, diffBlack, diffWhite, diffWhite, diffWhite, diffWhite, diffWhite, diffWhite, diffWhite, diffAdded}
+	if string(diff.Image.Pix) != string(want) {
+		t.Fatalf("DiffLabels failed: got %v, want %v", diff.Image.Pix, want)
+	}
+	if empty := DiffLabels(nil, after); empty.Added != 2 || empty.Removed != 0 {
+		t.Fatalf("DiffLabels failed: got %d added and %d removed for a new label", empty.Added, empty.Removed)
+	}
+}
+
+func Test_DiffZPL(t *testing.T) {
+	before := transcodeDocument()
+	transcoded, err := TranscodeGraphicFields(before, Z64)
+	if err != nil {
+		t.Fatalf("TranscodeGraphicFields failed: %v", err)
+	}
+	same := "^FX the same label\n" + FormatZPL(transcoded.ZPL, FormatOptions{KeepGraphics: true})
+	diff, err := DiffZPL(before, same)
+	if err != nil || !diff.Equal() || diff.CommandDiff(2) != "" {
+		t.Fatalf("DiffZPL failed: a transcoded and formatted document differs (%v):\n%s", err, diff.CommandDiff(2))
+	}
+
+	after := strings.Replace(before, "^FO10,300", "^FO12,300", 1)
+	after = strings.Replace(after, "Hello ~ World", "Hello World", 1)
+	diff, err = DiffZPL(before, after)
+	if err != nil || diff.Equal() || len(diff.Labels) != 1 {
+		t.Fatalf("DiffZPL failed: got %d labels, equal %v (%v)", len(diff.Labels), diff.Equal(), err)
+	}
+	if label := diff.Labels[0]; label.Added == 0 || label.Added != label.Removed || label.Percent() <= 0 {
+		t.Fatalf("DiffZPL failed: got %d added and %d removed dots", label.Added, label.Removed)
+	}
+	want := "...\n" +
+		"  ^FO20,200\n" +
+		"  ^A0N,30,30\n" +
+		"- ^FDHello \n" +
+		"- ~ World\n" +
+		"+ ^FDHello World\n" +
+		"  ^FS\n" +
+		"- ^FO10,300\n" +
+		"+ ^FO12,300\n"
+	if got := diff.CommandDiff(2); !strings.HasPrefix(got, want) {
+		t.Fatalf("DiffZPL failed: got\n%s\nwant\n%s", got, want)
+	}
+
+	diff, err = DiffZPL(before, before+before)
+	if err != nil || len(diff.Labels) != 2 || diff.Labels[1].Removed != 0 || diff.Labels[1].Added == 0 {
+		t.Fatalf("DiffZPL failed: got %d labels for an added label (%v)", len(diff.Labels), err)
+	}
+}
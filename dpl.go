@@ -0,0 +1,127 @@
+package zplgfa
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image"
+	"strings"
+)
+
+// DPLFormat is a type to select the image format of a DPL image download
+type DPLFormat int
+
+const (
+	// DPLImage sends the image in the 7-bit Datamax image load format
+	DPLImage DPLFormat = iota
+	// DPLBMP sends the image as a monochrome .BMP file
+	DPLBMP
+	// DPLPCX sends the image as a monochrome .PCX file
+	DPLPCX
+)
+
+// DPLOptions configures DPL output created by ConvertToDPLImage and ConvertToDPLLabel.
+type DPLOptions struct {
+	Format DPLFormat
+	// Name is the image name on the printer, up to 16 characters. Defaults to "ZPLGFA".
+	Name string
+	// Module is the memory module designator. Defaults to 'D'.
+	Module byte
+	// ASCIIHex sends .BMP and .PCX data as 7-bit ASCII hex instead of 8-bit binary.
+	ASCIIHex bool
+	// X and Y are the column and row of the image in the label format, in printer units.
+	X int
+	Y int
+}
+
+const (
+	dplSTX          = "\x02"
+	dplMaxNameLen   = 16
+	dplMaxRecordLen = 0xff
+)
+
+// ConvertToDPLImage converts an image.Image to a DPL <STX>I image download command
+// for Datamax and Honeywell printers.
+func ConvertToDPLImage(img image.Image, options DPLOptions) (string, error) {
+	if img == nil || img.Bounds().Dx() == 0 || img.Bounds().Dy() == 0 {
+		return "", fmt.Errorf("empty image")
+	}
+	options, err := dplDefaults(options)
+	if err != nil {
+		return "", err
+	}
+
+	raw, bytesPerRow := packImage(img)
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	var file []byte
+	var format string
+	switch options.Format {
+	case DPLImage:
+		data, err := encodeDatamaxImage(raw, bytesPerRow, height)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%sI%cF%s\r%s", dplSTX, options.Module, options.Name, data), nil
+	case DPLBMP:
+		file, format = encodeBMP(raw, bytesPerRow, width, height), "B"
+	case DPLPCX:
+		file, format = encodePCX(raw, bytesPerRow, width, height), "P"
+	default:
+		return "", fmt.Errorf("unsupported DPL format %d", options.Format)
+	}
+
+	if options.ASCIIHex {
+		return fmt.Sprintf("%sI%cA%s%s\r%s\r", dplSTX, options.Module, format, options.Name, strings.ToUpper(hex.EncodeToString(file))), nil
+	}
+	return fmt.Sprintf("%sI%c%s%s\r%s", dplSTX, options.Module, format, options.Name, file), nil
+}
+
+// ConvertToDPLLabel wraps ConvertToDPLImage, adding a label format that prints the image.
+func ConvertToDPLLabel(img image.Image, options DPLOptions) (string, error) {
+	download, err := ConvertToDPLImage(img, options)
+	if err != nil {
+		return "", err
+	}
+	return download + DPLLabelFormat(options), nil
+}
+
+// DPLLabelFormat returns the DPL label format records that place a downloaded image.
+func DPLLabelFormat(options DPLOptions) string {
+	options, _ = dplDefaults(options)
+	return fmt.Sprintf("%sL\rD11\r1Y11000%04d%04d%s\rE\r", dplSTX, options.Y, options.X, options.Name)
+}
+
+func dplDefaults(options DPLOptions) (DPLOptions, error) {
+	if options.Name == "" {
+		options.Name = "ZPLGFA"
+	}
+	if options.Module == 0 {
+		options.Module = 'D'
+	}
+	if len(options.Name) > dplMaxNameLen {
+		return options, fmt.Errorf("DPL image name %q is longer than %d characters", options.Name, dplMaxNameLen)
+	}
+	for _, r := range options.Name {
+		if r < 0x20 || r > 0x7e {
+			return options, fmt.Errorf("invalid DPL image name %q", options.Name)
+		}
+	}
+	return options, nil
+}
+
+// encodeDatamaxImage writes packed rows as 7-bit Datamax image load records.
+// Each row is a "80" record with its byte count in hex, followed by the row
+// data in hex. DPL measures rows from the bottom of the label, so the bottom
+// row is sent first. The image is terminated by a "FFFF" record.
+func encodeDatamaxImage(raw []byte, bytesPerRow, height int) (string, error) {
+	if bytesPerRow > dplMaxRecordLen {
+		return "", fmt.Errorf("image row of %d bytes exceeds the DPL record limit of %d bytes", bytesPerRow, dplMaxRecordLen)
+	}
+
+	var data strings.Builder
+	for y := height - 1; y >= 0; y-- {
+		fmt.Fprintf(&data, "80%02X%s\r", bytesPerRow, strings.ToUpper(hex.EncodeToString(raw[y*bytesPerRow:(y+1)*bytesPerRow])))
+	}
+	data.WriteString("FFFF\r")
+	return data.String(), nil
+}
@@ -0,0 +1,74 @@
+package zplgfa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func Test_ConvertToDPLImage(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 10, 2))
+	fillGray(img, color.White)
+	img.Set(0, 0, color.Black)
+	img.Set(9, 1, color.Black)
+
+	got, err := ConvertToDPLImage(img, DPLOptions{Name: "LOGO"})
+	if err != nil {
+		t.Fatalf("ConvertToDPLImage failed: %s", err)
+	}
+	want := "\x02IDFLOGO\r80020040\r80028000\rFFFF\r"
+	if got != want {
+		t.Fatalf("ConvertToDPLImage failed:\nExpected:\n%q\nGot:\n%q", want, got)
+	}
+}
+
+func Test_ConvertToDPLLabel(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 8, 1))
+
+	got, err := ConvertToDPLLabel(img, DPLOptions{Format: DPLBMP, ASCIIHex: true, X: 25, Y: 100})
+	if err != nil {
+		t.Fatalf("ConvertToDPLLabel failed: %s", err)
+	}
+	if !strings.HasPrefix(got, "\x02IDABZPLGFA\r424D") {
+		t.Fatalf("ConvertToDPLLabel download failed: got %q", got)
+	}
+	if want := "\x02L\rD11\r1Y1100001000025ZPLGFA\rE\r"; !strings.HasSuffix(got, want) {
+		t.Fatalf("ConvertToDPLLabel format failed:\nExpected suffix:\n%q\nGot:\n%q", want, got)
+	}
+}
+
+func Test_ConvertToDPLImageErrors(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 8, 1))
+	if _, err := ConvertToDPLImage(img, DPLOptions{Name: "THIS_NAME_IS_TOO_LONG"}); err == nil {
+		t.Fatal("ConvertToDPLImage should fail for long image names")
+	}
+	if _, err := ConvertToDPLImage(image.NewGray(image.Rect(0, 0, 8*256, 1)), DPLOptions{}); err == nil {
+		t.Fatal("ConvertToDPLImage should fail for rows longer than a record")
+	}
+}
+
+func Test_EncodeBMP(t *testing.T) {
+	raw := []byte{0x80, 0x01}
+	got := encodeBMP(raw, 1, 8, 2)
+	if !bytes.HasPrefix(got, []byte("BM")) || int(binary.LittleEndian.Uint32(got[2:])) != len(got) {
+		t.Fatalf("encodeBMP header failed: % X", got[:14])
+	}
+	// rows are stored bottom-up and padded to four bytes
+	if pixels := got[62:]; !bytes.Equal(pixels, []byte{0x01, 0, 0, 0, 0x80, 0, 0, 0}) {
+		t.Fatalf("encodeBMP pixels failed: % X", pixels)
+	}
+}
+
+func Test_EncodePCX(t *testing.T) {
+	raw := []byte{0x00, 0x00, 0xff, 0x00}
+	got := encodePCX(raw, 2, 12, 2)
+	if len(got) < 128 || got[0] != 0x0a || got[3] != 1 || got[65] != 1 {
+		t.Fatalf("encodePCX header failed: % X", got[:4])
+	}
+	if data := got[128:]; !bytes.Equal(data, []byte{0xc2, 0xff, 0x00, 0xc1, 0xff}) {
+		t.Fatalf("encodePCX data failed: % X", data)
+	}
+}
@@ -0,0 +1,165 @@
+package zplgfa
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+)
+
+// Orientation is the EXIF orientation of an image, describing how it has to be
+// rotated or mirrored to be displayed upright.
+type Orientation int
+
+const (
+	// OrientationNormal needs no transformation
+	OrientationNormal Orientation = iota + 1
+	// OrientationMirrorHorizontal mirrors the image horizontally
+	OrientationMirrorHorizontal
+	// OrientationRotate180 rotates the image by 180 degrees
+	OrientationRotate180
+	// OrientationMirrorVertical mirrors the image vertically
+	OrientationMirrorVertical
+	// OrientationTranspose mirrors the image along its top-left to bottom-right diagonal
+	OrientationTranspose
+	// OrientationRotate90 rotates the image by 90 degrees clockwise
+	OrientationRotate90
+	// OrientationTransverse mirrors the image along its top-right to bottom-left diagonal
+	OrientationTransverse
+	// OrientationRotate270 rotates the image by 270 degrees clockwise
+	OrientationRotate270
+)
+
+const exifOrientationTag = 274
+
+// String describes the transformation applied for the orientation.
+func (o Orientation) String() string {
+	switch o {
+	case OrientationNormal:
+		return "none"
+	case OrientationMirrorHorizontal:
+		return "mirror horizontal"
+	case OrientationRotate180:
+		return "rotate 180°"
+	case OrientationMirrorVertical:
+		return "mirror vertical"
+	case OrientationTranspose:
+		return "transpose"
+	case OrientationRotate90:
+		return "rotate 90° clockwise"
+	case OrientationTransverse:
+		return "transverse"
+	case OrientationRotate270:
+		return "rotate 270° clockwise"
+	default:
+		return fmt.Sprintf("Orientation(%d)", int(o))
+	}
+}
+
+// ReadOrientation reads the EXIF orientation from JPEG APP1 or TIFF data.
+// Data without an orientation returns OrientationNormal.
+func ReadOrientation(r io.Reader) (Orientation, error) {
+	reader := bufio.NewReader(r)
+	magic, err := reader.Peek(4)
+	if err != nil {
+		return OrientationNormal, nil
+	}
+	if isTIFF(magic) {
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return OrientationNormal, err
+		}
+		return tiffOrientation(data)
+	}
+	if magic[0] != 0xff || magic[1] != 0xd8 {
+		return OrientationNormal, nil
+	}
+
+	if _, err := reader.Discard(2); err != nil {
+		return OrientationNormal, err
+	}
+	for {
+		marker := make([]byte, 4)
+		if _, err := io.ReadFull(reader, marker); err != nil {
+			return OrientationNormal, fmt.Errorf("invalid JPEG marker: %w", err)
+		}
+		if marker[0] != 0xff {
+			return OrientationNormal, fmt.Errorf("invalid JPEG marker %02X%02X", marker[0], marker[1])
+		}
+		// the metadata segments end with the start of the image data
+		if marker[1] == 0xda || marker[1] == 0xd9 {
+			return OrientationNormal, nil
+		}
+
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return OrientationNormal, fmt.Errorf("invalid JPEG segment length")
+		}
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(reader, segment); err != nil {
+			return OrientationNormal, fmt.Errorf("JPEG segment too short: %w", err)
+		}
+		if marker[1] == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+	}
+}
+
+// tiffOrientation returns the orientation tag of the first TIFF directory.
+func tiffOrientation(data []byte) (Orientation, error) {
+	file, offset, err := newTIFFFile(data)
+	if err != nil {
+		return OrientationNormal, err
+	}
+	ifd, _, err := file.readIFD(offset)
+	if err != nil {
+		return OrientationNormal, err
+	}
+	orientation := Orientation(ifd.value(exifOrientationTag, uint32(OrientationNormal)))
+	if orientation < OrientationNormal || orientation > OrientationRotate270 {
+		return OrientationNormal, fmt.Errorf("invalid EXIF orientation %d", orientation)
+	}
+	return orientation, nil
+}
+
+// ApplyOrientation rotates or mirrors an image so it is displayed upright.
+// OrientationNormal and unknown orientations return the image unchanged.
+func ApplyOrientation(img image.Image, orientation Orientation) image.Image {
+	if orientation <= OrientationNormal || orientation > OrientationRotate270 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dstW, dstH := w, h
+	if orientation >= OrientationTranspose {
+		dstW, dstH = h, w
+	}
+
+	target := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+	for dy := 0; dy < dstH; dy++ {
+		for dx := 0; dx < dstW; dx++ {
+			var sx, sy int
+			switch orientation {
+			case OrientationMirrorHorizontal:
+				sx, sy = w-1-dx, dy
+			case OrientationRotate180:
+				sx, sy = w-1-dx, h-1-dy
+			case OrientationMirrorVertical:
+				sx, sy = dx, h-1-dy
+			case OrientationTranspose:
+				sx, sy = dy, dx
+			case OrientationRotate90:
+				sx, sy = dy, h-1-dx
+			case OrientationTransverse:
+				sx, sy = w-1-dy, h-1-dx
+			case OrientationRotate270:
+				sx, sy = w-1-dy, dx
+			}
+			target.Set(dx, dy, img.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+	return target
+}
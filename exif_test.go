@@ -0,0 +1,119 @@
+package zplgfa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// jpegWithOrientation encodes img as JPEG with an EXIF APP1 segment holding the orientation.
+func jpegWithOrientation(t *testing.T, img image.Image, orientation Orientation) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("Failed to encode JPEG: %s", err)
+	}
+
+	exif := []byte("Exif\x00\x00MM\x00*\x00\x00\x00\x08\x00\x01")
+	exif = binary.BigEndian.AppendUint16(exif, exifOrientationTag)
+	exif = binary.BigEndian.AppendUint16(exif, 3)
+	exif = binary.BigEndian.AppendUint32(exif, 1)
+	exif = binary.BigEndian.AppendUint16(exif, uint16(orientation))
+	exif = append(exif, 0, 0, 0, 0, 0, 0)
+
+	segment := []byte{0xff, 0xe1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(exif)+2))
+	segment = append(segment, exif...)
+
+	data := encoded.Bytes()
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+func Test_ReadOrientation(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	for orientation := OrientationNormal; orientation <= OrientationRotate270; orientation++ {
+		got, err := ReadOrientation(bytes.NewReader(jpegWithOrientation(t, img, orientation)))
+		if err != nil {
+			t.Fatalf("ReadOrientation failed: %s", err)
+		}
+		if got != orientation {
+			t.Fatalf("ReadOrientation failed: got %s, want %s", got, orientation)
+		}
+	}
+
+	var plain bytes.Buffer
+	if err := jpeg.Encode(&plain, img, nil); err != nil {
+		t.Fatalf("Failed to encode JPEG: %s", err)
+	}
+	if got, err := ReadOrientation(&plain); err != nil || got != OrientationNormal {
+		t.Fatalf("ReadOrientation without EXIF failed: got %s, %v", got, err)
+	}
+}
+
+func Test_ApplyOrientation(t *testing.T) {
+	// 0 1 2
+	// 3 4 5
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+
+	tests := map[Orientation][]uint8{
+		OrientationNormal:           {0, 1, 2, 3, 4, 5},
+		OrientationMirrorHorizontal: {2, 1, 0, 5, 4, 3},
+		OrientationRotate180:        {5, 4, 3, 2, 1, 0},
+		OrientationMirrorVertical:   {3, 4, 5, 0, 1, 2},
+		OrientationTranspose:        {0, 3, 1, 4, 2, 5},
+		OrientationRotate90:         {3, 0, 4, 1, 5, 2},
+		OrientationTransverse:       {5, 2, 4, 1, 3, 0},
+		OrientationRotate270:        {2, 5, 1, 4, 0, 3},
+	}
+
+	for orientation, want := range tests {
+		got := ApplyOrientation(img, orientation)
+		bounds := got.Bounds()
+		var pixels []uint8
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				pixels = append(pixels, color.GrayModel.Convert(got.At(x, y)).(color.Gray).Y)
+			}
+		}
+		if !bytes.Equal(pixels, want) {
+			t.Fatalf("ApplyOrientation %s failed: got %v, want %v", orientation, pixels, want)
+		}
+	}
+}
+
+func Test_ConvertReaderToZPLWithOptionsOrientation(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 16, 8))
+	fillGray(img, color.White)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, color.Black)
+		}
+	}
+	data := jpegWithOrientation(t, img, OrientationRotate90)
+
+	result, err := ConvertReaderToZPLWithOptions(bytes.NewReader(data), ConvertOptions{GraphicType: ASCII})
+	if err != nil {
+		t.Fatalf("ConvertReaderToZPLWithOptions failed: %s", err)
+	}
+	if result.Orientation != OrientationRotate90 || result.Format != "jpeg" || result.Width != 8 || result.Height != 16 {
+		t.Fatalf("ConvertReaderToZPLWithOptions failed: got %s %s %dx%d", result.Orientation, result.Format, result.Width, result.Height)
+	}
+	want := "^XA,^FS\n^FO0,0\n^GFA,48,16,1,\nFF\nFF\nFF\nFF\nFF\nFF\nFF\nFF\n00\n00\n00\n00\n00\n00\n00\n00\n^FS,^XZ\n"
+	if result.ZPL != want {
+		t.Fatalf("ConvertReaderToZPLWithOptions failed:\nExpected:\n%s\nGot:\n%s", want, result.ZPL)
+	}
+
+	result, err = ConvertReaderToZPLWithOptions(bytes.NewReader(data), ConvertOptions{IgnoreOrientation: true})
+	if err != nil {
+		t.Fatalf("ConvertReaderToZPLWithOptions failed: %s", err)
+	}
+	if result.Orientation != OrientationNormal || result.Width != 16 || result.Height != 8 {
+		t.Fatalf("ConvertReaderToZPLWithOptions with IgnoreOrientation failed: got %s %dx%d", result.Orientation, result.Width, result.Height)
+	}
+}
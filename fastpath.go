@@ -0,0 +1,126 @@
+package zplgfa
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Bilevel is implemented by images that only contain black and white pixels.
+// The encoder reads them with BlackAt instead of converting every pixel to gray.
+type Bilevel interface {
+	image.Image
+	// BlackAt reports whether the pixel at x, y prints black.
+	BlackAt(x, y int) bool
+}
+
+// gray16Black reports whether premultiplied color values are darker than half,
+// with the same rounding as color.Gray16Model.
+func gray16Black(r, g, b uint32) bool {
+	return (19595*r+38470*g+7471*b+1<<15)>>16 < math.MaxUint16/2
+}
+
+// rgba64Reader fills row with the premultiplied r, g, b and a values of every pixel of row y.
+type rgba64Reader func(y int, row []uint32)
+
+// newRGBA64Reader returns a reader for the image types that have one, avoiding image.At and its allocations.
+func newRGBA64Reader(img image.Image) rgba64Reader {
+	b := img.Bounds()
+	switch src := img.(type) {
+	case *image.Gray:
+		return func(y int, row []uint32) {
+			pix := src.Pix[src.PixOffset(b.Min.X, y):]
+			for x := 0; x < b.Dx(); x++ {
+				v := uint32(pix[x]) * 0x101
+				row[4*x], row[4*x+1], row[4*x+2], row[4*x+3] = v, v, v, 0xffff
+			}
+		}
+	case *image.NRGBA:
+		return func(y int, row []uint32) {
+			pix := src.Pix[src.PixOffset(b.Min.X, y):]
+			for x := 0; x < b.Dx(); x++ {
+				p := pix[4*x : 4*x+4 : 4*x+4]
+				row[4*x], row[4*x+1], row[4*x+2], row[4*x+3] = color.NRGBA{p[0], p[1], p[2], p[3]}.RGBA()
+			}
+		}
+	case *image.RGBA:
+		return func(y int, row []uint32) {
+			pix := src.Pix[src.PixOffset(b.Min.X, y):]
+			for x := 0; x < b.Dx(); x++ {
+				p := pix[4*x : 4*x+4 : 4*x+4]
+				row[4*x], row[4*x+1], row[4*x+2], row[4*x+3] = color.RGBA{p[0], p[1], p[2], p[3]}.RGBA()
+			}
+		}
+	case *image.YCbCr:
+		return func(y int, row []uint32) {
+			for x := 0; x < b.Dx(); x++ {
+				yi, ci := src.YOffset(b.Min.X+x, y), src.COffset(b.Min.X+x, y)
+				row[4*x], row[4*x+1], row[4*x+2], row[4*x+3] = color.YCbCr{src.Y[yi], src.Cb[ci], src.Cr[ci]}.RGBA()
+			}
+		}
+	case *image.Paletted:
+		palette := make([][4]uint32, 256)
+		for i, c := range src.Palette {
+			palette[i][0], palette[i][1], palette[i][2], palette[i][3] = c.RGBA()
+		}
+		return func(y int, row []uint32) {
+			pix := src.Pix[src.PixOffset(b.Min.X, y):]
+			for x := 0; x < b.Dx(); x++ {
+				copy(row[4*x:4*x+4], palette[pix[x]][:])
+			}
+		}
+	}
+	return nil
+}
+
+// blackRowReader fills row with the threshold of every pixel of row y, true for black.
+type blackRowReader func(y int, row []bool)
+
+// newBlackRowReader returns a reader that thresholds img like color.Gray16Model, using fast paths for common image types.
+func newBlackRowReader(img image.Image) blackRowReader {
+	b := img.Bounds()
+	switch src := img.(type) {
+	case *image.Gray:
+		return func(y int, row []bool) {
+			pix := src.Pix[src.PixOffset(b.Min.X, y):]
+			for x := range row {
+				// Y*0x101 < math.MaxUint16/2 holds for Y < 128
+				row[x] = pix[x] < 0x80
+			}
+		}
+	case *image.Paletted:
+		var black [256]bool
+		for i, c := range src.Palette {
+			r, g, bl, _ := c.RGBA()
+			black[i] = gray16Black(r, g, bl)
+		}
+		return func(y int, row []bool) {
+			pix := src.Pix[src.PixOffset(b.Min.X, y):]
+			for x := range row {
+				row[x] = black[pix[x]]
+			}
+		}
+	case Bilevel:
+		return func(y int, row []bool) {
+			for x := range row {
+				row[x] = src.BlackAt(b.Min.X+x, y)
+			}
+		}
+	}
+
+	if read := newRGBA64Reader(img); read != nil {
+		values := make([]uint32, 4*b.Dx())
+		return func(y int, row []bool) {
+			read(y, values)
+			for x := range row {
+				row[x] = gray16Black(values[4*x], values[4*x+1], values[4*x+2])
+			}
+		}
+	}
+
+	return func(y int, row []bool) {
+		for x := range row {
+			row[x] = color.Gray16Model.Convert(img.At(b.Min.X+x, y)).(color.Gray16).Y < math.MaxUint16/2
+		}
+	}
+}
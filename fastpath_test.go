@@ -0,0 +1,146 @@
+package zplgfa
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// genericImage hides the concrete type of an image so that only image.At is used.
+type genericImage struct {
+	image.Image
+}
+
+// bilevelImage is a black and white image implementing Bilevel.
+type bilevelImage struct {
+	*image.Gray
+}
+
+func (b bilevelImage) BlackAt(x, y int) bool {
+	return b.GrayAt(x, y).Y < 0x80
+}
+
+func fastPathImages(width, height int) map[string]image.Image {
+	rnd := rand.New(rand.NewSource(1))
+	rect := image.Rect(3, 5, 3+width, 5+height)
+
+	gray := image.NewGray(rect)
+	nrgba := image.NewNRGBA(rect)
+	rgba := image.NewRGBA(rect)
+	rnd.Read(gray.Pix)
+	rnd.Read(nrgba.Pix)
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		a := uint8(rnd.Intn(256))
+		rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2], rgba.Pix[i+3] = uint8(rnd.Intn(int(a)+1)), uint8(rnd.Intn(int(a)+1)), uint8(rnd.Intn(int(a)+1)), a
+	}
+	ycbcr := image.NewYCbCr(rect, image.YCbCrSubsampleRatio420)
+	rnd.Read(ycbcr.Y)
+	rnd.Read(ycbcr.Cb)
+	rnd.Read(ycbcr.Cr)
+	paletted := image.NewPaletted(rect, append(palette.Plan9[:200:200], color.NRGBA64{0x8000, 0x4000, 0xffff, 0x7000}, color.Transparent))
+	for i := range paletted.Pix {
+		paletted.Pix[i] = uint8(rnd.Intn(len(paletted.Palette)))
+	}
+	bilevel := bilevelImage{image.NewGray(rect)}
+	for i := range bilevel.Pix {
+		bilevel.Pix[i] = uint8(rnd.Intn(2) * 0xff)
+	}
+
+	monochrome := ConvertToMonochrome(bilevel)
+
+	return map[string]image.Image{"Gray": gray, "NRGBA": nrgba, "RGBA": rgba, "YCbCr": ycbcr, "Paletted": paletted, "Bilevel": bilevel, "Monochrome": monochrome}
+}
+
+func Test_FastPathsMatchGeneric(t *testing.T) {
+	for name, img := range fastPathImages(37, 11) {
+		generic := genericImage{img}
+
+		for _, graphicType := range []GraphicType{ASCII, CompressedASCII, Binary, Z64} {
+			got, err := ConvertToGraphicFieldWithError(img, graphicType)
+			want, err2 := ConvertToGraphicFieldWithError(generic, graphicType)
+			if err != nil || err2 != nil || got != want {
+				t.Fatalf("ConvertToGraphicField failed for %s: fast path differs from image.At", name)
+			}
+		}
+
+		if got, want := ConvertToLineFields(img, ConvertOptions{X: 1, Y: 2}), ConvertToLineFields(generic, ConvertOptions{X: 1, Y: 2}); got != want {
+			t.Fatalf("ConvertToLineFields failed for %s: fast path differs from image.At", name)
+		}
+
+		for _, options := range []FlattenOptions{{}, {Background: color.NRGBA{0x20, 0x40, 0x60, 0x80}}, {Alpha: AlphaComposite}, {Alpha: AlphaMask, AlphaThreshold: 100}} {
+			got := FlattenImageWithOptions(img, options)
+			want := FlattenImageWithOptions(generic, options)
+			if got.Rect != want.Rect || !bytes.Equal(got.Pix, want.Pix) {
+				t.Fatalf("FlattenImageWithOptions %+v failed for %s: fast path differs from image.At", options, name)
+			}
+		}
+	}
+}
+
+func Test_FastPathsMatchGray16Model(t *testing.T) {
+	// The generic path must keep matching the threshold the encoder always used.
+	img := fastPathImages(64, 4)["NRGBA"]
+	raw, width := packImage(genericImage{img})
+	b := img.Bounds()
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			want := color.Gray16Model.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray16).Y < math.MaxUint16/2
+			if got := raw[y*width+x/8]>>(7-uint(x)%8)&1 == 1; got != want {
+				t.Fatalf("packImage failed at %d,%d: got %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func benchmarkFastPath(b *testing.B, name string, generic bool, fn func(img image.Image)) {
+	var img image.Image = fastPathImages(1200, 1800)[name]
+	if generic {
+		img = genericImage{img}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fn(img)
+	}
+}
+
+func convertLabel(img image.Image) {
+	_ = ConvertToZPL(img, CompressedASCII)
+}
+
+func flattenLabel(img image.Image) {
+	_ = FlattenImage(img)
+}
+
+func lineLabel(img image.Image) {
+	_ = ConvertToLineFields(img, ConvertOptions{})
+}
+
+func Benchmark_ConvertToZPLGeneric(b *testing.B) { benchmarkFastPath(b, "NRGBA", true, convertLabel) }
+func Benchmark_ConvertToZPLGray(b *testing.B)    { benchmarkFastPath(b, "Gray", false, convertLabel) }
+func Benchmark_ConvertToZPLNRGBA(b *testing.B)   { benchmarkFastPath(b, "NRGBA", false, convertLabel) }
+func Benchmark_ConvertToZPLRGBA(b *testing.B)    { benchmarkFastPath(b, "RGBA", false, convertLabel) }
+func Benchmark_ConvertToZPLYCbCr(b *testing.B)   { benchmarkFastPath(b, "YCbCr", false, convertLabel) }
+func Benchmark_ConvertToZPLPaletted(b *testing.B) {
+	benchmarkFastPath(b, "Paletted", false, convertLabel)
+}
+func Benchmark_ConvertToZPLBilevel(b *testing.B) {
+	benchmarkFastPath(b, "Bilevel", false, convertLabel)
+}
+func Benchmark_ConvertToZPLMonochrome(b *testing.B) {
+	benchmarkFastPath(b, "Monochrome", false, convertLabel)
+}
+func Benchmark_FlattenImageGeneric(b *testing.B) { benchmarkFastPath(b, "NRGBA", true, flattenLabel) }
+func Benchmark_FlattenImageNRGBA(b *testing.B)   { benchmarkFastPath(b, "NRGBA", false, flattenLabel) }
+func Benchmark_FlattenImageYCbCr(b *testing.B)   { benchmarkFastPath(b, "YCbCr", false, flattenLabel) }
+func Benchmark_ConvertToLineFieldsGeneric(b *testing.B) {
+	benchmarkFastPath(b, "Bilevel", true, lineLabel)
+}
+func Benchmark_ConvertToLineFieldsBilevel(b *testing.B) {
+	benchmarkFastPath(b, "Bilevel", false, lineLabel)
+}
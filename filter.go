@@ -0,0 +1,387 @@
+package zplgfa
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Filter transforms an image before it is converted. Filters return a new image and never modify their input.
+type Filter interface {
+	Apply(img image.Image) image.Image
+}
+
+// FilterFunc adapts an ordinary function to the Filter interface.
+type FilterFunc func(img image.Image) image.Image
+
+// Apply calls f(img).
+func (f FilterFunc) Apply(img image.Image) image.Image {
+	return f(img)
+}
+
+// Pipeline is a Filter that applies its filters one after another.
+type Pipeline []Filter
+
+// Apply runs img through all filters of the pipeline.
+func (p Pipeline) Apply(img image.Image) image.Image {
+	for _, filter := range p {
+		img = filter.Apply(img)
+	}
+	return img
+}
+
+// filterParsers maps the filter names understood by ParseFilters to their constructors.
+var filterParsers = map[string]func(params []float64) (Filter, error){
+	"invert": func(params []float64) (Filter, error) { return Invert(), checkParams("invert", params, 0) },
+	"monochrome": func(params []float64) (Filter, error) {
+		return MonochromeFilter(), checkParams("monochrome", params, 0)
+	},
+	"edge":    func(params []float64) (Filter, error) { return Edge(), checkParams("edge", params, 0) },
+	"sharpen": func(params []float64) (Filter, error) { return Sharpen(), checkParams("sharpen", params, 0) },
+	"segment": func(params []float64) (Filter, error) {
+		if err := checkParams("segment", params, 0, 1); err != nil {
+			return nil, err
+		}
+		level := 128.0
+		if len(params) == 1 {
+			level = params[0]
+		}
+		if level < 0 || level > 255 {
+			return nil, fmt.Errorf("filter \"segment\": level %g is out of range 0-255", level)
+		}
+		return Segment(uint8(level)), nil
+	},
+	"blur": func(params []float64) (Filter, error) {
+		if err := checkParams("blur", params, 0, 1); err != nil {
+			return nil, err
+		}
+		radius := 0.0
+		if len(params) == 1 {
+			radius = params[0]
+		}
+		return Blur(radius), nil
+	},
+	"brightness": func(params []float64) (Filter, error) {
+		if err := checkParams("brightness", params, 1); err != nil {
+			return nil, err
+		}
+		return Brightness(params[0]), nil
+	},
+	"contrast": func(params []float64) (Filter, error) {
+		if err := checkParams("contrast", params, 1); err != nil {
+			return nil, err
+		}
+		return Contrast(params[0]), nil
+	},
+	"gamma": func(params []float64) (Filter, error) {
+		if err := checkParams("gamma", params, 1); err != nil {
+			return nil, err
+		}
+		if params[0] <= 0 {
+			return nil, fmt.Errorf("filter \"gamma\": gamma must be positive")
+		}
+		return Gamma(params[0]), nil
+	},
+	"crop": func(params []float64) (Filter, error) {
+		if err := checkParams("crop", params, 4); err != nil {
+			return nil, err
+		}
+		x, y := int(params[0]), int(params[1])
+		return Crop(image.Rect(x, y, x+int(params[2]), y+int(params[3]))), nil
+	},
+	"pad": func(params []float64) (Filter, error) {
+		if err := checkParams("pad", params, 1, 4); err != nil {
+			return nil, err
+		}
+		if len(params) == 1 {
+			return Pad(int(params[0]), int(params[0]), int(params[0]), int(params[0])), nil
+		}
+		return Pad(int(params[0]), int(params[1]), int(params[2]), int(params[3])), nil
+	},
+}
+
+func checkParams(name string, params []float64, counts ...int) error {
+	for _, count := range counts {
+		if len(params) == count {
+			return nil
+		}
+	}
+	return fmt.Errorf("filter \"%s\": unexpected number of parameters: %d", name, len(params))
+}
+
+// ParseFilters builds a pipeline from a comma separated list of filter names with colon separated parameters,
+// e.g. "monochrome,blur:2,crop:10:10:200:100". The filters are applied in the given order:
+//
+//	invert                 invert all colors
+//	monochrome             turn every pixel black or white
+//	segment[:level]        threshold the luminance at level (default 128)
+//	blur[:radius]          gaussian blur, the radius defaults to 1/300 of the image width
+//	edge                   sobel edge detection
+//	sharpen                sharpen edges
+//	brightness:change      change the brightness by -1 to 1
+//	contrast:change        change the contrast by -1 to 1
+//	gamma:gamma            gamma correction
+//	crop:x:y:width:height  cut out a rectangle
+//	pad:n or pad:t:r:b:l   add a white border
+func ParseFilters(spec string) (Pipeline, error) {
+	var pipeline Pipeline
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.Split(part, ":")
+		name := strings.ToLower(fields[0])
+		parse, ok := filterParsers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown filter \"%s\"", name)
+		}
+
+		params := make([]float64, len(fields)-1)
+		for i, field := range fields[1:] {
+			value, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, fmt.Errorf("filter \"%s\": invalid parameter \"%s\"", name, field)
+			}
+			params[i] = value
+		}
+
+		filter, err := parse(params)
+		if err != nil {
+			return nil, err
+		}
+		pipeline = append(pipeline, filter)
+	}
+	return pipeline, nil
+}
+
+// Invert returns a filter that inverts all colors and keeps the alpha channel.
+func Invert() Filter {
+	return colorFilter(func(v uint8) uint8 {
+		return 255 - v
+	})
+}
+
+// MonochromeFilter returns a filter that turns a pixel white if any of its color channels is brighter than half and black otherwise.
+// The alpha channel is kept.
+func MonochromeFilter() Filter {
+	return FilterFunc(func(img image.Image) image.Image {
+		dst := copyNRGBA(img)
+		for i := 0; i < len(dst.Pix); i += 4 {
+			v := uint8(0)
+			if dst.Pix[i] > 127 || dst.Pix[i+1] > 127 || dst.Pix[i+2] > 127 {
+				v = 255
+			}
+			dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2] = v, v, v
+		}
+		return dst
+	})
+}
+
+// Segment returns a filter that turns pixels with a luminance below level black and all other pixels white.
+// Fully transparent pixels become white.
+func Segment(level uint8) Filter {
+	return FilterFunc(func(img image.Image) image.Image {
+		src := copyNRGBA(img)
+		dst := image.NewGray(src.Rect)
+		for i := range dst.Pix {
+			p := src.Pix[i*4 : i*4+4]
+			luminance := color.GrayModel.Convert(color.NRGBA{p[0], p[1], p[2], 0xff}).(color.Gray).Y
+			if p[3] == 0 || luminance >= level {
+				dst.Pix[i] = 0xff
+			}
+		}
+		return dst
+	})
+}
+
+// Brightness returns a filter that changes the brightness by change, from -1 (black) to 1 (white).
+func Brightness(change float64) Filter {
+	return colorFilter(func(v uint8) uint8 {
+		return clampUint8(float64(v) + change*255)
+	})
+}
+
+// Contrast returns a filter that changes the contrast by change, from -1 (gray) to 1 and above for more contrast.
+func Contrast(change float64) Filter {
+	return colorFilter(func(v uint8) uint8 {
+		return clampUint8(((float64(v)/255-0.5)*(1+change) + 0.5) * 255)
+	})
+}
+
+// Gamma returns a filter that applies gamma correction, values above 1 brighten and values below 1 darken the image.
+func Gamma(gamma float64) Filter {
+	return colorFilter(func(v uint8) uint8 {
+		return clampUint8(math.Pow(float64(v)/255, 1/gamma) * 255)
+	})
+}
+
+// Blur returns a filter that applies a gaussian blur with the given radius.
+// A radius of zero derives the radius from the image width.
+func Blur(radius float64) Filter {
+	return FilterFunc(func(img image.Image) image.Image {
+		r := radius
+		if r <= 0 {
+			r = float64(img.Bounds().Dx()) / 300
+		}
+		if r <= 0 {
+			return copyNRGBA(img)
+		}
+
+		length := int(math.Ceil(2*r + 1))
+		kernel := make([]float64, length)
+		for i, x := 0, -r; i < length; i, x = i+1, x+1 {
+			kernel[i] = math.Exp(-(x * x / 4 / r))
+		}
+		src := copyNRGBA(img)
+		return convolve(convolve(src, kernel, length, 1), kernel, 1, length)
+	})
+}
+
+// Sharpen returns a filter that sharpens edges with a 3x3 kernel.
+func Sharpen() Filter {
+	return FilterFunc(func(img image.Image) image.Image {
+		return convolve(copyNRGBA(img), []float64{
+			0, -1, 0,
+			-1, 5, -1,
+			0, -1, 0,
+		}, 3, 3)
+	})
+}
+
+// Edge returns a filter that detects edges with the sobel operator.
+// Edges become bright on a black background.
+func Edge() Filter {
+	return FilterFunc(func(img image.Image) image.Image {
+		src := copyNRGBA(img)
+		w, h := src.Rect.Dx(), src.Rect.Dy()
+		gray := make([]float64, w*h)
+		for i := range gray {
+			p := src.Pix[i*4 : i*4+4]
+			gray[i] = float64(color.GrayModel.Convert(color.NRGBA{p[0], p[1], p[2], 0xff}).(color.Gray).Y)
+		}
+		at := func(x, y int) float64 {
+			return gray[clampInt(y, 0, h-1)*w+clampInt(x, 0, w-1)]
+		}
+
+		dst := image.NewGray(src.Rect)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+				gy := at(x-1, y-1) + 2*at(x, y-1) + at(x+1, y-1) - at(x-1, y+1) - 2*at(x, y+1) - at(x+1, y+1)
+				dst.Pix[y*dst.Stride+x] = clampUint8(math.Sqrt(gx*gx + gy*gy))
+			}
+		}
+		return dst
+	})
+}
+
+// Crop returns a filter that cuts out rect, given relative to the top left corner of the image.
+func Crop(rect image.Rectangle) Filter {
+	return FilterFunc(func(img image.Image) image.Image {
+		b := img.Bounds()
+		r := rect.Add(b.Min).Intersect(b)
+		dst := image.NewNRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+		draw.Draw(dst, dst.Rect, img, r.Min, draw.Src)
+		return dst
+	})
+}
+
+// Pad returns a filter that adds a white border of the given widths around the image.
+func Pad(top, right, bottom, left int) Filter {
+	return FilterFunc(func(img image.Image) image.Image {
+		b := img.Bounds()
+		dst := image.NewNRGBA(image.Rect(0, 0, max(b.Dx()+left+right, 0), max(b.Dy()+top+bottom, 0)))
+		draw.Draw(dst, dst.Rect, image.White, image.Point{}, draw.Src)
+		draw.Draw(dst, b.Sub(b.Min).Add(image.Pt(left, top)), img, b.Min, draw.Src)
+		return dst
+	})
+}
+
+// copyNRGBA copies any image to a new NRGBA image with its origin at 0,0.
+func copyNRGBA(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+	return dst
+}
+
+// colorFilter returns a filter that maps every color channel through fn and keeps the alpha channel.
+func colorFilter(fn func(v uint8) uint8) Filter {
+	var table [256]uint8
+	for i := range table {
+		table[i] = fn(uint8(i))
+	}
+	return FilterFunc(func(img image.Image) image.Image {
+		dst := copyNRGBA(img)
+		for i := 0; i < len(dst.Pix); i += 4 {
+			dst.Pix[i] = table[dst.Pix[i]]
+			dst.Pix[i+1] = table[dst.Pix[i+1]]
+			dst.Pix[i+2] = table[dst.Pix[i+2]]
+		}
+		return dst
+	})
+}
+
+// convolve applies a kernel of kw x kh weights to the color channels of src, repeating the edge pixels.
+// The kernel is normalized if its weights don't sum up to zero.
+func convolve(src *image.NRGBA, kernel []float64, kw, kh int) *image.NRGBA {
+	sum := 0.0
+	for _, k := range kernel {
+		sum += k
+	}
+	if sum == 0 {
+		sum = 1
+	}
+
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewNRGBA(src.Rect)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var r, g, b float64
+			for ky := 0; ky < kh; ky++ {
+				sy := clampInt(y+ky-kh/2, 0, h-1)
+				for kx := 0; kx < kw; kx++ {
+					k := kernel[ky*kw+kx]
+					if k == 0 {
+						continue
+					}
+					i := sy*src.Stride + clampInt(x+kx-kw/2, 0, w-1)*4
+					r += float64(src.Pix[i]) * k
+					g += float64(src.Pix[i+1]) * k
+					b += float64(src.Pix[i+2]) * k
+				}
+			}
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = clampUint8(r / sum)
+			dst.Pix[i+1] = clampUint8(g / sum)
+			dst.Pix[i+2] = clampUint8(b / sum)
+			dst.Pix[i+3] = src.Pix[i+3]
+		}
+	}
+	return dst
+}
+
+func clampUint8(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(math.Round(v))
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
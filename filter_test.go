@@ -0,0 +1,123 @@
+package zplgfa
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_ParseFilters(t *testing.T) {
+	pipeline, err := ParseFilters("invert, monochrome,segment:100,blur,blur:2,edge,sharpen,brightness:0.1,contrast:-0.5,gamma:2.2,crop:1:1:2:2,pad:1,pad:1:2:3:4")
+	if err != nil {
+		t.Fatalf("ParseFilters failed: %v", err)
+	}
+	if len(pipeline) != 13 {
+		t.Fatalf("ParseFilters failed: got %d filters, want 13", len(pipeline))
+	}
+
+	for _, spec := range []string{"emboss", "invert:1", "crop:1:2", "blur:x", "gamma:0", "segment:300"} {
+		if _, err := ParseFilters(spec); err == nil {
+			t.Fatalf("ParseFilters failed: %q was accepted", spec)
+		}
+	}
+}
+
+func Test_FiltersKeepSource(t *testing.T) {
+	// JPEG files decode to YCbCr images which can't be modified with Set.
+	src := image.NewYCbCr(image.Rect(2, 3, 6, 7), image.YCbCrSubsampleRatio420)
+	for i := range src.Y {
+		src.Y[i] = 200
+	}
+	for i := range src.Cb {
+		src.Cb[i], src.Cr[i] = 128, 128
+	}
+
+	pipeline, err := ParseFilters("invert,monochrome,blur,edge,sharpen,brightness:0.5,contrast:0.5,gamma:0.5,segment,crop:0:0:2:2,pad:1")
+	if err != nil {
+		t.Fatalf("ParseFilters failed: %v", err)
+	}
+	if img := pipeline.Apply(src); img.Bounds() != image.Rect(0, 0, 4, 4) {
+		t.Fatalf("Pipeline.Apply failed: got bounds %v", img.Bounds())
+	}
+	for i := range src.Y {
+		if src.Y[i] != 200 {
+			t.Fatalf("Pipeline.Apply failed: the source image was modified")
+		}
+	}
+}
+
+func Test_ColorFilters(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	src.Pix = []byte{0x40, 0x80, 0xff, 0x7f}
+
+	tests := []struct {
+		filter Filter
+		want   color.NRGBA
+	}{
+		{Invert(), color.NRGBA{0xbf, 0x7f, 0x00, 0x7f}},
+		{MonochromeFilter(), color.NRGBA{0xff, 0xff, 0xff, 0x7f}},
+		{Brightness(-0.5), color.NRGBA{0x00, 0x01, 0x80, 0x7f}},
+		{Contrast(-1), color.NRGBA{0x80, 0x80, 0x80, 0x7f}},
+		{Gamma(1), color.NRGBA{0x40, 0x80, 0xff, 0x7f}},
+		{Segment(0x80), color.NRGBA{0x00, 0x00, 0x00, 0xff}},
+	}
+	for i, tt := range tests {
+		got := color.NRGBAModel.Convert(tt.filter.Apply(src).At(0, 0)).(color.NRGBA)
+		if got != tt.want {
+			t.Fatalf("filter %d failed: got %v, want %v", i, got, tt.want)
+		}
+	}
+}
+
+func Test_CropAndPad(t *testing.T) {
+	src := checkerImage(8, 8)
+
+	cropped := Crop(image.Rect(2, 1, 5, 3)).Apply(src)
+	want := image.NewGray(image.Rect(0, 0, 3, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			want.Set(x, y, src.At(x+2, y+1))
+		}
+	}
+	assertBilevelEqual(t, cropped, want)
+
+	padded := Pad(1, 2, 3, 4).Apply(cropped)
+	if padded.Bounds() != image.Rect(0, 0, 9, 6) {
+		t.Fatalf("Pad failed: got bounds %v", padded.Bounds())
+	}
+	if r, _, _, _ := padded.At(0, 0).RGBA(); r != 0xffff {
+		t.Fatalf("Pad failed: the border is not white")
+	}
+	if color.GrayModel.Convert(padded.At(4, 1)) != color.GrayModel.Convert(cropped.At(0, 0)) {
+		t.Fatalf("Pad failed: the image was not moved by the padding")
+	}
+}
+
+func Test_BlurAndEdge(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 10, 10))
+	for i := range src.Pix {
+		src.Pix[i] = 0x60
+	}
+
+	blurred := Blur(2).Apply(src)
+	sharpened := Sharpen().Apply(src)
+	edges := Edge().Apply(src)
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			if g := color.GrayModel.Convert(blurred.At(x, y)).(color.Gray).Y; g != 0x60 {
+				t.Fatalf("Blur failed: got %#x at %d,%d for a uniform image", g, x, y)
+			}
+			if g := color.GrayModel.Convert(sharpened.At(x, y)).(color.Gray).Y; g != 0x60 {
+				t.Fatalf("Sharpen failed: got %#x at %d,%d for a uniform image", g, x, y)
+			}
+			if g := color.GrayModel.Convert(edges.At(x, y)).(color.Gray).Y; g != 0 {
+				t.Fatalf("Edge failed: got %#x at %d,%d for a uniform image", g, x, y)
+			}
+		}
+	}
+
+	src.Pix[5*10+5] = 0xff
+	if g := color.GrayModel.Convert(Edge().Apply(src).At(4, 5)).(color.Gray).Y; g == 0 {
+		t.Fatalf("Edge failed: no edge detected next to a bright pixel")
+	}
+}
@@ -0,0 +1,120 @@
+package zplgfa
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxFormattedGraphicBytes is the longest graphic data FormatZPL keeps without FormatOptions.KeepGraphics.
+const maxFormattedGraphicBytes = 64
+
+// asciiSpace is the whitespace FormatZPL removes. Other bytes may be prefixes or data.
+const asciiSpace = " \t\r\n\v\f"
+
+// FormatOptions controls FormatZPL.
+type FormatOptions struct {
+	// KeepGraphics keeps the data of graphic fields longer than 64 bytes instead of replacing it with a summary.
+	KeepGraphics bool
+}
+
+// FormatZPL formats a ZPL document for reading. Every command is written on a line of its own and the commands
+// of a field, from ^FO or ^FT to ^FS, are indented. Graphic data longer than 64 bytes is replaced with a summary of
+// its encoding and size unless KeepGraphics is set, which makes the result no valid ZPL. Field data, comments and
+// download commands keep their whitespace and prefix changes with ^CC and ^CT are followed, so formatting the
+// result again doesn't change it. A prefix changed to whitespace or a binary graphic field with missing data
+// leaves the rest of the document as it is.
+func FormatZPL(zpl string, options FormatOptions) string {
+	var out strings.Builder
+	inField := false
+	writeLine := func(text string) {
+		if inField {
+			out.WriteString("  ")
+		}
+		out.WriteString(text)
+		out.WriteByte('\n')
+	}
+
+	for _, command := range ParseCommands(zpl) {
+		if formatsVerbatim(command) {
+			out.WriteString(zpl[command.Offset:])
+			break
+		}
+		text, rest := formatCommand(command, options)
+		if command.Is("^FS") {
+			inField = false
+		}
+		if text != "" {
+			writeLine(text)
+		}
+		if rest != "" {
+			writeLine(rest)
+		}
+		if command.Is("^FO") || command.Is("^FT") {
+			inField = true
+		}
+	}
+	return out.String()
+}
+
+// formatsVerbatim reports whether the document from command on can't be formatted: line breaks and indentation
+// would turn into commands after a whitespace prefix and into data after a binary field with missing data.
+func formatsVerbatim(command Command) bool {
+	switch {
+	case command.Is("^CC"), command.Is("~CC"), command.Is("^CT"), command.Is("~CT"):
+		return strings.Trim(command.Params, asciiSpace) == ""
+	case command.Is("^GF") && command.hasData():
+		return binaryGraphicFieldEnd(command.Params, 0) == -1
+	}
+	return false
+}
+
+// formatCommand returns the text of a command without the whitespace that separates it from the next one,
+// and the text that follows the data of a binary graphic field.
+func formatCommand(command Command, options FormatOptions) (string, string) {
+	switch {
+	case command.Prefix == 0:
+		return strings.Trim(command.Params, asciiSpace), ""
+	case command.Is("^GF"):
+		return formatGraphicField(command, options)
+	case command.hasData():
+		return trimLineBreak(command.String()), ""
+	}
+	return strings.TrimRight(command.String(), asciiSpace), ""
+}
+
+// formatGraphicField formats a ^GF command, replacing long data with a summary unless options.KeepGraphics is set.
+func formatGraphicField(command Command, options FormatOptions) (string, string) {
+	text, rest := strings.TrimRight(command.String(), asciiSpace), ""
+	if command.hasData() {
+		end := len(command.String()) - len(command.Params) + binaryGraphicFieldEnd(command.Params, 0)
+		text, rest = command.String()[:end], strings.Trim(command.String()[end:], asciiSpace)
+		// the line break before the data belongs to the data, without data it separates nothing
+		if count, _ := strconv.Atoi(strings.TrimSpace(strings.SplitN(command.Params, ",", 3)[1])); count == 0 {
+			text = strings.TrimRight(text, asciiSpace)
+		}
+	}
+	if options.KeepGraphics {
+		return text, rest
+	}
+
+	field, err := parseGraphicField(text)
+	if err != nil || len(field.data) <= maxFormattedGraphicBytes {
+		return text, rest
+	}
+	summary := fmt.Sprintf("<%s data, %d bytes", field.encoding(), len(field.data))
+	if width, height, err := field.size(); err == nil {
+		summary += fmt.Sprintf(", %dx%d dots", width, height)
+	}
+	return strings.TrimRight(text[:field.dataOffset], asciiSpace) + summary + ">", rest
+}
+
+// trimLineBreak removes the whitespace at the end of data from its first line break on.
+// Whitespace before the line break belongs to the data.
+func trimLineBreak(data string) string {
+	trimmed := strings.TrimRight(data, asciiSpace)
+	if i := strings.IndexAny(data[len(trimmed):], "\r\n"); i != -1 {
+		return data[:len(trimmed)+i]
+	}
+	return data
+}
@@ -0,0 +1,79 @@
+package zplgfa
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func Test_FormatZPL(t *testing.T) {
+	field := ConvertToGraphicField(repeatedRowsImage(200, 80), CompressedASCII)
+	zpl := "^XA^FX label \n^fo10,10 ^a0n,30^FDHello \n^FS^FT5,5^GB3,3^FS" +
+		"^FO0,0" + field + "^FS^FO1,1^GFA,2,2,1,\nFF\n00\n^FS\n~DGR:A.GRF,2,1,\nFF\nFF\n^XZ"
+	parsed, _ := parseGraphicField(field)
+	want := strings.Join([]string{
+		"^XA",
+		"^FX label ",
+		"^FO10,10",
+		"  ^A0n,30",
+		"  ^FDHello ",
+		"^FS",
+		"^FT5,5",
+		"  ^GB3,3",
+		"^FS",
+		"^FO0,0",
+		fmt.Sprintf("  ^GFA,%d,2000,25,<CompressedASCII data, %d bytes, 200x80 dots>", len(parsed.data), len(parsed.data)),
+		"^FS",
+		"^FO1,1",
+		"  ^GFA,2,2,1,",
+		"FF",
+		"00",
+		"^FS",
+		"~DGR:A.GRF,2,1,",
+		"FF",
+		"FF",
+		"^XZ",
+		"",
+	}, "\n")
+	got := FormatZPL(zpl, FormatOptions{})
+	if got != want {
+		t.Fatalf("FormatZPL failed: got\n%s\nwant\n%s", got, want)
+	}
+	if again := FormatZPL(got, FormatOptions{}); again != got {
+		t.Fatalf("FormatZPL failed: formatting twice gave\n%s", again)
+	}
+}
+
+func Test_FormatZPLKeepGraphics(t *testing.T) {
+	binary := ConvertToGraphicField(checkerImage(64, 10), Binary)
+	documents := []string{
+		transcodeDocument(),
+		"^XA^FO3,4" + binary + "^FS^FO0,0" + binary + "\n  ^FS^XZ",
+		"^XA~CT#^CC+\n+FO0,0+FR+" + binary[1:] + "+FS\n#JA+XZ",
+	}
+	for i, zpl := range documents {
+		formatted := FormatZPL(zpl, FormatOptions{KeepGraphics: true})
+		if again := FormatZPL(formatted, FormatOptions{KeepGraphics: true}); again != formatted {
+			t.Fatalf("FormatZPL failed for document %d: formatting twice gave\n%q\nwant\n%q", i, again, formatted)
+		}
+		want, err := RenderLabels(zpl)
+		if err != nil {
+			t.Fatalf("RenderLabels failed for document %d: %v", i, err)
+		}
+		got, err := RenderLabels(formatted)
+		if err != nil || len(got) != len(want) {
+			t.Fatalf("FormatZPL failed for document %d: got %d labels (%v), want %d", i, len(got), err, len(want))
+		}
+		for j := range want {
+			if got[j].Rect != want[j].Rect || !bytes.Equal(got[j].Pix, want[j].Pix) {
+				t.Fatalf("FormatZPL failed for document %d: label %d renders differently", i, j)
+			}
+		}
+	}
+
+	formatted := FormatZPL(documents[2], FormatOptions{})
+	if !strings.Contains(formatted, "\n+FO0,0\n  +FR\n  +GFB,80,80,8,<Binary data, 80 bytes, 64x10 dots>\n+FS\n#JA\n+XZ\n") {
+		t.Fatalf("FormatZPL failed: got\n%s", formatted)
+	}
+}
@@ -0,0 +1,142 @@
+package zplgfa
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+// graphicFieldSeeds returns the ^GF fields of tests/tests.json.
+func graphicFieldSeeds() []string {
+	var fields []string
+	for _, test := range zplTests {
+		if start := strings.Index(test.Zplstring, "^GF"); start != -1 {
+			fields = append(fields, test.Zplstring[start:])
+		}
+	}
+	return fields
+}
+
+func Fuzz_ConvertGraphicFieldToImage(f *testing.F) {
+	for _, field := range graphicFieldSeeds() {
+		f.Add(field)
+	}
+	f.Fuzz(func(t *testing.T, field string) {
+		img, err := ConvertGraphicFieldToImage(field)
+		if err != nil {
+			return
+		}
+		parsed, _ := parseGraphicField(field)
+		total, bytesPerRow := parsed.total, parsed.bytesPerRow
+		if img.Bounds().Dx() != bytesPerRow*8 || img.Bounds().Dy()*bytesPerRow != total || len(img.Pix) != total {
+			t.Fatalf("ConvertGraphicFieldToImage failed: got %v with %d bytes for %d bytes of %d per row", img.Bounds(), len(img.Pix), total, bytesPerRow)
+		}
+		again, err := ConvertGraphicFieldToImage(ConvertToGraphicField(img, CompressedASCII))
+		if err != nil {
+			t.Fatalf("ConvertGraphicFieldToImage failed on its own output: %v", err)
+		}
+		if !bytes.Equal(again.Pix, img.Pix) {
+			t.Fatalf("ConvertGraphicFieldToImage failed: round trip changed the image")
+		}
+	})
+}
+
+func Fuzz_ExpandCompressedASCII(f *testing.F) {
+	for _, field := range graphicFieldSeeds() {
+		parsed, err := parseGraphicField(field)
+		if err == nil && parsed.format == 'A' && parsed.bytesPerRow > 0 {
+			f.Add(parsed.data, parsed.bytesPerRow, parsed.total/parsed.bytesPerRow)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data string, bytesPerRow, rows int) {
+		if bytesPerRow < 1 || bytesPerRow > 64 || rows < 0 || rows > 256 {
+			return
+		}
+		raw, err := expandCompressedASCII(context.Background(), data, bytesPerRow, rows)
+		if err != nil {
+			return
+		}
+		if len(raw) != bytesPerRow*rows {
+			t.Fatalf("expandCompressedASCII failed: got %d bytes, want %d", len(raw), bytesPerRow*rows)
+		}
+		encoded, _ := appendGraphicRows(context.Background(), nil, raw, bytesPerRow, 0, rows, CompressedASCII, Limits{})
+		again, err := expandCompressedASCII(context.Background(), string(encoded), bytesPerRow, rows)
+		if err != nil || !bytes.Equal(again, raw) {
+			t.Fatalf("expandCompressedASCII failed: round trip of %q changed the rows (%v)", encoded, err)
+		}
+	})
+}
+
+func Fuzz_DecodeZ64(f *testing.F) {
+	for _, field := range graphicFieldSeeds() {
+		img, err := ConvertGraphicFieldToImage(field)
+		if err != nil {
+			continue
+		}
+		payload, err := EncodeZ64(img.Pix)
+		if err != nil {
+			f.Fatalf("EncodeZ64 failed: %v", err)
+		}
+		f.Add(payload, len(img.Pix))
+	}
+	f.Fuzz(func(t *testing.T, payload string, bytesUsed int) {
+		if bytesUsed < 0 || bytesUsed > 1<<20 {
+			return
+		}
+		raw, err := decodeZ64Data(payload, bytesUsed, Limits{})
+		if err != nil {
+			return
+		}
+		if len(raw) != bytesUsed {
+			t.Fatalf("decodeZ64Data failed: got %d bytes, want %d", len(raw), bytesUsed)
+		}
+		encoded, err := EncodeZ64(raw)
+		if err != nil {
+			t.Fatalf("EncodeZ64 failed: %v", err)
+		}
+		again, err := decodeZ64Data(encoded, bytesUsed, Limits{})
+		if err != nil || !bytes.Equal(again, raw) {
+			t.Fatalf("decodeZ64Data failed: round trip changed the data (%v)", err)
+		}
+	})
+}
+
+func Fuzz_ParseCommands(f *testing.F) {
+	for _, test := range zplTests {
+		f.Add(test.Zplstring)
+	}
+	f.Fuzz(func(t *testing.T, zpl string) {
+		end := 0
+		for _, command := range ParseCommands(zpl) {
+			if command.Offset != end || command.End <= command.Offset {
+				t.Fatalf("ParseCommands failed: command %q at %d-%d after %d", command.Name, command.Offset, command.End, end)
+			}
+			end = command.End
+		}
+		if end != len(zpl) {
+			t.Fatalf("ParseCommands failed: the commands end at %d of %d", end, len(zpl))
+		}
+		ScanGraphicFields(zpl)
+	})
+}
+
+func Fuzz_FormatZPL(f *testing.F) {
+	for _, test := range zplTests {
+		f.Add(test.Zplstring, false)
+	}
+	// prefix changes, invalid UTF-8 and binary fields with little or no data
+	for _, zpl := range []string{
+		"^XA^CC+\n+FO0,0+GFB,2,2,1,\n+\n+FS~CT#\n#JA+XZ", "^\x8d", "0^CC\n~CT010", "^CC\x85\x850",
+		"^CC+0+GFB,0,0,0,0", "^GFB,00,,,", "^GFB,1,,, 00", "^CC++GFB,2,,,0",
+	} {
+		f.Add(zpl, true)
+	}
+	f.Fuzz(func(t *testing.T, zpl string, keepGraphics bool) {
+		options := FormatOptions{KeepGraphics: keepGraphics}
+		formatted := FormatZPL(zpl, options)
+		if again := FormatZPL(formatted, options); again != formatted {
+			t.Fatalf("FormatZPL failed: formatting %q twice gave %q, want %q", zpl, again, formatted)
+		}
+	})
+}
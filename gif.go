@@ -0,0 +1,55 @@
+package zplgfa
+
+import (
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+)
+
+// DecodeGIFFrames decodes every frame of an animated GIF. Each frame is
+// composited onto the previous ones like a browser would display it, taking
+// partial frames, transparency and the disposal method of each frame into
+// account. The background of the animation is transparent.
+func DecodeGIFFrames(r io.Reader) ([]image.Image, error) {
+	animation, err := gif.DecodeAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := image.Rect(0, 0, animation.Config.Width, animation.Config.Height)
+	if bounds.Empty() && len(animation.Image) > 0 {
+		bounds = animation.Image[0].Bounds()
+	}
+	canvas := image.NewNRGBA(bounds)
+	frames := make([]image.Image, 0, len(animation.Image))
+
+	for i, frame := range animation.Image {
+		disposal := byte(0)
+		if i < len(animation.Disposal) {
+			disposal = animation.Disposal[i]
+		}
+
+		var previous *image.NRGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneNRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		frames = append(frames, cloneNRGBA(canvas))
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return frames, nil
+}
+
+func cloneNRGBA(img *image.NRGBA) *image.NRGBA {
+	clone := image.NewNRGBA(img.Bounds())
+	copy(clone.Pix, img.Pix)
+	return clone
+}
@@ -0,0 +1,101 @@
+package zplgfa
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"strings"
+	"testing"
+)
+
+func Test_DecodeGIFFrames(t *testing.T) {
+	palette := color.Palette{color.White, color.Black, color.Transparent}
+	frame := func(rect image.Rectangle, index uint8) *image.Paletted {
+		img := image.NewPaletted(rect, palette)
+		for i := range img.Pix {
+			img.Pix[i] = index
+		}
+		return img
+	}
+
+	// A white background, a black square kept in place, a black square
+	// restored to the previous state and a transparent frame that clears the
+	// lower half to the background.
+	animation := &gif.GIF{
+		Image: []*image.Paletted{
+			frame(image.Rect(0, 0, 4, 4), 0),
+			frame(image.Rect(0, 0, 2, 2), 1),
+			frame(image.Rect(2, 2, 4, 4), 1),
+			frame(image.Rect(0, 2, 4, 4), 2),
+			frame(image.Rect(3, 0, 4, 1), 1),
+		},
+		Delay:    make([]int, 5),
+		Disposal: []byte{gif.DisposalNone, gif.DisposalNone, gif.DisposalPrevious, gif.DisposalBackground, gif.DisposalNone},
+		Config:   image.Config{Width: 4, Height: 4},
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, animation); err != nil {
+		t.Fatalf("EncodeAll failed: %v", err)
+	}
+
+	frames, err := DecodeGIFFrames(&buf)
+	if err != nil {
+		t.Fatalf("DecodeGIFFrames failed: %v", err)
+	}
+	if len(frames) != 5 {
+		t.Fatalf("DecodeGIFFrames failed: got %d frames, want 5", len(frames))
+	}
+
+	want := []string{
+		"....\n....\n....\n....\n",
+		"##..\n##..\n....\n....\n",
+		"##..\n##..\n..##\n..##\n",
+		"##..\n##..\n....\n....\n",
+		"##.#\n##..\n    \n    \n",
+	}
+	for i, img := range frames {
+		if got := gifFrameString(img); got != want[i] {
+			t.Fatalf("DecodeGIFFrames failed: frame %d is\n%swant\n%s", i+1, got, want[i])
+		}
+	}
+}
+
+// gifFrameString renders black as '#', white as '.' and transparent pixels as ' '.
+func gifFrameString(img image.Image) string {
+	var s strings.Builder
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, _, _, a := img.At(x, y).RGBA()
+			switch {
+			case a == 0:
+				s.WriteByte(' ')
+			case r < 0x8000:
+				s.WriteByte('#')
+			default:
+				s.WriteByte('.')
+			}
+		}
+		s.WriteByte('\n')
+	}
+	return s.String()
+}
+
+func Test_ConvertPagesToDownloadGraphics(t *testing.T) {
+	pages := []image.Image{checkerImage(8, 2), image.NewGray(image.Rect(0, 0, 0, 0)), checkerImage(8, 1)}
+
+	got, err := ConvertPagesToDownloadGraphics(pages, "E:anim", CompressedASCII)
+	if err != nil {
+		t.Fatalf("ConvertPagesToDownloadGraphics failed: %v", err)
+	}
+	if strings.Count(got, "~DG") != 2 || !strings.HasPrefix(got, "~DGE:ANIM001.GRF,2,1,\n") || !strings.Contains(got, "~DGE:ANIM003.GRF,1,1,\n") {
+		t.Fatalf("ConvertPagesToDownloadGraphics failed: unexpected output %q", got)
+	}
+
+	img, err := DecodeGRF(strings.NewReader(got[strings.LastIndex(got, "~DG"):]))
+	if err != nil {
+		t.Fatalf("ConvertPagesToDownloadGraphics failed: %v", err)
+	}
+	assertBilevelEqual(t, img, pages[2])
+}
@@ -2,7 +2,4 @@ module simonwaldherr.de/go/zplgfa
 
 go 1.22
 
-require (
-	github.com/anthonynsimon/bild v0.13.0
-	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
-)
+require github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
@@ -0,0 +1,168 @@
+package zplgfa
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+func init() {
+	image.RegisterFormat("grf", "~DG", DecodeGRF, DecodeGRFConfig)
+}
+
+// ConvertToDownloadGraphic converts an image.Image to a ZPL ~DG download graphic command.
+// The name defaults to the R: device and the .GRF extension when they are omitted.
+// Binary is not supported by ~DG and returns an error.
+func ConvertToDownloadGraphic(img image.Image, name string, graphicType GraphicType) (string, error) {
+	if graphicType == Binary {
+		return "", fmt.Errorf("~DG does not support binary graphic data")
+	}
+
+	raw, width := packImage(img)
+	height := img.Bounds().Dy()
+	data, err := encodeGraphicData(raw, width, height, graphicType)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("~DG%s,%d,%d,\n%s", downloadGraphicName(name), width*height, width, data), nil
+}
+
+// EncodeGRF writes an image as a .GRF file containing a compressed ~DG command.
+func EncodeGRF(w io.Writer, img image.Image, name string) error {
+	grf, err := ConvertToDownloadGraphic(img, name, CompressedASCII)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, grf)
+	return err
+}
+
+// DecodeGRF reads a .GRF file or ~DG command and returns the graphic as a black and white image.
+// Malformed graphics return a *DecodeError with the position of the error in the file.
+func DecodeGRF(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	_, field, err := parseDownloadGraphic(string(data))
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := field.size(); err != nil {
+		return nil, err
+	}
+
+	raw, err := decodeASCIIData(context.Background(), field.data, field.total, field.bytesPerRow, Limits{})
+	if err != nil {
+		return nil, shiftDecodeError(err, field.dataOffset)
+	}
+	return imageFromGraphicData(raw, field.bytesPerRow), nil
+}
+
+// DecodeGRFConfig returns the dimensions of a .GRF file without decoding the graphic data.
+func DecodeGRFConfig(r io.Reader) (image.Config, error) {
+	header, err := readHeader(r, "~DG", 3)
+	if err != nil {
+		return image.Config{}, err
+	}
+	_, field, err := parseDownloadGraphic(header)
+	if err != nil {
+		return image.Config{}, err
+	}
+	width, height, err := field.size()
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: MonochromeModel, Width: width, Height: height}, nil
+}
+
+// parseDownloadGraphic returns the name and the graphic of a ~DG command. The data ends at the next command.
+func parseDownloadGraphic(graphic string) (string, graphicField, error) {
+	start := len(graphic) - len(strings.TrimLeft(graphic, " \t\r\n"))
+	if !strings.HasPrefix(graphic[start:], "~DG") {
+		return "", graphicField{}, decodeError(DecodeHeader, start, "download graphic must start with ~DG")
+	}
+	parts := strings.SplitN(graphic[start+3:], ",", 4)
+	if len(parts) != 4 {
+		return "", graphicField{}, decodeError(DecodeHeader, len(graphic), "~DG needs 3 parameters before its data")
+	}
+	var offsets [4]int
+	offsets[0] = start + 3
+	for i := 1; i < len(parts); i++ {
+		offsets[i] = offsets[i-1] + len(parts[i-1]) + 1
+	}
+
+	field := graphicField{format: 'A', totalOffset: offsets[1]}
+	var err error
+	if field.total, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil {
+		return "", graphicField{}, decodeError(DecodeHeader, offsets[1], "~DG byte count %q is not a number", parts[1])
+	}
+	if field.bytesPerRow, err = strconv.Atoi(strings.TrimSpace(parts[2])); err != nil {
+		return "", graphicField{}, decodeError(DecodeHeader, offsets[2], "~DG bytes per row %q is not a number", parts[2])
+	}
+
+	data := parts[3]
+	if end := strings.IndexAny(data, "^~"); end != -1 {
+		data = data[:end]
+	}
+	field.data = strings.TrimSpace(data)
+	field.dataOffset = offsets[3] + len(data) - len(strings.TrimLeftFunc(data, unicode.IsSpace))
+	return parts[0], field, nil
+}
+
+// readHeader reads a command header from r, starting at the command prefix
+// and ending after the given number of parameter separators.
+func readHeader(r io.Reader, command string, separators int) (string, error) {
+	const (
+		maxPreambleLen = 1 << 16
+		maxHeaderLen   = 1024
+	)
+	reader := bufio.NewReader(r)
+	var buf []byte
+	start := -1
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return "", fmt.Errorf("incomplete %s header: %w", command, err)
+		}
+		buf = append(buf, b)
+		if start == -1 {
+			if bytes.HasSuffix(buf, []byte(command)) {
+				start = len(buf) - len(command)
+			} else if len(buf) > maxPreambleLen {
+				return "", fmt.Errorf("%s header not found", command)
+			}
+			continue
+		}
+		if b == ',' {
+			separators--
+			if separators == 0 {
+				return string(buf[start:]), nil
+			}
+		}
+		if len(buf)-start > maxHeaderLen {
+			return "", fmt.Errorf("%s header too long", command)
+		}
+	}
+}
+
+func downloadGraphicName(name string) string {
+	if name == "" {
+		name = "IMAGE"
+	}
+	name = strings.ToUpper(name)
+	if !strings.Contains(name, ":") {
+		name = "R:" + name
+	}
+	if !strings.Contains(name[strings.Index(name, ":"):], ".") {
+		name += ".GRF"
+	}
+	return name
+}
@@ -0,0 +1,91 @@
+package zplgfa
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func Test_ConvertToDownloadGraphic(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 8, 2))
+
+	got, err := ConvertToDownloadGraphic(img, "logo", ASCII)
+	if err != nil {
+		t.Fatalf("ConvertToDownloadGraphic failed: %s", err)
+	}
+	want := "~DGR:LOGO.GRF,2,1,\nFF\nFF\n"
+	if got != want {
+		t.Fatalf("ConvertToDownloadGraphic failed:\nExpected:\n%s\nGot:\n%s", want, got)
+	}
+
+	if _, err := ConvertToDownloadGraphic(img, "E:LOGO.GRF", Binary); err == nil {
+		t.Fatal("ConvertToDownloadGraphic should fail for binary data")
+	}
+}
+
+func Test_GRFRoundTrip(t *testing.T) {
+	img := checkerImage(12, 5)
+
+	var buf bytes.Buffer
+	if err := EncodeGRF(&buf, img, "TEST"); err != nil {
+		t.Fatalf("EncodeGRF failed: %s", err)
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("DecodeConfig failed: %s", err)
+	}
+	if format != "grf" || config.Width != 16 || config.Height != 5 {
+		t.Fatalf("DecodeConfig failed: got %s %dx%d", format, config.Width, config.Height)
+	}
+
+	decoded, format, err := image.Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decode failed: %s", err)
+	}
+	if format != "grf" {
+		t.Fatalf("Decode format failed: got %s", format)
+	}
+	assertBilevelEqual(t, decoded, img)
+}
+
+func Test_DecodeGRFError(t *testing.T) {
+	if _, err := DecodeGRF(strings.NewReader("~DGR:X.GRF,3,2,FFFFFF")); err == nil {
+		t.Fatal("DecodeGRF should fail for invalid dimensions")
+	}
+	if _, err := DecodeGRF(strings.NewReader("~DGR:X.GRF,536870912,8192,\n," + strings.Repeat(":", 1000))); err == nil {
+		t.Fatal("DecodeGRF should fail for a graphic of 512 MiB")
+	}
+}
+
+// checkerImage returns a white image with a checker pattern of black dots.
+func checkerImage(width, height int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	fillGray(img, color.White)
+	for y := 0; y < height; y++ {
+		for x := (y % 2); x < width; x += 2 {
+			img.Set(x, y, color.Black)
+		}
+	}
+	return img
+}
+
+// assertBilevelEqual compares the black and white dots of got with want,
+// ignoring any padding got may have on the right.
+func assertBilevelEqual(t *testing.T, got, want image.Image) {
+	t.Helper()
+	if got.Bounds().Dx() < want.Bounds().Dx() || got.Bounds().Dy() != want.Bounds().Dy() {
+		t.Fatalf("image bounds failed: got %v, want %v", got.Bounds(), want.Bounds())
+	}
+	for y := 0; y < want.Bounds().Dy(); y++ {
+		for x := 0; x < want.Bounds().Dx(); x++ {
+			g := color.GrayModel.Convert(got.At(got.Bounds().Min.X+x, got.Bounds().Min.Y+y)).(color.Gray).Y < 0x80
+			w := color.GrayModel.Convert(want.At(want.Bounds().Min.X+x, want.Bounds().Min.Y+y)).(color.Gray).Y < 0x80
+			if g != w {
+				t.Fatalf("pixel %d,%d failed: got black=%t, want black=%t", x, y, g, w)
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package zplgfa
+
+import (
+	"image"
+	"image/color"
+)
+
+// HybridOptions configures the region-aware conversion of ConvertHybrid.
+type HybridOptions struct {
+	// BlockSize is the edge length of the classified blocks in dots, zero means 16.
+	BlockSize int
+	// Threshold is the luminance below which dots of bilevel blocks print black, zero means 128.
+	Threshold uint8
+	// ToneRatio is the share of smooth mid tones above which a block counts as continuous-tone, zero means 0.2.
+	ToneRatio float64
+}
+
+func (o HybridOptions) withDefaults() HybridOptions {
+	if o.BlockSize <= 0 {
+		o.BlockSize = 16
+	}
+	if o.Threshold == 0 {
+		o.Threshold = 128
+	}
+	if o.ToneRatio <= 0 {
+		o.ToneRatio = 0.2
+	}
+	return o
+}
+
+// luminanceMap holds the 8 bit luminance of every pixel of an image.
+type luminanceMap struct {
+	pix           []uint8
+	width, height int
+}
+
+func newLuminanceMap(img image.Image) luminanceMap {
+	b := img.Bounds()
+	lum := luminanceMap{pix: make([]uint8, b.Dx()*b.Dy()), width: b.Dx(), height: b.Dy()}
+	for y := 0; y < lum.height; y++ {
+		for x := 0; x < lum.width; x++ {
+			lum.pix[y*lum.width+x] = uint8(color.Gray16Model.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray16).Y >> 8)
+		}
+	}
+	return lum
+}
+
+func (l luminanceMap) at(x, y int) int {
+	return int(l.pix[clampInt(y, 0, l.height-1)*l.width+clampInt(x, 0, l.width-1)])
+}
+
+// ClassifyRegions divides img into blocks and returns a mask that is black for continuous-tone
+// blocks and white for bilevel blocks such as text, line art and barcodes.
+// A block is continuous-tone when enough of its pixels are mid tones without a steep edge next
+// to them; the mid tones of anti-aliased text and barcodes always sit on steep edges.
+func ClassifyRegions(img image.Image, options HybridOptions) *image.Gray {
+	return classifyRegions(newLuminanceMap(img), options.withDefaults())
+}
+
+func classifyRegions(lum luminanceMap, options HybridOptions) *image.Gray {
+	mask := image.NewGray(image.Rect(0, 0, lum.width, lum.height))
+	for by := 0; by < lum.height; by += options.BlockSize {
+		for bx := 0; bx < lum.width; bx += options.BlockSize {
+			block := image.Rect(bx, by, bx+options.BlockSize, by+options.BlockSize).Intersect(mask.Rect)
+
+			smooth := 0
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				for x := block.Min.X; x < block.Max.X; x++ {
+					v := lum.at(x, y)
+					if v < 48 || v > 208 {
+						continue
+					}
+					gradient := abs(lum.at(x+1, y)-lum.at(x-1, y)) + abs(lum.at(x, y+1)-lum.at(x, y-1))
+					if gradient < 96 {
+						smooth++
+					}
+				}
+			}
+
+			value := uint8(0xff)
+			if float64(smooth) > options.ToneRatio*float64(block.Dx()*block.Dy()) {
+				value = 0
+			}
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				for x := block.Min.X; x < block.Max.X; x++ {
+					mask.Pix[y*mask.Stride+x] = value
+				}
+			}
+		}
+	}
+	return mask
+}
+
+// ConvertHybrid converts img to black and white, dithering continuous-tone blocks with
+// Floyd-Steinberg error diffusion and thresholding bilevel blocks, see ClassifyRegions.
+// The diffused error never crosses into bilevel blocks, so barcodes and text next to photos stay sharp.
+func ConvertHybrid(img image.Image, options HybridOptions) *image.Gray {
+	options = options.withDefaults()
+	lum := newLuminanceMap(img)
+	mask := classifyRegions(lum, options)
+
+	dst := image.NewGray(mask.Rect)
+	errors := make([]int, lum.width*lum.height)
+	diffuse := func(x, y, err int) {
+		if x < 0 || x >= lum.width || y >= lum.height || mask.Pix[y*mask.Stride+x] != 0 {
+			return
+		}
+		errors[y*lum.width+x] += err
+	}
+
+	for y := 0; y < lum.height; y++ {
+		for x := 0; x < lum.width; x++ {
+			i := y*lum.width + x
+			if mask.Pix[y*mask.Stride+x] != 0 {
+				if lum.pix[i] >= options.Threshold {
+					dst.Pix[y*dst.Stride+x] = 0xff
+				}
+				continue
+			}
+
+			v := int(lum.pix[i]) + errors[i]
+			out := 0
+			if v >= 128 {
+				out = 0xff
+			}
+			dst.Pix[y*dst.Stride+x] = uint8(out)
+
+			err := v - out
+			diffuse(x+1, y, err*7/16)
+			diffuse(x-1, y+1, err*3/16)
+			diffuse(x, y+1, err*5/16)
+			diffuse(x+1, y+1, err/16)
+		}
+	}
+	return dst
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,77 @@
+package zplgfa
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// hybridTestImage has a gray gradient in the left block, anti-aliased bars in
+// the middle block and white paper in the right block.
+func hybridTestImage() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 48, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.SetGray(x, y, color.Gray{uint8(64 + 8*x)})
+		}
+		for x := 16; x < 32; x++ {
+			v := uint8(0xff)
+			switch x % 4 {
+			case 0:
+				v = 0
+			case 1:
+				v = 0x80
+			}
+			img.SetGray(x, y, color.Gray{v})
+		}
+		for x := 32; x < 48; x++ {
+			img.SetGray(x, y, color.Gray{0xff})
+		}
+	}
+	return img
+}
+
+func Test_ClassifyRegions(t *testing.T) {
+	mask := ClassifyRegions(hybridTestImage(), HybridOptions{})
+	for i, want := range []uint8{0, 0xff, 0xff} {
+		if got := mask.GrayAt(i*16+8, 8).Y; got != want {
+			t.Fatalf("ClassifyRegions failed: block %d is %#x, want %#x", i, got, want)
+		}
+	}
+}
+
+func Test_ConvertHybrid(t *testing.T) {
+	src := hybridTestImage()
+	img := ConvertHybrid(src, HybridOptions{})
+
+	black := 0
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 48; x++ {
+			v := img.GrayAt(x, y).Y
+			if v != 0 && v != 0xff {
+				t.Fatalf("ConvertHybrid failed: gray dot %#x at %d,%d", v, x, y)
+			}
+			if x >= 16 {
+				want := uint8(0xff)
+				if src.GrayAt(x, y).Y < 128 {
+					want = 0
+				}
+				if v != want {
+					t.Fatalf("ConvertHybrid failed: dot at %d,%d was not thresholded", x, y)
+				}
+			} else if v == 0 {
+				black++
+			}
+		}
+	}
+	// The gradient averages to a luminance of 124, so about half of its dots are black.
+	if black < 100 || black > 156 {
+		t.Fatalf("ConvertHybrid failed: %d of 256 dithered dots are black", black)
+	}
+
+	zpl := ConvertToZPLWithOptions(src, ConvertOptions{GraphicType: ASCII, Hybrid: &HybridOptions{}})
+	if want, _ := ConvertToGraphicFieldWithError(img, ASCII); !strings.Contains(zpl, want) {
+		t.Fatalf("ConvertToZPLWithOptions failed: hybrid conversion is missing in %q", zpl)
+	}
+}
@@ -0,0 +1,125 @@
+package zplgfa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+)
+
+// Limits bounds the resources of a conversion, zero fields are unlimited.
+type Limits struct {
+	// MaxPixels is the largest number of pixels of an image. Images decoded from readers
+	// are checked with image.DecodeConfig before their pixels are decoded.
+	MaxPixels int64
+	// MaxOutputBytes is the largest size of the created ZPL.
+	MaxOutputBytes int64
+	// MaxInflatedBytes is the largest size Z64 graphic data may inflate to when decoding ZPL.
+	MaxInflatedBytes int64
+	// MaxInputBytes is the largest number of bytes read from a reader before the image is decoded.
+	MaxInputBytes int64
+}
+
+// LimitKind names the field of Limits that was exceeded.
+type LimitKind int
+
+const (
+	// LimitPixels is Limits.MaxPixels
+	LimitPixels LimitKind = iota
+	// LimitOutputBytes is Limits.MaxOutputBytes
+	LimitOutputBytes
+	// LimitInflatedBytes is Limits.MaxInflatedBytes
+	LimitInflatedBytes
+	// LimitInputBytes is Limits.MaxInputBytes
+	LimitInputBytes
+)
+
+func (k LimitKind) String() string {
+	switch k {
+	case LimitPixels:
+		return "pixels"
+	case LimitOutputBytes:
+		return "output bytes"
+	case LimitInflatedBytes:
+		return "inflated bytes"
+	case LimitInputBytes:
+		return "input bytes"
+	}
+	return fmt.Sprintf("LimitKind(%d)", int(k))
+}
+
+// ErrLimitExceeded matches every *LimitError with errors.Is.
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// LimitError is returned when a conversion exceeds one of its Limits.
+type LimitError struct {
+	Kind  LimitKind
+	Limit int64
+	// Size is the size that exceeded the limit. Output and inflated bytes are counted until
+	// the limit is exceeded, so for them Size is a lower bound.
+	Size int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s limit exceeded: %d > %d", e.Kind, e.Size, e.Limit)
+}
+
+// Is reports whether target is ErrLimitExceeded.
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// checkPixels returns a *LimitError if a width x height image has more than MaxPixels pixels.
+func (l Limits) checkPixels(width, height int) error {
+	if pixels := int64(width) * int64(height); l.MaxPixels > 0 && pixels > l.MaxPixels {
+		return &LimitError{Kind: LimitPixels, Limit: l.MaxPixels, Size: pixels}
+	}
+	return nil
+}
+
+// checkOutput returns a *LimitError if size is more than MaxOutputBytes.
+func (l Limits) checkOutput(size int) error {
+	if l.MaxOutputBytes > 0 && int64(size) > l.MaxOutputBytes {
+		return &LimitError{Kind: LimitOutputBytes, Limit: l.MaxOutputBytes, Size: int64(size)}
+	}
+	return nil
+}
+
+// readAll reads r until EOF and returns a *LimitError once it has read more than MaxInputBytes.
+func (l Limits) readAll(r io.Reader) ([]byte, error) {
+	if l.MaxInputBytes <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, l.MaxInputBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > l.MaxInputBytes {
+		return nil, &LimitError{Kind: LimitInputBytes, Limit: l.MaxInputBytes, Size: int64(len(data))}
+	}
+	return data, nil
+}
+
+// CheckImage reads the header of the image data in r with image.DecodeConfig and
+// returns a *LimitError if the image has more than MaxPixels pixels.
+func (l Limits) CheckImage(r io.Reader) error {
+	if l.MaxPixels <= 0 {
+		return nil
+	}
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return err
+	}
+	return l.checkPixels(config.Width, config.Height)
+}
+
+// canceled returns the error of ctx once it is done. It is cheap enough to be called for every row.
+func canceled(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,109 @@
+package zplgfa
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func assertLimitError(t *testing.T, err error, kind LimitKind) {
+	t.Helper()
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("limit check failed: got %v, want a *LimitError", err)
+	}
+	if limitErr.Kind != kind || limitErr.Size <= limitErr.Limit {
+		t.Fatalf("limit check failed: got %+v, want %s", limitErr, kind)
+	}
+}
+
+func Test_LimitPixels(t *testing.T) {
+	var data bytes.Buffer
+	if err := png.Encode(&data, checkerImage(40, 30)); err != nil {
+		t.Fatalf("png.Encode failed: %s", err)
+	}
+
+	_, err := ConvertReaderToZPLContext(context.Background(), bytes.NewReader(data.Bytes()), ConvertOptions{Limits: Limits{MaxPixels: 1199}})
+	assertLimitError(t, err, LimitPixels)
+	if _, err := ConvertReaderToZPLContext(context.Background(), bytes.NewReader(data.Bytes()), ConvertOptions{Limits: Limits{MaxPixels: 1200}}); err != nil {
+		t.Fatalf("ConvertReaderToZPLContext failed: %s", err)
+	}
+
+	_, err = ConvertToZPLContext(context.Background(), checkerImage(40, 30), ConvertOptions{Scale: 2, Limits: Limits{MaxPixels: 2000}})
+	assertLimitError(t, err, LimitPixels)
+
+	zpl := ConvertToZPL(checkerImage(40, 30), CompressedASCII)
+	_, err = ConvertZPLToImageContext(context.Background(), zpl, Limits{MaxPixels: 1000})
+	assertLimitError(t, err, LimitPixels)
+}
+
+func Test_LimitOutputBytes(t *testing.T) {
+	img := checkerImage(64, 64)
+	for _, options := range []ConvertOptions{{GraphicType: ASCII}, {GraphicType: CompressedASCII, Workers: 3}, {GraphicType: Z64}, {GraphicType: Binary}} {
+		zpl, err := ConvertToZPLContext(context.Background(), img, options)
+		if err != nil {
+			t.Fatalf("ConvertToZPLContext failed: %s", err)
+		}
+
+		options.Limits.MaxOutputBytes = int64(len(zpl))
+		if _, err := ConvertToZPLContext(context.Background(), img, options); err != nil {
+			t.Fatalf("ConvertToZPLContext failed with a limit of the exact size: %s", err)
+		}
+		options.Limits.MaxOutputBytes--
+		_, err = ConvertToZPLContext(context.Background(), img, options)
+		assertLimitError(t, err, LimitOutputBytes)
+	}
+}
+
+func Test_LimitInflatedBytes(t *testing.T) {
+	zpl := ConvertToZPL(checkerImage(64, 64), Z64)
+	if _, err := ConvertZPLToImageContext(context.Background(), zpl, Limits{MaxInflatedBytes: 512}); err != nil {
+		t.Fatalf("ConvertZPLToImageContext failed: %s", err)
+	}
+	_, err := ConvertZPLToImageContext(context.Background(), zpl, Limits{MaxInflatedBytes: 511})
+	assertLimitError(t, err, LimitInflatedBytes)
+}
+
+func Test_LimitInputBytes(t *testing.T) {
+	var data bytes.Buffer
+	if err := png.Encode(&data, checkerImage(40, 30)); err != nil {
+		t.Fatalf("png.Encode failed: %s", err)
+	}
+
+	options := ConvertOptions{Limits: Limits{MaxInputBytes: int64(data.Len())}}
+	if _, err := ConvertReaderToZPLContext(context.Background(), bytes.NewReader(data.Bytes()), options); err != nil {
+		t.Fatalf("ConvertReaderToZPLContext failed with a limit of the exact size: %s", err)
+	}
+	options.Limits.MaxInputBytes--
+	_, err := ConvertReaderToZPLContext(context.Background(), bytes.NewReader(data.Bytes()), options)
+	assertLimitError(t, err, LimitInputBytes)
+}
+
+func Test_ContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	img := fastPathImages(100, 100)["NRGBA"]
+	for _, options := range []ConvertOptions{{}, {Workers: 4}, {Flatten: FlattenOptions{Alpha: AlphaComposite}}, {Hybrid: &HybridOptions{}}} {
+		if _, err := ConvertToZPLContext(ctx, img, options); !errors.Is(err, context.Canceled) {
+			t.Fatalf("ConvertToZPLContext failed: got %v, want context.Canceled", err)
+		}
+	}
+	if _, err := NewConverter(ConvertOptions{}).ConvertToGraphicFieldContext(ctx, img); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Converter.ConvertToGraphicFieldContext failed: got %v, want context.Canceled", err)
+	}
+	if _, err := ConvertZPLToImageContext(ctx, ConvertToZPL(img, CompressedASCII), Limits{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ConvertZPLToImageContext failed: got %v, want context.Canceled", err)
+	}
+
+	var data bytes.Buffer
+	if err := png.Encode(&data, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatalf("png.Encode failed: %s", err)
+	}
+	if _, err := ConvertReaderToZPLContext(ctx, &data, ConvertOptions{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ConvertReaderToZPLContext failed: got %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,192 @@
+package zplgfa
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// MonochromeModel converts colors to black or white with the threshold the encoder uses.
+var MonochromeModel = color.ModelFunc(monochromeModel)
+
+func monochromeModel(c color.Color) color.Color {
+	if monochromeBlack(c) {
+		return color.Gray{Y: 0}
+	}
+	return color.Gray{Y: 0xff}
+}
+
+func monochromeBlack(c color.Color) bool {
+	return color.Gray16Model.Convert(c).(color.Gray16).Y < math.MaxUint16/2
+}
+
+// Monochrome is an in-memory image with one bit per pixel, set bits are black.
+// Like ZPL graphic field data, the bits are packed most significant bit first.
+// They are aligned to the absolute x coordinate, so the first byte of a row holds
+// the pixels from x = Rect.Min.X&^7 on and sub images share the pixels of their parent.
+type Monochrome struct {
+	// Pix holds the packed pixels, the bit of the pixel at (x, y) is
+	// Pix[(y-Rect.Min.Y)*Stride+(x>>3)-(Rect.Min.X>>3)] & (0x80 >> (x&7)).
+	Pix []byte
+	// Stride is the distance in bytes between vertically adjacent pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+}
+
+// NewMonochrome returns a new white Monochrome image with the given bounds.
+func NewMonochrome(r image.Rectangle) *Monochrome {
+	stride := 0
+	if !r.Empty() {
+		stride = (r.Max.X+7)>>3 - r.Min.X>>3
+	}
+	return &Monochrome{Pix: make([]byte, stride*max(r.Dy(), 0)), Stride: stride, Rect: r}
+}
+
+// ColorModel returns MonochromeModel.
+func (p *Monochrome) ColorModel() color.Model {
+	return MonochromeModel
+}
+
+// Bounds returns the image's bounds.
+func (p *Monochrome) Bounds() image.Rectangle {
+	return p.Rect
+}
+
+// At returns color.Black or color.White as color.Gray.
+func (p *Monochrome) At(x, y int) color.Color {
+	if p.BlackAt(x, y) {
+		return color.Gray{Y: 0}
+	}
+	return color.Gray{Y: 0xff}
+}
+
+// BlackAt reports whether the pixel at x, y is black. Pixels outside of the bounds are white.
+func (p *Monochrome) BlackAt(x, y int) bool {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return false
+	}
+	return p.Pix[p.byteOffset(x, y)]&(0x80>>(x&7)) != 0
+}
+
+// Set sets the pixel at x, y to black if c is darker than half and to white otherwise.
+func (p *Monochrome) Set(x, y int, c color.Color) {
+	p.SetBlack(x, y, monochromeBlack(c))
+}
+
+// SetBlack sets the pixel at x, y to black or white.
+func (p *Monochrome) SetBlack(x, y int, black bool) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	i := p.byteOffset(x, y)
+	if black {
+		p.Pix[i] |= 0x80 >> (x & 7)
+	} else {
+		p.Pix[i] &^= 0x80 >> (x & 7)
+	}
+}
+
+// Row returns the packed bytes of row y, starting with the byte that holds the pixel at Rect.Min.X.
+// Bits for pixels outside of the bounds belong to the parent of a sub image and must be ignored.
+func (p *Monochrome) Row(y int) []byte {
+	if y < p.Rect.Min.Y || y >= p.Rect.Max.Y {
+		return nil
+	}
+	i := (y - p.Rect.Min.Y) * p.Stride
+	return p.Pix[i : i+(p.Rect.Max.X+7)>>3-p.Rect.Min.X>>3]
+}
+
+// SubImage returns an image representing the portion of the image p visible through r.
+// The returned value shares pixels with the original image.
+func (p *Monochrome) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	if r.Empty() {
+		return &Monochrome{}
+	}
+	return &Monochrome{Pix: p.Pix[p.byteOffset(r.Min.X, r.Min.Y):], Stride: p.Stride, Rect: r}
+}
+
+// Opaque reports that the image has no transparent pixels.
+func (p *Monochrome) Opaque() bool {
+	return true
+}
+
+func (p *Monochrome) byteOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + x>>3 - p.Rect.Min.X>>3
+}
+
+// packRows writes the rows start to end, relative to Rect.Min.Y, to raw in rows of bytesPerRow bytes
+// starting at Rect.Min.X, the padding bits at the end of each row are cleared.
+func (p *Monochrome) packRows(raw []byte, bytesPerRow, start, end int) {
+	width := p.Rect.Dx()
+	shift := uint(p.Rect.Min.X & 7)
+	for y := start; y < end; y++ {
+		row := p.Row(p.Rect.Min.Y + y)
+		line := raw[y*bytesPerRow : (y+1)*bytesPerRow]
+		if shift == 0 {
+			copy(line, row)
+		} else {
+			for i := range line {
+				line[i] = row[i] << shift
+				if i+1 < len(row) {
+					line[i] |= row[i+1] >> (8 - shift)
+				}
+			}
+		}
+		if width%8 != 0 {
+			line[bytesPerRow-1] &= 0xff << uint(8-width%8)
+		}
+	}
+}
+
+// ConvertToMonochrome converts any image to a Monochrome image with the threshold the encoder uses.
+// *image.Gray, *image.Paletted and the other types with a fast path are converted without image.At.
+func ConvertToMonochrome(img image.Image) *Monochrome {
+	bounds := img.Bounds()
+	m := NewMonochrome(bounds)
+	if bounds.Empty() {
+		return m
+	}
+
+	read := newBlackRowReader(img)
+	black := make([]bool, bounds.Dx())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		read(y, black)
+		for x, isBlack := range black {
+			if isBlack {
+				m.SetBlack(bounds.Min.X+x, y, true)
+			}
+		}
+	}
+	return m
+}
+
+// Gray converts the image to an *image.Gray with black and white pixels.
+func (p *Monochrome) Gray() *image.Gray {
+	gray := image.NewGray(p.Rect)
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		pix := gray.Pix[gray.PixOffset(p.Rect.Min.X, y):]
+		for x := p.Rect.Min.X; x < p.Rect.Max.X; x++ {
+			if !p.BlackAt(x, y) {
+				pix[x-p.Rect.Min.X] = 0xff
+			}
+		}
+	}
+	return gray
+}
+
+// Paletted converts the image to an *image.Paletted with the palette white, black,
+// so that palette indices match the bits of Monochrome.
+func (p *Monochrome) Paletted() *image.Paletted {
+	paletted := image.NewPaletted(p.Rect, color.Palette{color.White, color.Black})
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		pix := paletted.Pix[paletted.PixOffset(p.Rect.Min.X, y):]
+		for x := p.Rect.Min.X; x < p.Rect.Max.X; x++ {
+			if p.BlackAt(x, y) {
+				pix[x-p.Rect.Min.X] = 1
+			}
+		}
+	}
+	return paletted
+}
@@ -0,0 +1,80 @@
+package zplgfa
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func Test_Monochrome(t *testing.T) {
+	var _ draw.Image = NewMonochrome(image.Rect(0, 0, 1, 1))
+
+	m := NewMonochrome(image.Rect(-3, 2, 10, 4))
+	if m.Stride != 3 || len(m.Pix) != 6 {
+		t.Fatalf("NewMonochrome failed: got stride %d and %d bytes, want 3 and 6", m.Stride, len(m.Pix))
+	}
+	m.Set(-3, 2, color.Black)
+	m.Set(9, 3, color.Gray{Y: 0x7f})
+	m.Set(0, 3, color.Gray{Y: 0x80})
+	m.Set(20, 20, color.Black)
+	if !m.BlackAt(-3, 2) || !m.BlackAt(9, 3) || m.BlackAt(0, 3) || m.BlackAt(20, 20) {
+		t.Fatal("Monochrome Set failed: wrong threshold or bounds check")
+	}
+	if got := m.At(-3, 2); got != (color.Gray{Y: 0}) {
+		t.Fatalf("Monochrome At failed: got %v, want black", got)
+	}
+	if got := m.ColorModel().Convert(color.RGBA{0xff, 0, 0, 0xff}); got != (color.Gray{Y: 0}) {
+		t.Fatalf("MonochromeModel failed: got %v, want black for red", got)
+	}
+	if got := m.Row(2); !bytes.Equal(got, []byte{0x04, 0x00, 0x00}) {
+		t.Fatalf("Monochrome Row failed: got %X, want 040000", got)
+	}
+
+	sub := m.SubImage(image.Rect(8, 3, 12, 5)).(*Monochrome)
+	if sub.Rect != image.Rect(8, 3, 10, 4) || !sub.BlackAt(9, 3) {
+		t.Fatalf("Monochrome SubImage failed: got %v", sub.Rect)
+	}
+	sub.SetBlack(8, 3, true)
+	if !m.BlackAt(8, 3) {
+		t.Fatal("Monochrome SubImage failed: pixels are not shared")
+	}
+}
+
+func Test_MonochromeConverters(t *testing.T) {
+	src := checkerImage(13, 5)
+	m := ConvertToMonochrome(src)
+	assertGrayImageEqual(t, m.Gray(), src)
+
+	paletted := m.Paletted()
+	if paletted.Pix[0] != 1 || paletted.Pix[1] != 0 {
+		t.Fatalf("Monochrome Paletted failed: got indices %v, want 1 for black", paletted.Pix[:2])
+	}
+	if back := ConvertToMonochrome(paletted); !bytes.Equal(back.Pix, m.Pix) {
+		t.Fatal("ConvertToMonochrome failed for *image.Paletted")
+	}
+
+	drawn := NewMonochrome(src.Rect)
+	draw.Draw(drawn, drawn.Rect, src, image.Point{}, draw.Src)
+	if !bytes.Equal(drawn.Pix, m.Pix) {
+		t.Fatal("draw.Draw failed on Monochrome")
+	}
+}
+
+func Test_MonochromeEncodeDecode(t *testing.T) {
+	m := ConvertToMonochrome(checkerImage(21, 6))
+	for _, r := range []image.Rectangle{m.Rect, image.Rect(3, 1, 17, 6), image.Rect(8, 0, 21, 3)} {
+		sub := m.SubImage(r)
+		want := ConvertToGraphicField(genericImage{sub}, CompressedASCII)
+		if got := ConvertToGraphicField(sub, CompressedASCII); got != want {
+			t.Fatalf("ConvertToGraphicField failed for %v: got %q, want %q", r, got, want)
+		}
+
+		decoded, err := ConvertGraphicFieldToImage(want)
+		if err != nil {
+			t.Fatalf("ConvertGraphicFieldToImage failed: %s", err)
+		}
+		assertBilevelEqual(t, decoded, sub)
+	}
+}
@@ -0,0 +1,268 @@
+package zplgfa
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MorphologyOp is a morphological operation on the black dots of a bitmap.
+type MorphologyOp int
+
+const (
+	// MorphErode shrinks black areas and widens white gaps
+	MorphErode MorphologyOp = iota
+	// MorphDilate grows black areas and closes white gaps
+	MorphDilate
+	// MorphOpen erodes and then dilates, removing black specks smaller than the element
+	MorphOpen
+	// MorphClose dilates and then erodes, filling white holes smaller than the element
+	MorphClose
+	// MorphDespeckle flips dots that have no neighbour of the same color within the element
+	MorphDespeckle
+)
+
+// StructuringElement is the shape of the neighbourhood a morphological operation looks at.
+type StructuringElement int
+
+const (
+	// ElementSquare covers all dots within the radius in both directions
+	ElementSquare StructuringElement = iota
+	// ElementCross covers the dots within the radius in the same row and column
+	ElementCross
+	// ElementDisk covers the dots within the euclidean radius
+	ElementDisk
+)
+
+// Morphology configures a morphological operation applied to the bitmap just before encoding.
+type Morphology struct {
+	Op      MorphologyOp
+	Element StructuringElement
+	// Radius is the size of the element in dots, zero means 1
+	Radius int
+}
+
+// ParseMorphology parses a comma separated list of operations with an optional radius and element,
+// e.g. "erode:1", "close:2:disk" or "despeckle,open:1:cross".
+// Operations are erode, dilate, open, close and despeckle, elements are square (default), cross and disk.
+func ParseMorphology(spec string) ([]Morphology, error) {
+	ops := map[string]MorphologyOp{"erode": MorphErode, "dilate": MorphDilate, "open": MorphOpen, "close": MorphClose, "despeckle": MorphDespeckle}
+	elements := map[string]StructuringElement{"square": ElementSquare, "cross": ElementCross, "disk": ElementDisk}
+
+	var morphology []Morphology
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.Split(strings.ToLower(part), ":")
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("invalid morphological operation \"%s\"", part)
+		}
+
+		op, ok := ops[fields[0]]
+		if !ok {
+			return nil, fmt.Errorf("unknown morphological operation \"%s\"", fields[0])
+		}
+		m := Morphology{Op: op, Radius: 1}
+		if len(fields) > 1 {
+			radius, err := strconv.Atoi(fields[1])
+			if err != nil || radius < 1 {
+				return nil, fmt.Errorf("invalid radius \"%s\" for %s", fields[1], fields[0])
+			}
+			m.Radius = radius
+		}
+		if len(fields) > 2 {
+			if m.Element, ok = elements[fields[2]]; !ok {
+				return nil, fmt.Errorf("unknown structuring element \"%s\"", fields[2])
+			}
+		}
+		morphology = append(morphology, m)
+	}
+	return morphology, nil
+}
+
+// offsets returns the relative positions covered by the structuring element, without the center.
+func (m Morphology) offsets() [][2]int {
+	radius := m.Radius
+	if radius < 1 {
+		radius = 1
+	}
+
+	var offsets [][2]int
+	for dy := -radius; dy <= radius; dy++ {
+		for dx := -radius; dx <= radius; dx++ {
+			if dx == 0 && dy == 0 {
+				continue
+			}
+			switch m.Element {
+			case ElementCross:
+				if dx != 0 && dy != 0 {
+					continue
+				}
+			case ElementDisk:
+				if dx*dx+dy*dy > radius*radius {
+					continue
+				}
+			}
+			offsets = append(offsets, [2]int{dx, dy})
+		}
+	}
+	return offsets
+}
+
+// dotBitmap is an unpacked bitmap with one byte per dot, 1 for black.
+type dotBitmap struct {
+	dots          []byte
+	width, height int
+}
+
+func unpackDots(raw []byte, bytesPerRow, width, height int) dotBitmap {
+	bitmap := dotBitmap{dots: make([]byte, width*height), width: width, height: height}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			bitmap.dots[y*width+x] = raw[y*bytesPerRow+x/8] >> (7 - uint(x)%8) & 1
+		}
+	}
+	return bitmap
+}
+
+func (b dotBitmap) pack(bytesPerRow int) []byte {
+	raw := make([]byte, bytesPerRow*b.height)
+	for y := 0; y < b.height; y++ {
+		for x := 0; x < b.width; x++ {
+			if b.dots[y*b.width+x] == 1 {
+				raw[y*bytesPerRow+x/8] |= 1 << (7 - uint(x)%8)
+			}
+		}
+	}
+	return raw
+}
+
+func (b dotBitmap) clone() dotBitmap {
+	return dotBitmap{dots: append([]byte(nil), b.dots...), width: b.width, height: b.height}
+}
+
+// pad adds a white border of n dots.
+func (b dotBitmap) pad(n int) dotBitmap {
+	out := dotBitmap{dots: make([]byte, (b.width+2*n)*(b.height+2*n)), width: b.width + 2*n, height: b.height + 2*n}
+	for y := 0; y < b.height; y++ {
+		copy(out.dots[(y+n)*out.width+n:], b.dots[y*b.width:(y+1)*b.width])
+	}
+	return out
+}
+
+// crop removes a border of n dots.
+func (b dotBitmap) crop(n int) dotBitmap {
+	out := dotBitmap{dots: make([]byte, 0, (b.width-2*n)*(b.height-2*n)), width: b.width - 2*n, height: b.height - 2*n}
+	for y := n; y < b.height-n; y++ {
+		out.dots = append(out.dots, b.dots[y*b.width+n:(y+1)*b.width-n]...)
+	}
+	return out
+}
+
+// rank sets a dot to color if the number of neighbours with that color reaches count.
+// Neighbours outside of the bitmap are ignored.
+func (b dotBitmap) rank(offsets [][2]int, color byte, count func(total int) int) dotBitmap {
+	out := b.clone()
+	for y := 0; y < b.height; y++ {
+		for x := 0; x < b.width; x++ {
+			if b.dots[y*b.width+x] == color {
+				continue
+			}
+			total, matches := 0, 0
+			for _, o := range offsets {
+				nx, ny := x+o[0], y+o[1]
+				if nx < 0 || ny < 0 || nx >= b.width || ny >= b.height {
+					continue
+				}
+				total++
+				if b.dots[ny*b.width+nx] == color {
+					matches++
+				}
+			}
+			if total > 0 && matches >= count(total) {
+				out.dots[y*b.width+x] = color
+			}
+		}
+	}
+	return out
+}
+
+func (b dotBitmap) erode(offsets [][2]int) dotBitmap {
+	return b.rank(offsets, 0, func(int) int { return 1 })
+}
+
+func (b dotBitmap) dilate(offsets [][2]int) dotBitmap {
+	return b.rank(offsets, 1, func(int) int { return 1 })
+}
+
+func (b dotBitmap) apply(m Morphology) dotBitmap {
+	offsets := m.offsets()
+	switch m.Op {
+	case MorphErode:
+		return b.erode(offsets)
+	case MorphDilate:
+		return b.dilate(offsets)
+	case MorphOpen:
+		return b.erode(offsets).dilate(offsets)
+	case MorphClose:
+		// Pad the bitmap so that areas grown beyond the border are eroded again.
+		radius := max(m.Radius, 1)
+		return b.pad(radius).dilate(offsets).erode(offsets).crop(radius)
+	case MorphDespeckle:
+		all := func(total int) int { return total }
+		return b.rank(offsets, 0, all).rank(offsets, 1, all)
+	}
+	return b
+}
+
+// thinBlack shortens every horizontal and vertical run of black dots by n dots at its end, keeping at least one dot.
+func (b dotBitmap) thinBlack(n int) dotBitmap {
+	trim := func(src dotBitmap, lines, length, lineStep, step int) dotBitmap {
+		dst := src.clone()
+		for line := 0; line < lines; line++ {
+			start := line * lineStep
+			for i := 0; i < length; {
+				if src.dots[start+i*step] == 0 {
+					i++
+					continue
+				}
+				run := 0
+				for i+run < length && src.dots[start+(i+run)*step] == 1 {
+					run++
+				}
+				for j := max(run-n, 1); j < run; j++ {
+					dst.dots[start+(i+j)*step] = 0
+				}
+				i += run
+			}
+		}
+		return dst
+	}
+
+	rows := trim(b, b.height, b.width, b.width, 1)
+	return trim(rows, b.width, b.height, 1, b.width)
+}
+
+// processBitmap applies the bilevel scaling, morphological operations and black thinning of options to packed rows.
+// It returns the processed rows with their number of bytes per row and the number of rows.
+func processBitmap(raw []byte, bytesPerRow, width, height int, options ConvertOptions) ([]byte, int, int) {
+	scale := options.Scale > 0 && options.Scale != 1 && options.Resampling == ResampleBilevel
+	if !scale && len(options.Morphology) == 0 && options.ThinBlack <= 0 {
+		return raw, bytesPerRow, height
+	}
+
+	bitmap := unpackDots(raw, bytesPerRow, width, height)
+	if scale && width > 0 && height > 0 {
+		bitmap = bitmap.scale(scaledSize(width, height, options.Scale))
+		bytesPerRow = (bitmap.width + 7) / 8
+	}
+	for _, m := range options.Morphology {
+		bitmap = bitmap.apply(m)
+	}
+	if options.ThinBlack > 0 {
+		bitmap = bitmap.thinBlack(options.ThinBlack)
+	}
+	return bitmap.pack(bytesPerRow), bytesPerRow, bitmap.height
+}
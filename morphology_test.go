@@ -0,0 +1,120 @@
+package zplgfa
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// dotsFromString builds a bitmap from rows of '#' (black) and '.' (white).
+func dotsFromString(rows ...string) dotBitmap {
+	bitmap := dotBitmap{width: len(rows[0]), height: len(rows)}
+	for _, row := range rows {
+		for _, c := range row {
+			if c == '#' {
+				bitmap.dots = append(bitmap.dots, 1)
+			} else {
+				bitmap.dots = append(bitmap.dots, 0)
+			}
+		}
+	}
+	return bitmap
+}
+
+func (b dotBitmap) String() string {
+	var s strings.Builder
+	for i, dot := range b.dots {
+		if i > 0 && i%b.width == 0 {
+			s.WriteByte('|')
+		}
+		s.WriteByte(".#"[dot])
+	}
+	return s.String()
+}
+
+func Test_Morphology(t *testing.T) {
+	src := dotsFromString(
+		"#......",
+		"..###..",
+		"..#.#..",
+		"..###..",
+		".......",
+	)
+
+	tests := []struct {
+		morphology Morphology
+		want       string
+	}{
+		{Morphology{Op: MorphErode}, ".......|.......|.......|.......|......."},
+		{Morphology{Op: MorphDilate, Element: ElementCross}, "#####..|######.|.#####.|.#####.|..###.."},
+		{Morphology{Op: MorphOpen}, ".......|.......|.......|.......|......."},
+		{Morphology{Op: MorphClose}, "#......|..###..|..###..|..###..|......."},
+		{Morphology{Op: MorphDespeckle}, ".......|..###..|..###..|..###..|......."},
+	}
+	for _, tt := range tests {
+		if got := src.apply(tt.morphology).String(); got != tt.want {
+			t.Fatalf("Morphology %v failed: got %s, want %s", tt.morphology, got, tt.want)
+		}
+	}
+
+	if got := (Morphology{Radius: 2, Element: ElementDisk}).offsets(); len(got) != 12 {
+		t.Fatalf("disk element failed: got %d offsets, want 12", len(got))
+	}
+}
+
+func Test_ThinBlack(t *testing.T) {
+	src := dotsFromString(
+		"####.##.#..",
+		"####.##.#..",
+		"####.##.#..",
+	)
+	want := "##...#..#..|...........|..........."
+	if got := src.thinBlack(2).String(); got != want {
+		t.Fatalf("thinBlack failed: got %s, want %s", got, want)
+	}
+}
+
+func Test_ParseMorphology(t *testing.T) {
+	got, err := ParseMorphology("erode, close:2:disk,despeckle:1:cross")
+	if err != nil {
+		t.Fatalf("ParseMorphology failed: %v", err)
+	}
+	want := []Morphology{{MorphErode, ElementSquare, 1}, {MorphClose, ElementDisk, 2}, {MorphDespeckle, ElementCross, 1}}
+	if len(got) != len(want) {
+		t.Fatalf("ParseMorphology failed: got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("ParseMorphology failed: got %v, want %v", got, want)
+		}
+	}
+
+	for _, spec := range []string{"shrink", "erode:0", "erode:x", "erode:1:star", "erode:1:square:2"} {
+		if _, err := ParseMorphology(spec); err == nil {
+			t.Fatalf("ParseMorphology failed: %q was accepted", spec)
+		}
+	}
+}
+
+func Test_ConvertToGraphicFieldWithOptions(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 16, 2))
+	fillGray(img, color.White)
+	for x := 2; x < 10; x++ {
+		img.Pix[x] = 0
+		img.Pix[16+x] = 0
+	}
+
+	got, err := ConvertToGraphicFieldWithOptions(img, ConvertOptions{GraphicType: ASCII, ThinBlack: 3})
+	if err != nil {
+		t.Fatalf("ConvertToGraphicFieldWithOptions failed: %v", err)
+	}
+	if want := "^GFA,10,4,2,\n3E00\n0000\n"; got != want {
+		t.Fatalf("ConvertToGraphicFieldWithOptions failed: got %q, want %q", got, want)
+	}
+
+	plain, _ := ConvertToGraphicFieldWithOptions(img, ConvertOptions{GraphicType: ASCII})
+	if want, _ := ConvertToGraphicFieldWithError(img, ASCII); plain != want {
+		t.Fatalf("ConvertToGraphicFieldWithOptions failed: got %q without bitmap operations, want %q", plain, want)
+	}
+}
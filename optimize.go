@@ -0,0 +1,226 @@
+package zplgfa
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// OptimizeOptions controls OptimizeZPLContext.
+type OptimizeOptions struct {
+	// KeepComments keeps ^FX comments.
+	KeepComments bool
+	// Verify renders the document before and after the optimization with RenderLabelsContext
+	// and returns an error if a label changed.
+	Verify bool
+}
+
+// OptimizeResult is a document shrunk by OptimizeZPL.
+type OptimizeResult struct {
+	ZPL string
+	// Before and After are the sizes of the document in bytes.
+	Before, After int
+	// Comments counts the removed ^FX comments, Commands the removed redundant commands
+	// and Boxes the ^GB boxes merged into the box before them.
+	Comments, Commands, Boxes int
+}
+
+// Saved returns the number of bytes the optimized document is smaller than the original one.
+func (r OptimizeResult) Saved() int {
+	return r.Before - r.After
+}
+
+// stateCommands stay in effect until they are changed, so repeating them with the same parameters does nothing.
+var stateCommands = []string{"BY", "CF", "CI", "FW", "LH", "LL", "LS", "LT", "MD", "PM", "PO", "PR", "PW"}
+
+// OptimizeZPL removes what doesn't change the printout of a ZPL document: ^FX comments, line breaks and
+// whitespace between commands, parameters of commands that take none, ^FS without a field and state commands
+// that repeat the setting in effect. Filled ^GB boxes of consecutive fields that continue each other downwards
+// with the same width, like the rows of ConvertToZPLLines, are merged into one. Field data, download commands
+// and binary graphics are kept as they are.
+func OptimizeZPL(zpl string) (OptimizeResult, error) {
+	return OptimizeZPLContext(context.Background(), zpl, OptimizeOptions{}, Limits{})
+}
+
+// OptimizeZPLContext is OptimizeZPL with options and with limits for the size of the optimized document and,
+// with Verify, for rendering it. It stops with the error of ctx once ctx is done.
+func OptimizeZPLContext(ctx context.Context, zpl string, options OptimizeOptions, limits Limits) (OptimizeResult, error) {
+	result := OptimizeResult{Before: len(zpl)}
+	var kept []Command
+	var boxes boxRun
+	state := map[string]string{}
+
+	for _, command := range ParseCommands(zpl) {
+		if err := canceled(ctx); err != nil {
+			return OptimizeResult{}, err
+		}
+		switch {
+		case command.Prefix == 0:
+			if strings.TrimSpace(command.Params) == "" {
+				continue
+			}
+		case command.Is("^FX") && !options.KeepComments:
+			result.Comments++
+			continue
+		case command.Is("^XA"), command.Is("^XZ"):
+			clear(state)
+		case command.Is("^FS") && !fieldOpen(kept):
+			result.Commands++
+			continue
+		}
+
+		command.Params = optimizeParams(command)
+		if !command.Control && slices.Contains(stateCommands, command.Name) {
+			if previous, ok := state[command.Name]; ok && previous == command.Params {
+				result.Commands++
+				continue
+			}
+			state[command.Name] = command.Params
+		}
+		kept = append(kept, command)
+		if merged, ok := boxes.merge(kept); ok {
+			kept = merged
+			result.Boxes++
+		}
+	}
+
+	var out strings.Builder
+	out.Grow(len(zpl))
+	for _, command := range kept {
+		out.WriteString(command.String())
+	}
+	if err := limits.checkOutput(out.Len()); err != nil {
+		return OptimizeResult{}, err
+	}
+	result.ZPL = out.String()
+	result.After = len(result.ZPL)
+
+	if options.Verify {
+		if err := compareRenderings(ctx, zpl, result.ZPL, limits); err != nil {
+			return OptimizeResult{}, err
+		}
+	}
+	return result, nil
+}
+
+// fieldOpen reports whether a ^FS after commands would end a field, it doesn't after ^XA, ^XZ or another ^FS.
+func fieldOpen(commands []Command) bool {
+	if len(commands) == 0 {
+		return false
+	}
+	last := commands[len(commands)-1]
+	return !last.Is("^FS") && !last.Is("^XA") && !last.Is("^XZ")
+}
+
+// optimizeParams removes the line breaks and trailing whitespace from the parameters of a command.
+// Commands without parameters lose them entirely, data is kept as it is.
+func optimizeParams(command Command) string {
+	switch {
+	case command.Prefix == 0, command.hasData():
+		return command.Params
+	case command.Is("^XA"), command.Is("^XZ"), command.Is("^FS"), command.Is("^FR"):
+		return ""
+	}
+	params := strings.NewReplacer("\r", "", "\n", "").Replace(command.Params)
+	return strings.TrimRight(params, " \t")
+}
+
+// boxField is a field of the commands ^FO, ^GB and ^FS with an optional ^FR before ^FO or ^GB.
+type boxField struct {
+	x, y    int
+	box     box
+	reverse bool
+}
+
+// parseBoxField parses the box field at the end of commands and returns the number of its commands.
+// Only filled black boxes without rounded corners and justification qualify.
+func parseBoxField(commands []Command) (boxField, int, bool) {
+	n := len(commands)
+	if n < 3 || !commands[n-1].Is("^FS") || !commands[n-2].Is("^GB") {
+		return boxField{}, 0, false
+	}
+	var field boxField
+	start := n - 3
+	if commands[start].Is("^FR") {
+		field.reverse = true
+		start--
+	}
+	if start < 0 || !commands[start].Is("^FO") {
+		return boxField{}, 0, false
+	}
+	position := strings.Split(commands[start].Params, ",")
+	// a ^FR before ^FO reverses the field as well
+	if start > 0 && commands[start-1].Is("^FR") {
+		field.reverse = true
+		start--
+	}
+	if len(position) != 2 {
+		return boxField{}, 0, false
+	}
+	var errX, errY error
+	field.x, errX = strconv.Atoi(strings.TrimSpace(position[0]))
+	field.y, errY = strconv.Atoi(strings.TrimSpace(position[1]))
+	field.box = parseBox(commands[n-2].Params)
+	if errX != nil || errY != nil || field.box.white || field.box.rounding != 0 || !field.box.filled() {
+		return boxField{}, 0, false
+	}
+	return field, n - start, true
+}
+
+// boxRun finds the boxes to merge in a run of consecutive box fields. The fields of a run are all black
+// or all reversed, so the order they are drawn in doesn't matter.
+type boxRun struct {
+	// bottoms holds the index of the ^GB command of each box by its left edge, bottom and width
+	bottoms map[[3]int]int
+	end     int
+	reverse bool
+}
+
+// merge merges the box field at the end of commands into a box of the run it continues downwards with
+// the same width, or adds it to the run.
+func (r *boxRun) merge(commands []Command) ([]Command, bool) {
+	field, n, ok := parseBoxField(commands)
+	if !ok {
+		return commands, false
+	}
+	start := len(commands) - n
+	if r.bottoms == nil || start != r.end || field.reverse != r.reverse {
+		r.bottoms, r.reverse = map[[3]int]int{}, field.reverse
+	}
+	width := field.box.width
+	if i, ok := r.bottoms[[3]int{field.x, field.y, width}]; ok {
+		height := parseBox(commands[i].Params).height + field.box.height
+		commands[i].Params = fmt.Sprintf("%d,%d,%d", width, height, min(width, height))
+		delete(r.bottoms, [3]int{field.x, field.y, width})
+		r.bottoms[[3]int{field.x, field.y + field.box.height, width}] = i
+		r.end = start
+		return commands[:start], true
+	}
+	r.bottoms[[3]int{field.x, field.y + field.box.height, width}] = len(commands) - 2
+	r.end = len(commands)
+	return commands, false
+}
+
+// compareRenderings returns an error if the labels of two documents render differently.
+func compareRenderings(ctx context.Context, before, after string, limits Limits) error {
+	want, err := RenderLabelsContext(ctx, before, limits)
+	if err != nil {
+		return err
+	}
+	got, err := RenderLabelsContext(ctx, after, limits)
+	if err != nil {
+		return err
+	}
+	if len(got) != len(want) {
+		return fmt.Errorf("optimized document has %d labels, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Rect != want[i].Rect || !bytes.Equal(got[i].Pix, want[i].Pix) {
+			return fmt.Errorf("label %d renders differently after the optimization", i)
+		}
+	}
+	return nil
+}
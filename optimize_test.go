@@ -0,0 +1,79 @@
+package zplgfa
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func Test_OptimizeZPL(t *testing.T) {
+	zpl := "^XA,^FS\n^FX a comment\n^LH10,10^LH10,10\n^BY2^FS\n" +
+		"^FO5,5^GB10,1,1^FS\n^FO5,6^GB10,1,1^FS\n^FO5,7^FR^GB10,3,1^FS^FS\n" +
+		"^FO0,0^A0N,20 \n^FD  Hello\nWorld ^FS\n^XZ\n^XA^LH10,10^XZ\n"
+	want := "^XA^LH10,10^BY2^FS^FO5,5^GB10,2,2^FS^FO5,7^FR^GB10,3,1^FS^FO0,0^A0N,20^FD  Hello\nWorld ^FS^XZ^XA^LH10,10^XZ"
+	result, err := OptimizeZPL(zpl)
+	if err != nil {
+		t.Fatalf("OptimizeZPL failed: %v", err)
+	}
+	if result.ZPL != want {
+		t.Fatalf("OptimizeZPL failed: got %q, want %q", result.ZPL, want)
+	}
+	if result.Comments != 1 || result.Commands != 3 || result.Boxes != 1 || result.Saved() != len(zpl)-len(want) {
+		t.Fatalf("OptimizeZPL failed: got %+v", result)
+	}
+
+	again, err := OptimizeZPL(result.ZPL)
+	if err != nil || again.ZPL != result.ZPL {
+		t.Fatalf("OptimizeZPL failed: optimizing twice changed the document to %q (%v)", again.ZPL, err)
+	}
+	kept, _ := OptimizeZPLContext(context.Background(), zpl, OptimizeOptions{KeepComments: true}, Limits{})
+	if kept.Comments != 0 || !strings.Contains(kept.ZPL, "^FX a comment\n") {
+		t.Fatalf("OptimizeZPLContext failed: got %q without the comment", kept.ZPL)
+	}
+}
+
+func Test_OptimizeZPLRenders(t *testing.T) {
+	img := repeatedRowsImage(120, 60)
+	documents := []string{
+		ConvertToZPLLines(img),
+		ConvertToZPLLinesAt(checkerImage(20, 9), 3, 4),
+		ConvertToZPL(img, ASCII),
+		ConvertToZPL(img, CompressedASCII) + ConvertToZPL(img, Z64),
+		"^XA\n^FO1,1\n" + ConvertToGraphicField(img, Binary) + "^FS\n^XZ\n",
+	}
+	for i, zpl := range documents {
+		result, err := OptimizeZPLContext(context.Background(), zpl, OptimizeOptions{Verify: true}, Limits{})
+		if err != nil {
+			t.Fatalf("OptimizeZPLContext failed for document %d: %v", i, err)
+		}
+		if result.Saved() <= 0 {
+			t.Fatalf("OptimizeZPLContext failed for document %d: saved %d bytes", i, result.Saved())
+		}
+	}
+
+	lines, _ := OptimizeZPL(documents[0])
+	if lines.Boxes == 0 || strings.Count(lines.ZPL, "^GB") >= strings.Count(documents[0], "^GB") {
+		t.Fatalf("OptimizeZPL failed: merged %d boxes", lines.Boxes)
+	}
+
+	// a ^FR before ^FO reverses the box, which must not be merged with the black box below it
+	reversed := "^XA^FO0,0^GB20,20,20^FS^FR^FO0,0^GB10,10,10^FS^FO0,10^GB10,10,10^FS^XZ"
+	result, err := OptimizeZPLContext(context.Background(), reversed, OptimizeOptions{Verify: true}, Limits{})
+	if err != nil || result.Boxes != 0 {
+		t.Fatalf("OptimizeZPLContext failed: merged %d boxes (%v)", result.Boxes, err)
+	}
+	reversed = "^XA^FR^FO0,0^GB10,10,10^FS^FR^FO0,10^GB10,10,10^FS^XZ"
+	if result, err := OptimizeZPL(reversed); err != nil || result.ZPL != "^XA^FR^FO0,0^GB10,20,10^FS^XZ" {
+		t.Fatalf("OptimizeZPL failed: got %q (%v)", result.ZPL, err)
+	}
+}
+
+func Test_OptimizeZPLKeepsData(t *testing.T) {
+	binary := ConvertToGraphicField(checkerImage(16, 10), Binary)
+	zpl := "^XA^CC+\n+FO0,0+FR+FS\n+FO0,0+" + binary[1:] + "+FS\n~DGR:A.GRF,2,1,\nFF\nFF\n+XZ"
+	want := "^XA^CC++FO0,0+FR+FS+FO0,0" + "+" + binary[1:] + "+FS~DGR:A.GRF,2,1,\nFF\nFF\n+XZ"
+	result, err := OptimizeZPL(zpl)
+	if err != nil || result.ZPL != want {
+		t.Fatalf("OptimizeZPL failed: got %q, want %q (%v)", result.ZPL, want, err)
+	}
+}
@@ -0,0 +1,89 @@
+package zplgfa
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+)
+
+// DecodeAll decodes every page of image data from r and returns the pages with the format name.
+// Multi-page TIFF files return one image per page, all other formats a single image.
+func DecodeAll(r io.Reader) ([]image.Image, string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if isTIFF(data) {
+		pages, err := DecodeTIFFPages(bytes.NewReader(data))
+		return pages, "tiff", err
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, format, err
+	}
+	return []image.Image{img}, format, nil
+}
+
+// ConvertPagesToZPL converts every page to its own ZPL label and returns all labels in one string.
+// Empty pages are skipped.
+func ConvertPagesToZPL(pages []image.Image, options ConvertOptions) (string, error) {
+	var labels bytes.Buffer
+	for i, page := range pages {
+		if page == nil || page.Bounds().Dx() == 0 || page.Bounds().Dy() == 0 {
+			continue
+		}
+		label, err := convertToZPL(page, options)
+		if err != nil {
+			return "", fmt.Errorf("page %d: %w", i+1, err)
+		}
+		labels.WriteString(label)
+	}
+	return labels.String(), nil
+}
+
+// ConvertPagesToDownloadGraphics converts every page to its own ~DG download graphic and returns all of them in one string.
+// The graphics are named after name with the page number appended, e.g. R:FRAME001.GRF for the name "FRAME".
+func ConvertPagesToDownloadGraphics(pages []image.Image, name string, graphicType GraphicType) (string, error) {
+	device, base := "R:", strings.ToUpper(name)
+	if i := strings.Index(base, ":"); i != -1 {
+		device, base = base[:i+1], base[i+1:]
+	}
+	base = strings.TrimSuffix(base, ".GRF")
+
+	var graphics bytes.Buffer
+	for i, page := range pages {
+		if page == nil || page.Bounds().Dx() == 0 || page.Bounds().Dy() == 0 {
+			continue
+		}
+		graphic, err := ConvertToDownloadGraphic(page, fmt.Sprintf("%s%s%03d.GRF", device, base, i+1), graphicType)
+		if err != nil {
+			return "", fmt.Errorf("page %d: %w", i+1, err)
+		}
+		graphics.WriteString(graphic)
+	}
+	return graphics.String(), nil
+}
+
+// ConvertReaderToZPLPages decodes all pages of image data from reader and converts each page to its own ZPL label.
+// The pages are rotated or mirrored according to the EXIF orientation of the data.
+func ConvertReaderToZPLPages(reader io.Reader, graphicType GraphicType) (string, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	pages, _, err := DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	orientation, err := ReadOrientation(bytes.NewReader(data))
+	if err != nil {
+		orientation = OrientationNormal
+	}
+	for i, page := range pages {
+		pages[i] = FlattenImage(ApplyOrientation(page, orientation))
+	}
+	return ConvertPagesToZPL(pages, ConvertOptions{GraphicType: graphicType})
+}
@@ -0,0 +1,169 @@
+package zplgfa
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+func init() {
+	image.RegisterFormat("pcx", "\x0a?\x01", DecodePCX, DecodePCXConfig)
+}
+
+// encodePCX writes packed one bit per dot rows as a run-length encoded
+// monochrome PCX file. PCX uses set bits for white dots, so the rows are
+// inverted, which also turns the padding bits white.
+func encodePCX(raw []byte, bytesPerRow, width, height int) []byte {
+	bytesPerLine := bytesPerRow + bytesPerRow%2
+	header := make([]byte, 128)
+	header[0] = 0x0a // manufacturer
+	header[1] = 5    // version
+	header[2] = 1    // run-length encoding
+	header[3] = 1    // bits per pixel
+	binary.LittleEndian.PutUint16(header[8:], uint16(width-1))
+	binary.LittleEndian.PutUint16(header[10:], uint16(height-1))
+	binary.LittleEndian.PutUint16(header[12:], 203)
+	binary.LittleEndian.PutUint16(header[14:], 203)
+	copy(header[19:22], []byte{0xff, 0xff, 0xff})
+	header[65] = 1 // color planes
+	binary.LittleEndian.PutUint16(header[66:], uint16(bytesPerLine))
+	binary.LittleEndian.PutUint16(header[68:], 1)
+
+	out := header
+	line := make([]byte, bytesPerLine)
+	for y := 0; y < height; y++ {
+		for i := range line {
+			line[i] = 0xff
+			if i < bytesPerRow {
+				line[i] = ^raw[y*bytesPerRow+i]
+			}
+		}
+		out = appendPCXLine(out, line)
+	}
+	return out
+}
+
+// appendPCXLine run-length encodes a single scan line.
+func appendPCXLine(out, line []byte) []byte {
+	const maxRun = 0x3f
+	for i := 0; i < len(line); {
+		run := 1
+		for i+run < len(line) && run < maxRun && line[i+run] == line[i] {
+			run++
+		}
+		if run > 1 || line[i]&0xc0 == 0xc0 {
+			out = append(out, 0xc0|byte(run))
+		}
+		out = append(out, line[i])
+		i += run
+	}
+	return out
+}
+
+// EncodePCX writes an image as a run-length encoded monochrome PCX file.
+func EncodePCX(w io.Writer, img image.Image) error {
+	if img.Bounds().Dx() == 0 || img.Bounds().Dy() == 0 {
+		return fmt.Errorf("empty image")
+	}
+	raw, bytesPerRow := packImage(img)
+	_, err := w.Write(encodePCX(raw, bytesPerRow, img.Bounds().Dx(), img.Bounds().Dy()))
+	return err
+}
+
+// DecodePCX reads a monochrome PCX file and returns it as a two color paletted image.
+func DecodePCX(r io.Reader) (image.Image, error) {
+	header, width, height, err := readPCXHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	bytesPerLine := int(binary.LittleEndian.Uint16(header[66:]))
+	if bytesPerLine*8 < width {
+		return nil, fmt.Errorf("invalid PCX bytes per line %d", bytesPerLine)
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, width, height), pcxPalette(header))
+	reader := bufio.NewReader(r)
+	line := make([]byte, bytesPerLine)
+	for y := 0; y < height; y++ {
+		if err := readPCXLine(reader, line); err != nil {
+			return nil, err
+		}
+		row := img.Pix[y*img.Stride : y*img.Stride+width]
+		for x := range row {
+			row[x] = line[x/8] >> (7 - uint(x)%8) & 1
+		}
+	}
+	return img, nil
+}
+
+// DecodePCXConfig returns the dimensions of a monochrome PCX file without decoding the image data.
+func DecodePCXConfig(r io.Reader) (image.Config, error) {
+	header, width, height, err := readPCXHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{
+		ColorModel: pcxPalette(header),
+		Width:      width,
+		Height:     height,
+	}, nil
+}
+
+func readPCXHeader(r io.Reader) ([]byte, int, int, error) {
+	header := make([]byte, 128)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, 0, 0, fmt.Errorf("invalid PCX header: %w", err)
+	}
+	if header[0] != 0x0a || header[2] != 1 {
+		return nil, 0, 0, fmt.Errorf("invalid PCX header")
+	}
+	if header[3] != 1 || header[65] != 1 {
+		return nil, 0, 0, fmt.Errorf("unsupported PCX format: %d bits per pixel, %d planes", header[3], header[65])
+	}
+
+	xMin := int(binary.LittleEndian.Uint16(header[4:]))
+	yMin := int(binary.LittleEndian.Uint16(header[6:]))
+	xMax := int(binary.LittleEndian.Uint16(header[8:]))
+	yMax := int(binary.LittleEndian.Uint16(header[10:]))
+	if xMax < xMin || yMax < yMin {
+		return nil, 0, 0, fmt.Errorf("invalid PCX dimensions")
+	}
+	return header, xMax - xMin + 1, yMax - yMin + 1, nil
+}
+
+// pcxPalette returns the two color palette of a monochrome PCX header.
+// Files without a palette use black for unset and white for set bits.
+func pcxPalette(header []byte) color.Palette {
+	black := color.RGBA{header[16], header[17], header[18], 0xff}
+	white := color.RGBA{header[19], header[20], header[21], 0xff}
+	if black == white {
+		return color.Palette{color.Black, color.White}
+	}
+	return color.Palette{black, white}
+}
+
+// readPCXLine decodes one run-length encoded scan line into line.
+func readPCXLine(reader io.ByteReader, line []byte) error {
+	for i := 0; i < len(line); {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return fmt.Errorf("PCX data too short: %w", err)
+		}
+		run := 1
+		if b&0xc0 == 0xc0 {
+			run = int(b & 0x3f)
+			if b, err = reader.ReadByte(); err != nil {
+				return fmt.Errorf("PCX data too short: %w", err)
+			}
+		}
+		for ; run > 0 && i < len(line); run-- {
+			line[i] = b
+			i++
+		}
+	}
+	return nil
+}
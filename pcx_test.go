@@ -0,0 +1,49 @@
+package zplgfa
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func Test_PCXRoundTrip(t *testing.T) {
+	img := checkerImage(13, 4)
+
+	var buf bytes.Buffer
+	if err := EncodePCX(&buf, img); err != nil {
+		t.Fatalf("EncodePCX failed: %s", err)
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("DecodeConfig failed: %s", err)
+	}
+	if format != "pcx" || config.Width != 13 || config.Height != 4 {
+		t.Fatalf("DecodeConfig failed: got %s %dx%d", format, config.Width, config.Height)
+	}
+
+	decoded, _, err := image.Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decode failed: %s", err)
+	}
+	if !decoded.Bounds().Eq(img.Bounds()) {
+		t.Fatalf("Decode bounds failed: got %v, want %v", decoded.Bounds(), img.Bounds())
+	}
+	assertBilevelEqual(t, decoded, img)
+}
+
+func Test_DecodePCXError(t *testing.T) {
+	header := make([]byte, 128)
+	header[0], header[2], header[3], header[65] = 0x0a, 1, 8, 3
+	if _, err := DecodePCX(bytes.NewReader(header)); err == nil {
+		t.Fatal("DecodePCX should fail for 24 bit images")
+	}
+
+	var buf bytes.Buffer
+	if err := EncodePCX(&buf, checkerImage(8, 8)); err != nil {
+		t.Fatalf("EncodePCX failed: %s", err)
+	}
+	if _, err := DecodePCX(bytes.NewReader(buf.Bytes()[:buf.Len()-2])); err == nil {
+		t.Fatal("DecodePCX should fail for truncated data")
+	}
+}
@@ -0,0 +1,249 @@
+package zplgfa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+)
+
+// RenderLabels draws the ^GF graphic fields and ^GB boxes of every ^XA format of a ZPL document, one image per
+// format. A label is as wide as ^PW and as long as ^LL, or as large as its content if they are missing.
+// Fields are placed with ^LH, ^FO and ^FT and inverted with ^FR. Text, barcodes and other fields are not drawn
+// and the corners of boxes are not rounded, so the images show the graphics of a label, not the whole printout.
+// A graphic field that can't be decoded stops the rendering with its *DecodeError.
+func RenderLabels(zpl string) ([]*Monochrome, error) {
+	return RenderLabelsContext(context.Background(), zpl, Limits{})
+}
+
+// RenderLabelsContext is RenderLabels with limits for decoding the graphic fields and for the size of each label,
+// stopping with the error of ctx once ctx is done.
+func RenderLabelsContext(ctx context.Context, zpl string, limits Limits) ([]*Monochrome, error) {
+	var labels []*Monochrome
+	var label labelRenderer
+	inLabel := false
+	fields := 0
+
+	for _, command := range ParseCommands(zpl) {
+		if command.Control {
+			continue
+		}
+		switch command.Name {
+		case "XA":
+			label.start()
+			inLabel = true
+		case "XZ":
+			if inLabel {
+				img, err := label.draw(limits)
+				if err != nil {
+					return labels, err
+				}
+				labels = append(labels, img)
+				inLabel = false
+			}
+		case "LH":
+			label.home = parsePoint(command.Params)
+		case "PW":
+			label.width = parseNumber(command.Params, 0)
+		case "LL":
+			label.length = parseNumber(command.Params, 0)
+		case "FO", "FT":
+			label.origin = label.home.Add(parsePoint(command.Params))
+			label.bottom = command.Name == "FT"
+		case "FR":
+			label.reverse = true
+		case "FS":
+			label.endField()
+		case "GB":
+			label.addBox(parseBox(command.Params))
+		case "GF":
+			if err := canceled(ctx); err != nil {
+				return labels, err
+			}
+			img, err := ConvertGraphicFieldToImageContext(ctx, zpl[command.Offset:command.End], limits)
+			if err != nil {
+				var decodeErr *DecodeError
+				if errors.As(err, &decodeErr) {
+					decodeErr.Offset += command.Offset
+					decodeErr.Field = fields
+				}
+				return labels, err
+			}
+			fields++
+			label.addGraphic(img)
+		}
+	}
+	if inLabel {
+		img, err := label.draw(limits)
+		if err != nil {
+			return labels, err
+		}
+		labels = append(labels, img)
+	}
+	return labels, nil
+}
+
+// paintMode is how a field changes the dots of a label.
+type paintMode int
+
+const (
+	paintBlack paintMode = iota
+	paintWhite
+	paintReverse
+)
+
+// renderOp is a box or graphic to draw on a label, in the order of the document.
+type renderOp struct {
+	rect image.Rectangle
+	// graphic is nil for boxes, which have a border of thickness dots
+	graphic   *Monochrome
+	thickness int
+	mode      paintMode
+}
+
+// labelRenderer collects the fields of a format. The label home and size stay in effect for the next formats
+// like they do on a printer.
+type labelRenderer struct {
+	home, origin  image.Point
+	width, length int
+	bottom        bool
+	reverse       bool
+	ops           []renderOp
+}
+
+func (r *labelRenderer) start() {
+	r.ops = r.ops[:0]
+	r.endField()
+}
+
+func (r *labelRenderer) endField() {
+	r.origin, r.bottom, r.reverse = r.home, false, false
+}
+
+// place returns the rectangle of a field of the given size at the current origin.
+func (r *labelRenderer) place(size image.Point) image.Rectangle {
+	corner := r.origin
+	if r.bottom {
+		corner.Y -= size.Y
+	}
+	return image.Rectangle{Min: corner, Max: corner.Add(size)}
+}
+
+func (r *labelRenderer) addBox(b box) {
+	mode := paintBlack
+	switch {
+	case r.reverse && b.white:
+		return
+	case r.reverse:
+		mode = paintReverse
+	case b.white:
+		mode = paintWhite
+	}
+	r.ops = append(r.ops, renderOp{rect: r.place(image.Pt(b.width, b.height)), thickness: b.thickness, mode: mode})
+}
+
+func (r *labelRenderer) addGraphic(img *Monochrome) {
+	mode := paintBlack
+	if r.reverse {
+		mode = paintReverse
+	}
+	r.ops = append(r.ops, renderOp{rect: r.place(img.Rect.Size()), graphic: img, mode: mode})
+}
+
+// draw paints the collected fields on a white label.
+func (r *labelRenderer) draw(limits Limits) (*Monochrome, error) {
+	width, length := r.width, r.length
+	for _, op := range r.ops {
+		if r.width <= 0 {
+			width = max(width, op.rect.Max.X)
+		}
+		if r.length <= 0 {
+			length = max(length, op.rect.Max.Y)
+		}
+	}
+	if err := checkGraphicSize(width, length); err != nil {
+		return nil, fmt.Errorf("label too large: %w", err)
+	}
+	if err := limits.checkPixels(width, length); err != nil {
+		return nil, err
+	}
+
+	canvas := NewMonochrome(image.Rect(0, 0, max(width, 0), max(length, 0)))
+	for _, op := range r.ops {
+		if op.graphic != nil {
+			offset := op.rect.Min.Sub(op.graphic.Rect.Min)
+			for y := op.graphic.Rect.Min.Y; y < op.graphic.Rect.Max.Y; y++ {
+				for x := op.graphic.Rect.Min.X; x < op.graphic.Rect.Max.X; x++ {
+					if op.graphic.BlackAt(x, y) {
+						paint(canvas, x+offset.X, y+offset.Y, op.mode)
+					}
+				}
+			}
+			continue
+		}
+		inner := op.rect.Inset(op.thickness)
+		area := op.rect.Intersect(canvas.Rect)
+		for y := area.Min.Y; y < area.Max.Y; y++ {
+			for x := area.Min.X; x < area.Max.X; x++ {
+				if !(image.Point{x, y}.In(inner)) {
+					paint(canvas, x, y, op.mode)
+				}
+			}
+		}
+	}
+	return canvas, nil
+}
+
+// paint changes the dot at x, y of img, dots outside of its bounds are ignored.
+func paint(img *Monochrome, x, y int, mode paintMode) {
+	switch mode {
+	case paintBlack:
+		img.SetBlack(x, y, true)
+	case paintWhite:
+		img.SetBlack(x, y, false)
+	case paintReverse:
+		img.SetBlack(x, y, !img.BlackAt(x, y))
+	}
+}
+
+// box holds the parameters of a ^GB command.
+type box struct {
+	width, height, thickness int
+	white                    bool
+	rounding                 int
+}
+
+// parseBox parses the parameters of ^GB with the defaults of ZPL: a thickness of 1 and a box
+// no smaller than its border.
+func parseBox(params string) box {
+	parts := strings.Split(params, ",")
+	param := func(i int) string {
+		if i < len(parts) {
+			return strings.TrimSpace(parts[i])
+		}
+		return ""
+	}
+	b := box{thickness: max(parseNumber(param(2), 1), 1)}
+	b.width = max(parseNumber(param(0), b.thickness), b.thickness)
+	b.height = max(parseNumber(param(1), b.thickness), b.thickness)
+	b.white = strings.EqualFold(param(3), "W")
+	b.rounding = parseNumber(param(4), 0)
+	return b
+}
+
+// filled reports whether the border of the box covers all of it.
+func (b box) filled() bool {
+	return b.width <= 2*b.thickness || b.height <= 2*b.thickness
+}
+
+// parseNumber returns the number in params up to the first comma, or fallback if there is none.
+func parseNumber(params string, fallback int) int {
+	value, _, _ := strings.Cut(params, ",")
+	number, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return fallback
+	}
+	return number
+}
@@ -0,0 +1,90 @@
+package zplgfa
+
+import (
+	"context"
+	"errors"
+	"image"
+	"strings"
+	"testing"
+)
+
+// dotsString draws the dots of img as rows of # and . for readable test failures.
+func dotsString(img *Monochrome) string {
+	var rows []string
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		var row strings.Builder
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			if img.BlackAt(x, y) {
+				row.WriteByte('#')
+			} else {
+				row.WriteByte('.')
+			}
+		}
+		rows = append(rows, row.String())
+	}
+	return strings.Join(rows, "\n")
+}
+
+func Test_RenderLabels(t *testing.T) {
+	zpl := "^XA^PW12^LL6^LH1,1\n" +
+		"^FO0,0^GB4,3,1^FS\n" +
+		"^FO6,0^GB3,2,2^FS\n" +
+		"^FO0,3^FR^GB6,1,1^FS\n" +
+		"^FO0,3^GB2,1,1,W^FS\n" +
+		"^FX text is not drawn\n^FO0,0^A0N,20^FDHello^FS\n" +
+		"^XZ"
+	labels, err := RenderLabels(zpl)
+	if err != nil || len(labels) != 1 {
+		t.Fatalf("RenderLabels failed: got %d labels (%v), want 1", len(labels), err)
+	}
+	want := strings.Join([]string{
+		"............",
+		".####..###..",
+		".#..#..###..",
+		".####.......",
+		"...####.....",
+		"............",
+	}, "\n")
+	if got := dotsString(labels[0]); got != want {
+		t.Fatalf("RenderLabels failed: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func Test_RenderLabelsGraphic(t *testing.T) {
+	field := ConvertToGraphicField(checkerImage(8, 4), CompressedASCII)
+	zpl := "^XA^FT5,10" + field + "^FS^XZ\n^XA^FO0,0^FR" + field + "^FO0,0^FR^GB8,1,1^FS^XZ"
+	labels, err := RenderLabels(zpl)
+	if err != nil || len(labels) != 2 {
+		t.Fatalf("RenderLabels failed: got %d labels (%v), want 2", len(labels), err)
+	}
+	if labels[0].Rect != image.Rect(0, 0, 13, 10) || labels[1].Rect != image.Rect(0, 0, 8, 4) {
+		t.Fatalf("RenderLabels failed: got %v and %v", labels[0].Rect, labels[1].Rect)
+	}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 8; x++ {
+			checker := (x+y)%2 == 0
+			if labels[0].BlackAt(x+5, y+6) != checker || labels[1].BlackAt(x, y) != (checker != (y == 0)) {
+				t.Fatalf("RenderLabels failed: wrong dot at %d,%d\n%s\n\n%s", x, y, dotsString(labels[0]), dotsString(labels[1]))
+			}
+		}
+	}
+}
+
+func Test_RenderLabelsError(t *testing.T) {
+	zpl := "^XA^FO0,0^GB4,4,1^FS^FO0,0^GFA,4,4,2,\nFFFF\nFFZF^FS^XZ"
+	_, err := RenderLabels(zpl)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Offset != strings.Index(zpl, "Z") || decodeErr.Field != 0 {
+		t.Fatalf("RenderLabels failed: got %v, want an invalid character at %d", err, strings.Index(zpl, "Z"))
+	}
+
+	if _, err := RenderLabels("^XA^PW70000^LL1^XZ"); err == nil {
+		t.Fatalf("RenderLabels failed: got no error for a label of 70000 dots")
+	}
+	if _, err := RenderLabels("^XA^PW60000^LL60000^XZ"); err == nil {
+		t.Fatalf("RenderLabels failed: got no error for a label of 60000x60000 dots")
+	}
+	if _, err := RenderLabelsContext(context.Background(), "^XA^PW100^LL100^XZ", Limits{MaxPixels: 1000}); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("RenderLabelsContext failed: got %v, want a limit error", err)
+	}
+}
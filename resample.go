@@ -0,0 +1,139 @@
+package zplgfa
+
+import (
+	"image"
+	"math"
+	"sort"
+
+	"github.com/nfnt/resize"
+)
+
+// Resampling selects how images are scaled by ConvertOptions.Scale.
+type Resampling int
+
+const (
+	// ResampleSmooth interpolates with a Mitchell-Netravali filter, which suits photos
+	ResampleSmooth Resampling = iota
+	// ResampleBilevel scales the thresholded bitmap and keeps bar and space widths proportional, which suits barcodes and text
+	ResampleBilevel
+)
+
+// ResizeBilevel thresholds img and scales it to width x height dots without blurring edges.
+// Integer upscaling repeats dots, all other factors scale the runs of equally colored dots in
+// every row and column so that their widths stay proportional and no run shrinks below one dot.
+// Lines with more runs than the target has dots are sampled at the nearest dot.
+func ResizeBilevel(img image.Image, width, height int) *image.Gray {
+	if width <= 0 || height <= 0 || img.Bounds().Empty() {
+		return image.NewGray(image.Rect(0, 0, max(width, 0), max(height, 0)))
+	}
+
+	raw, bytesPerRow := packImage(img)
+	bitmap := unpackDots(raw, bytesPerRow, img.Bounds().Dx(), img.Bounds().Dy()).scale(width, height)
+
+	gray := image.NewGray(image.Rect(0, 0, width, height))
+	for i, dot := range bitmap.dots {
+		if dot == 0 {
+			gray.Pix[i] = 0xff
+		}
+	}
+	return gray
+}
+
+// scaledSize returns the size of a width x height image scaled by factor, at least one dot in each direction.
+func scaledSize(width, height int, factor float64) (int, int) {
+	return max(int(math.Round(float64(width)*factor)), 1), max(int(math.Round(float64(height)*factor)), 1)
+}
+
+// resizeSmooth scales img by factor with a Mitchell-Netravali filter.
+func resizeSmooth(img image.Image, factor float64) image.Image {
+	width, height := scaledSize(img.Bounds().Dx(), img.Bounds().Dy(), factor)
+	return resize.Resize(uint(width), uint(height), img, resize.MitchellNetravali)
+}
+
+// scale resizes the bitmap, first every row and then every column.
+func (b dotBitmap) scale(width, height int) dotBitmap {
+	rows := dotBitmap{dots: make([]byte, 0, width*b.height), width: width, height: b.height}
+	for y := 0; y < b.height; y++ {
+		rows.dots = append(rows.dots, scaleLine(b.dots[y*b.width:(y+1)*b.width], width)...)
+	}
+
+	out := dotBitmap{dots: make([]byte, width*height), width: width, height: height}
+	column := make([]byte, b.height)
+	for x := 0; x < width; x++ {
+		for y := range column {
+			column[y] = rows.dots[y*width+x]
+		}
+		for y, dot := range scaleLine(column, height) {
+			out.dots[y*width+x] = dot
+		}
+	}
+	return out
+}
+
+// scaleLine resizes a line of dots to length dots. Runs of equally colored dots are scaled
+// proportionally with the rounding distributed by the largest remainder, and every run keeps at
+// least one dot. Lines with more runs than dots are sampled proportionally at the nearest dot.
+func scaleLine(src []byte, length int) []byte {
+	dst := make([]byte, length)
+	if length%len(src) == 0 {
+		factor := length / len(src)
+		for i := range dst {
+			dst[i] = src[i/factor]
+		}
+		return dst
+	}
+
+	var ends []int
+	for i := 1; i <= len(src); i++ {
+		if i == len(src) || src[i] != src[i-1] {
+			ends = append(ends, i)
+		}
+	}
+	if len(ends) > length {
+		// not every run fits, sample the dot under the center of every target dot instead of cutting off the end
+		for i := range dst {
+			dst[i] = src[(2*i+1)*len(src)/(2*length)]
+		}
+		return dst
+	}
+
+	sizes := make([]int, len(ends))
+	remainders := make([]float64, len(ends))
+	total, start := 0, 0
+	for i, end := range ends {
+		exact := float64((end-start)*length) / float64(len(src))
+		sizes[i] = max(int(exact), 1)
+		remainders[i] = exact - float64(sizes[i])
+		total += sizes[i]
+		start = end
+	}
+
+	order := make([]int, len(ends))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return remainders[order[a]] > remainders[order[b]] })
+	for i := 0; total < length; i = (i + 1) % len(order) {
+		sizes[order[i]]++
+		total++
+	}
+	for shrunk := true; total > length && shrunk; {
+		shrunk = false
+		for i := len(order) - 1; i >= 0 && total > length; i-- {
+			if sizes[order[i]] > 1 {
+				sizes[order[i]]--
+				total--
+				shrunk = true
+			}
+		}
+	}
+
+	pos := 0
+	for i, end := range ends {
+		for j := 0; j < sizes[i] && pos < length; j++ {
+			dst[pos] = src[end-1]
+			pos++
+		}
+	}
+	return dst
+}
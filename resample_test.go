@@ -0,0 +1,92 @@
+package zplgfa
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func Test_ScaleLine(t *testing.T) {
+	line := func(s string) []byte {
+		return dotsFromString(s).dots
+	}
+
+	tests := []struct {
+		src    string
+		length int
+		want   string
+	}{
+		{"#.##", 8, "##..####"},
+		{"##..####", 4, "#.##"},
+		{"#.#..##...", 6, "#.#.#."},
+		{"#.#..##...", 15, "##..#...###...."},
+		{"####.#.####", 7, "##.#.##"},
+		// lines with more runs than dots are sampled, keeping the content at their end
+		{"#.#.#", 3, "###"},
+		{"#.#.#.....####", 5, ".#..#"},
+	}
+	for _, tt := range tests {
+		got := dotBitmap{dots: scaleLine(line(tt.src), tt.length), width: tt.length, height: 1}.String()
+		if got != tt.want {
+			t.Fatalf("scaleLine(%s, %d) failed: got %s, want %s", tt.src, tt.length, got, tt.want)
+		}
+	}
+}
+
+func Test_ResizeBilevel(t *testing.T) {
+	// A barcode with 1 and 2 dot wide bars and spaces.
+	bars := "#.##.#..##.#"
+	src := image.NewGray(image.Rect(0, 0, len(bars), 4))
+	for y := 0; y < 4; y++ {
+		for x, c := range bars {
+			if c == '.' {
+				src.SetGray(x, y, color.Gray{0xff})
+			}
+		}
+	}
+
+	for _, width := range []int{9, 10, 12, 17, 24, 30} {
+		img := ResizeBilevel(src, width, 3)
+		if img.Bounds() != image.Rect(0, 0, width, 3) {
+			t.Fatalf("ResizeBilevel failed: got bounds %v", img.Bounds())
+		}
+
+		var rows []string
+		for y := 0; y < 3; y++ {
+			var row strings.Builder
+			runs := 1
+			for x := 0; x < width; x++ {
+				if x > 0 && img.GrayAt(x, y) != img.GrayAt(x-1, y) {
+					runs++
+				}
+				row.WriteByte(".#"[1-img.GrayAt(x, y).Y/0xff])
+			}
+			if runs != 9 {
+				t.Fatalf("ResizeBilevel failed: got %d runs in %s at width %d, want 9", runs, row.String(), width)
+			}
+			rows = append(rows, row.String())
+		}
+		if rows[0] != rows[1] || rows[1] != rows[2] {
+			t.Fatalf("ResizeBilevel failed: rows differ at width %d: %v", width, rows)
+		}
+	}
+}
+
+func Test_ConvertWithBilevelScale(t *testing.T) {
+	img := checkerImage(8, 2)
+
+	got, err := ConvertToGraphicFieldWithOptions(img, ConvertOptions{GraphicType: ASCII, Scale: 2, Resampling: ResampleBilevel})
+	if err != nil {
+		t.Fatalf("ConvertToGraphicFieldWithOptions failed: %v", err)
+	}
+	want, _ := ConvertToGraphicFieldWithError(ResizeBilevel(img, 16, 4), ASCII)
+	if got != want || !strings.HasPrefix(got, "^GFA,20,8,2,\n") {
+		t.Fatalf("ConvertToGraphicFieldWithOptions failed: got %q, want %q", got, want)
+	}
+
+	smooth, err := ConvertToGraphicFieldWithOptions(img, ConvertOptions{GraphicType: ASCII, Scale: 0.5})
+	if err != nil || !strings.HasPrefix(smooth, "^GFA,3,1,1,\n") {
+		t.Fatalf("ConvertToGraphicFieldWithOptions failed: got %q, %v", smooth, err)
+	}
+}
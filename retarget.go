@@ -0,0 +1,148 @@
+package zplgfa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// retargetParams lists the parameters measured in dots of the format commands RetargetZPL scales, by their index.
+// The module width of ^BY stays between 1 and 10 dots, which is all printers accept.
+var retargetParams = map[string][]int{
+	"FO": {0, 1}, "FT": {0, 1}, "LH": {0, 1}, "LS": {0}, "LT": {0}, "PW": {0}, "LL": {0}, "ML": {0},
+	"GB": {0, 1, 2}, "GC": {0, 1}, "GD": {0, 1, 2}, "GE": {0, 1, 2},
+	"A": {1, 2}, "CF": {1, 2}, "FB": {0, 2, 4}, "TB": {1, 2}, "BY": {0, 2}, "BC": {1},
+}
+
+// RetargetZPL rewrites a ZPL document made for a printer with fromDPI dots per inch, like 203, 300 or 600, for
+// a printer with toDPI, so that it prints at the same size. Positions and sizes in dots are scaled: ^FO, ^FT, ^LH,
+// ^LS, ^LT, ^PW, ^LL and ^ML, the boxes, circles, ellipses and lines of ^GB, ^GC, ^GE and ^GD, the font sizes of ^A and
+// ^CF, ^FB and ^TB, the module width and height of ^BY and the height of ^BC. Every ^GF field is decoded, scaled
+// with ResizeBilevel, which keeps bars and text sharp, and encoded again with its encoding. Sizes that are not
+// zero stay at least one dot, all other commands are kept as they are. A field that can't be decoded stops the
+// rewrite with its *DecodeError.
+func RetargetZPL(zpl string, fromDPI, toDPI int) (string, error) {
+	return RetargetZPLContext(context.Background(), zpl, fromDPI, toDPI, Limits{})
+}
+
+// RetargetZPLContext is RetargetZPL with limits for decoding and scaling the fields and for the size of the
+// rewritten document, stopping with the error of ctx once ctx is done.
+func RetargetZPLContext(ctx context.Context, zpl string, fromDPI, toDPI int, limits Limits) (string, error) {
+	for _, dpi := range []int{fromDPI, toDPI} {
+		if dpi <= 0 {
+			return "", fmt.Errorf("invalid resolution of %d dpi", dpi)
+		}
+	}
+	if fromDPI == toDPI {
+		return zpl, nil
+	}
+	factor := float64(toDPI) / float64(fromDPI)
+
+	buffers := getSharedBuffers()
+	defer sharedBuffers.Put(buffers)
+
+	var out strings.Builder
+	out.Grow(len(zpl))
+	last, fields := 0, 0
+	for _, command := range ParseCommands(zpl) {
+		if err := canceled(ctx); err != nil {
+			return "", err
+		}
+		if command.Control {
+			continue
+		}
+		text := zpl[command.Offset:command.End]
+		var rewritten string
+		switch indices, ok := retargetParams[command.Name]; {
+		case command.Is("^GF"):
+			field, err := retargetGraphicField(ctx, buffers, command, factor, limits)
+			if err != nil {
+				var decodeErr *DecodeError
+				if errors.As(err, &decodeErr) {
+					decodeErr.Offset += command.Offset
+					decodeErr.Field = fields
+				}
+				return "", err
+			}
+			fields++
+			rewritten = field
+		case ok:
+			// the name keeps its letter case, only the parameters are rewritten
+			rewritten = text[:1+len(command.Name)] + scaleParams(command, indices, factor)
+		default:
+			continue
+		}
+		out.WriteString(zpl[last:command.Offset])
+		out.WriteString(rewritten)
+		last = command.End
+	}
+	out.WriteString(zpl[last:])
+
+	if err := limits.checkOutput(out.Len()); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// scaleParams returns the parameters of command with the numbers at indices scaled by factor.
+// Empty parameters keep their default.
+func scaleParams(command Command, indices []int, factor float64) string {
+	parts := strings.Split(strings.TrimRight(command.Params, asciiSpace), ",")
+	values := map[int]int{}
+	for _, i := range indices {
+		if i < len(parts) {
+			if number, err := strconv.Atoi(strings.TrimSpace(parts[i])); err == nil {
+				values[i] = scaleDots(number, factor)
+			}
+		}
+	}
+	if width, ok := values[0]; ok && command.Name == "BY" {
+		values[0] = min(max(width, 1), 10)
+	}
+	return replaceParams(command.Params, values)
+}
+
+// replaceParams returns the comma separated params with the parameters at the indices of values replaced
+// by their numbers, adding missing parameters. Whitespace after the last parameter is kept.
+func replaceParams(params string, values map[int]int) string {
+	body := strings.TrimRight(params, asciiSpace)
+	parts := strings.Split(body, ",")
+	for i, number := range values {
+		for len(parts) <= i {
+			parts = append(parts, "")
+		}
+		parts[i] = strconv.Itoa(number)
+	}
+	return strings.Join(parts, ",") + params[len(body):]
+}
+
+// scaleDots scales a number of dots by factor, numbers that are not zero stay at least one dot.
+func scaleDots(dots int, factor float64) int {
+	scaled := int(math.Round(float64(dots) * factor))
+	switch {
+	case scaled == 0 && dots > 0:
+		return 1
+	case scaled == 0 && dots < 0:
+		return -1
+	}
+	return scaled
+}
+
+// retargetGraphicField returns a ^GF command with its dots scaled by factor and encoded like before.
+func retargetGraphicField(ctx context.Context, buffers *encodeBuffers, command Command, factor float64, limits Limits) (string, error) {
+	img, err := ConvertGraphicFieldToImageContext(ctx, command.String(), limits)
+	if err != nil {
+		return "", err
+	}
+	width, height := scaledSize(img.Rect.Dx(), img.Rect.Dy(), factor)
+	if err := checkGraphicSize(width, height); err != nil {
+		return "", fmt.Errorf("scaled graphic field too large: %w", err)
+	}
+	if err := limits.checkPixels(width, height); err != nil {
+		return "", err
+	}
+	return buffers.reencodeGraphicField(ctx, command, ResizeBilevel(img, width, height), limits)
+}
@@ -0,0 +1,94 @@
+package zplgfa
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_RetargetZPL(t *testing.T) {
+	tests := []struct {
+		zpl      string
+		from, to int
+		want     string
+	}{
+		{"^XA^PW406^LL203^LH10,5^XZ", 203, 406, "^XA^PW812^LL406^LH20,10^XZ"},
+		{"^XA\n^FO30,60^A0N,30,0^FDHello^FS\n^XZ\n", 300, 203, "^XA\n^FO20,41^A0N,20,0^FDHello^FS\n^XZ\n"},
+		{"^fo10,20,1^gb100,3,1,W,2^FS", 203, 600, "^fo30,59,1^gb296,9,3,W,2^FS"},
+		{"^BY2,3.0,50^BCN,100,Y,N^FD123^FS", 203, 600, "^BY6,3.0,148^BCN,296,Y,N^FD123^FS"},
+		{"^BY4,,^CF0,60,^FB400,2,10,C,0", 600, 203, "^BY1,,^CF0,20,^FB135,2,3,C,0"},
+		// sizes that are not zero stay at least one dot, data and control commands are kept
+		{"^GB1,1,1^FS~SD15^FD10,20^FS", 600, 203, "^GB1,1,1^FS~SD15^FD10,20^FS"},
+		{"^XA^FO10,10^FS^XZ", 300, 300, "^XA^FO10,10^FS^XZ"},
+	}
+	for _, test := range tests {
+		got, err := RetargetZPL(test.zpl, test.from, test.to)
+		if err != nil || got != test.want {
+			t.Fatalf("RetargetZPL failed for %q: got %q (%v), want %q", test.zpl, got, err, test.want)
+		}
+	}
+}
+
+func Test_RetargetZPLGraphic(t *testing.T) {
+	img := repeatedRowsImage(37, 21)
+	for _, graphicType := range []GraphicType{ASCII, CompressedASCII, Binary, Z64, B64} {
+		zpl := "^XA^FO10,10" + ConvertToGraphicField(img, graphicType) + "^FS^XZ"
+		got, err := RetargetZPL(zpl, 203, 300)
+		if err != nil {
+			t.Fatalf("RetargetZPL failed for %s: %v", graphicType, err)
+		}
+		fields := ScanGraphicFields(got)
+		if len(fields) != 1 || fields[0].Err != nil || fields[0].Encoding != graphicType {
+			t.Fatalf("RetargetZPL failed for %s: got %+v", graphicType, fields)
+		}
+		field, _ := ConvertGraphicFieldToImage(ConvertToGraphicField(img, graphicType))
+		width, height := scaledSize(field.Rect.Dx(), field.Rect.Dy(), 300.0/203)
+		want := ConvertToMonochrome(ResizeBilevel(field, width, height))
+		if fields[0].Image.Rect.Dy() != height || !bytes.Equal(fields[0].Image.Pix, want.Pix) {
+			t.Fatalf("RetargetZPL failed for %s: the field is not scaled with ResizeBilevel", graphicType)
+		}
+		if !strings.HasPrefix(got, "^XA^FO15,15^GF") || !strings.HasSuffix(got, "^FS^XZ") {
+			t.Fatalf("RetargetZPL failed for %s: got %q", graphicType, got)
+		}
+	}
+}
+
+func Test_RetargetZPLRenders(t *testing.T) {
+	// doubling the resolution draws every dot as 2x2 dots
+	zpl := "^XA^PW40^LL30^LH1,2\n^FO0,0^GB12,8,2^FS\n^FO3,12^FR^GB20,4,4^FS\n^FO14,0" + ConvertToGraphicField(checkerImage(13, 9), Z64) + "^FS\n^XZ"
+	before, err := RenderLabels(zpl)
+	if err != nil || len(before) != 1 {
+		t.Fatalf("RenderLabels failed: %v", err)
+	}
+	retargeted, err := RetargetZPL(zpl, 203, 406)
+	if err != nil {
+		t.Fatalf("RetargetZPL failed: %v", err)
+	}
+	after, err := RenderLabels(retargeted)
+	if err != nil || len(after) != 1 {
+		t.Fatalf("RenderLabels failed: %v", err)
+	}
+	want := ConvertToMonochrome(ResizeBilevel(before[0], 2*before[0].Rect.Dx(), 2*before[0].Rect.Dy()))
+	if got := after[0]; dotsString(got) != dotsString(want) {
+		t.Fatalf("RetargetZPL failed: got\n%s\nwant\n%s", dotsString(got), dotsString(want))
+	}
+}
+
+func Test_RetargetZPLError(t *testing.T) {
+	if _, err := RetargetZPL("^XA^XZ", 0, 300); err == nil {
+		t.Fatalf("RetargetZPL failed: no error for 0 dpi")
+	}
+	zpl := "^XA^FO0,0" + ConvertToGraphicField(checkerImage(8, 2), ASCII) + "^FS^FO0,0^GFA,4,4,2,\nZZZZ^FS^XZ"
+	_, err := RetargetZPL(zpl, 203, 300)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Field != 1 || decodeErr.Offset < strings.LastIndex(zpl, "^GF") {
+		t.Fatalf("RetargetZPL failed: got %v, want a DecodeError of the second field", err)
+	}
+	zpl = "^XA^FO0,0" + ConvertToGraphicField(checkerImage(8, 2), ASCII) + "^FS^XZ"
+	var limitErr *LimitError
+	if _, err := RetargetZPLContext(context.Background(), zpl, 203, 600, Limits{MaxPixels: 40}); !errors.As(err, &limitErr) {
+		t.Fatalf("RetargetZPL failed: no error above the pixel limit")
+	}
+}
@@ -0,0 +1,105 @@
+package zplgfa
+
+import (
+	"context"
+	"errors"
+	"image"
+	"strconv"
+	"strings"
+)
+
+// GraphicFieldInfo describes a ^GF graphic field of a ZPL document.
+type GraphicFieldInfo struct {
+	// Index counts the ^GF fields of the document from 0, Label counts its ^XA formats from 0.
+	Index, Label int
+	// Offset and End are the byte offsets of the ^GF command in the document.
+	Offset, End int
+	// Origin is the position set by the ^FO or ^FT command of the field, named by OriginCommand.
+	// OriginCommand is empty if the field has neither. For ^FT, Origin is the bottom left corner of the graphic.
+	Origin        image.Point
+	OriginCommand string
+	// Encoding is the encoding of the graphic data.
+	Encoding GraphicType
+	// DeclaredBytes is the binary byte count of the command, or -1 if it is no number.
+	// DeclaredTotal is its total number of graphic bytes and BytesPerRow its number of bytes per row.
+	DeclaredBytes, DeclaredTotal, BytesPerRow int
+	// DataBytes is the actual length of the graphic data in the document.
+	DataBytes int
+	// Width and Height are the declared size of the graphic in dots.
+	Width, Height int
+	// Image is the decoded graphic, or nil if it could not be decoded.
+	Image *Monochrome
+	// Err is the error of decoding the field, usually a *DecodeError or *LimitError.
+	// Its offsets are offsets in the document.
+	Err error
+}
+
+// ScanGraphicFields decodes every ^GF graphic field of a ZPL document. A field that can't be decoded
+// doesn't stop the scan, its Err is set instead.
+func ScanGraphicFields(zpl string) []GraphicFieldInfo {
+	fields, _ := ScanGraphicFieldsContext(context.Background(), zpl, Limits{})
+	return fields
+}
+
+// ScanGraphicFieldsContext is ScanGraphicFields with limits for every field, stopping with the error of ctx
+// once ctx is done. The fields scanned so far are returned with the error.
+func ScanGraphicFieldsContext(ctx context.Context, zpl string, limits Limits) ([]GraphicFieldInfo, error) {
+	var fields []GraphicFieldInfo
+	label := -1
+	var origin image.Point
+	originCommand := ""
+
+	for _, command := range ParseCommands(zpl) {
+		switch {
+		case command.Is("^XA"):
+			label++
+			origin, originCommand = image.Point{}, ""
+		case command.Is("^FO"), command.Is("^FT"):
+			origin, originCommand = parsePoint(command.Params), command.Name
+		case command.Is("^FS"):
+			origin, originCommand = image.Point{}, ""
+		case command.Is("^GF"):
+			if err := canceled(ctx); err != nil {
+				return fields, err
+			}
+			info := GraphicFieldInfo{
+				Index:         len(fields),
+				Label:         max(label, 0),
+				Offset:        command.Offset,
+				End:           command.End,
+				Origin:        origin,
+				OriginCommand: originCommand,
+				DeclaredBytes: -1,
+			}
+			text := zpl[command.Offset:command.End]
+			if field, err := parseGraphicField(text); err == nil {
+				info.Encoding = field.encoding()
+				info.DeclaredBytes, info.DeclaredTotal, info.BytesPerRow = field.declared, field.total, field.bytesPerRow
+				info.DataBytes = len(field.data)
+				info.Width, info.Height, _ = graphicFieldSize(field.total, field.bytesPerRow)
+			}
+			info.Image, info.Err = ConvertGraphicFieldToImageContext(ctx, text, limits)
+			if errors.Is(info.Err, context.Canceled) || errors.Is(info.Err, context.DeadlineExceeded) {
+				return fields, info.Err
+			}
+			var decodeErr *DecodeError
+			if errors.As(info.Err, &decodeErr) {
+				decodeErr.Offset += command.Offset
+				decodeErr.Field = info.Index
+			}
+			fields = append(fields, info)
+		}
+	}
+	return fields, nil
+}
+
+// parsePoint returns the x and y parameters of a position command like ^FO, missing or invalid ones are zero.
+func parsePoint(params string) image.Point {
+	parts := strings.Split(params, ",")
+	var p image.Point
+	p.X, _ = strconv.Atoi(strings.TrimSpace(parts[0]))
+	if len(parts) > 1 {
+		p.Y, _ = strconv.Atoi(strings.TrimSpace(parts[1]))
+	}
+	return p
+}
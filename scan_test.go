@@ -0,0 +1,54 @@
+package zplgfa
+
+import (
+	"errors"
+	"image"
+	"strings"
+	"testing"
+)
+
+func Test_ScanGraphicFields(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 64, 2))
+	for x := 0; x < 8; x++ {
+		img.Pix[x] = 0xff
+	}
+	var fields []string
+	for _, graphicType := range []GraphicType{ASCII, CompressedASCII, Binary, Z64, B64} {
+		fields = append(fields, ConvertToGraphicField(img, graphicType))
+	}
+	broken := "^GFA,4,4,2,\nFFFF\nFFZF"
+	zpl := "^XA^FO10,20" + fields[0] + "^FS^FT5,6" + fields[1] + "^FS" + fields[2] + "^FS^XZ\n^XA^FO1,2" + fields[3] +
+		"^FS^FO3,4" + fields[4] + "^FS^FO0,0" + broken + "^FS^XZ"
+
+	scanned := ScanGraphicFields(zpl)
+	if len(scanned) != 6 {
+		t.Fatalf("ScanGraphicFields failed: got %d fields, want 6", len(scanned))
+	}
+	origins := []image.Point{{10, 20}, {5, 6}, {0, 0}, {1, 2}, {3, 4}, {0, 0}}
+	commands := []string{"FO", "FT", "", "FO", "FO", "FO"}
+	for i, field := range scanned[:5] {
+		if field.Err != nil {
+			t.Fatalf("ScanGraphicFields failed for field %d: %v", i, field.Err)
+		}
+		if field.Index != i || field.Label != i/3 || field.Origin != origins[i] || field.OriginCommand != commands[i] {
+			t.Fatalf("ScanGraphicFields failed for field %d: got %+v", i, field)
+		}
+		if field.Encoding != GraphicType([]GraphicType{ASCII, CompressedASCII, Binary, Z64, B64}[i]) {
+			t.Fatalf("ScanGraphicFields failed for field %d: got encoding %s", i, field.Encoding)
+		}
+		if field.Width != 64 || field.Height != 2 || field.DeclaredTotal != 16 || field.BytesPerRow != 8 ||
+			strings.TrimSpace(zpl[field.Offset:field.End]) != strings.TrimSpace(fields[i]) {
+			t.Fatalf("ScanGraphicFields failed for field %d: got %+v", i, field)
+		}
+		assertGrayImageEqual(t, field.Image.Gray(), img)
+	}
+
+	last := scanned[5]
+	var decodeErr *DecodeError
+	if !errors.As(last.Err, &decodeErr) || decodeErr.Field != 5 || decodeErr.Offset != strings.Index(zpl, "FFZF")+2 {
+		t.Fatalf("ScanGraphicFields failed: got %v for the broken field", last.Err)
+	}
+	if last.DataBytes != len("FFFF\nFFZF") || last.Image != nil {
+		t.Fatalf("ScanGraphicFields failed: got %d data bytes for the broken field", last.DataBytes)
+	}
+}
@@ -0,0 +1,464 @@
+package zplgfa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+func init() {
+	image.RegisterFormat("tiff", "II*\x00", DecodeTIFF, DecodeTIFFConfig)
+	image.RegisterFormat("tiff", "MM\x00*", DecodeTIFF, DecodeTIFFConfig)
+}
+
+// TIFF tags used by the decoder.
+const (
+	tiffImageWidth      = 256
+	tiffImageLength     = 257
+	tiffBitsPerSample   = 258
+	tiffCompression     = 259
+	tiffPhotometric     = 262
+	tiffFillOrder       = 266
+	tiffStripOffsets    = 273
+	tiffSamplesPerPixel = 277
+	tiffRowsPerStrip    = 278
+	tiffStripByteCounts = 279
+	tiffPlanarConfig    = 284
+	tiffT4Options       = 292
+	tiffColorMap        = 320
+	tiffTileWidth       = 322
+	tiffExtraSamples    = 338
+)
+
+// TIFF compression schemes supported by the decoder.
+const (
+	tiffCompressionNone     = 1
+	tiffCompressionCCITTRLE = 2
+	tiffCompressionGroup3   = 3
+	tiffCompressionGroup4   = 4
+	tiffCompressionPackBits = 32773
+)
+
+// TIFF photometric interpretations supported by the decoder.
+const (
+	tiffWhiteIsZero = 0
+	tiffBlackIsZero = 1
+	tiffRGB         = 2
+	tiffPalette     = 3
+)
+
+// DecodeTIFF reads the first page of a baseline, CCITT Group 3 or Group 4 TIFF file.
+func DecodeTIFF(r io.Reader) (image.Image, error) {
+	pages, err := decodeTIFF(r, 1)
+	if err != nil {
+		return nil, err
+	}
+	return pages[0], nil
+}
+
+// DecodeTIFFPages reads every page of a baseline, CCITT Group 3 or Group 4 TIFF file.
+func DecodeTIFFPages(r io.Reader) ([]image.Image, error) {
+	return decodeTIFF(r, 0)
+}
+
+// DecodeTIFFConfig returns the dimensions of the first page of a TIFF file.
+func DecodeTIFFConfig(r io.Reader) (image.Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	file, offset, err := newTIFFFile(data)
+	if err != nil {
+		return image.Config{}, err
+	}
+	ifd, _, err := file.readIFD(offset)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	config := image.Config{
+		ColorModel: color.GrayModel,
+		Width:      int(ifd.value(tiffImageWidth, 0)),
+		Height:     int(ifd.value(tiffImageLength, 0)),
+	}
+	switch ifd.value(tiffPhotometric, tiffWhiteIsZero) {
+	case tiffRGB:
+		config.ColorModel = color.NRGBAModel
+	case tiffPalette:
+		config.ColorModel = tiffColorPalette(ifd, int(ifd.value(tiffBitsPerSample, 1)))
+	}
+	return config, nil
+}
+
+// isTIFF reports whether data starts with a TIFF header.
+func isTIFF(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*"))
+}
+
+// decodeTIFF decodes up to maxPages pages, or all pages if maxPages is 0.
+func decodeTIFF(r io.Reader, maxPages int) ([]image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	file, offset, err := newTIFFFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []image.Image
+	visited := map[uint32]bool{}
+	for offset != 0 && (maxPages == 0 || len(pages) < maxPages) {
+		if visited[offset] {
+			return nil, fmt.Errorf("TIFF page chain contains a loop")
+		}
+		visited[offset] = true
+
+		ifd, next, err := file.readIFD(offset)
+		if err != nil {
+			return nil, err
+		}
+		page, err := file.decodePage(ifd)
+		if err != nil {
+			return nil, fmt.Errorf("TIFF page %d: %w", len(pages)+1, err)
+		}
+		pages = append(pages, page)
+		offset = next
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("TIFF file contains no pages")
+	}
+	return pages, nil
+}
+
+type tiffFile struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// tiffIFD holds the values of an image file directory by tag.
+type tiffIFD map[uint16][]uint32
+
+func (ifd tiffIFD) value(tag uint16, fallback uint32) uint32 {
+	if values := ifd[tag]; len(values) > 0 {
+		return values[0]
+	}
+	return fallback
+}
+
+func newTIFFFile(data []byte) (*tiffFile, uint32, error) {
+	if len(data) < 8 || !isTIFF(data) {
+		return nil, 0, fmt.Errorf("invalid TIFF header")
+	}
+	file := &tiffFile{data: data, order: binary.LittleEndian}
+	if data[0] == 'M' {
+		file.order = binary.BigEndian
+	}
+	return file, file.order.Uint32(data[4:]), nil
+}
+
+// readIFD reads the image file directory at offset and returns it with the offset of the next one.
+func (t *tiffFile) readIFD(offset uint32) (tiffIFD, uint32, error) {
+	const entrySize = 12
+	if uint64(offset)+2 > uint64(len(t.data)) {
+		return nil, 0, fmt.Errorf("invalid TIFF directory offset %d", offset)
+	}
+	count := int(t.order.Uint16(t.data[offset:]))
+	start := int(offset) + 2
+	if start+count*entrySize+4 > len(t.data) {
+		return nil, 0, fmt.Errorf("TIFF directory too short")
+	}
+
+	ifd := tiffIFD{}
+	for i := 0; i < count; i++ {
+		entry := t.data[start+i*entrySize : start+(i+1)*entrySize]
+		tag := t.order.Uint16(entry)
+		values, err := t.readValues(entry)
+		if err != nil {
+			return nil, 0, fmt.Errorf("TIFF tag %d: %w", tag, err)
+		}
+		if values != nil {
+			ifd[tag] = values
+		}
+	}
+	return ifd, t.order.Uint32(t.data[start+count*entrySize:]), nil
+}
+
+// readValues returns the integer values of a directory entry. Entries of
+// other types are skipped and return nil.
+func (t *tiffFile) readValues(entry []byte) ([]uint32, error) {
+	var size int
+	switch t.order.Uint16(entry[2:]) {
+	case 1, 2, 6, 7: // BYTE, ASCII, SBYTE, UNDEFINED
+		size = 1
+	case 3, 8: // SHORT, SSHORT
+		size = 2
+	case 4, 9: // LONG, SLONG
+		size = 4
+	default:
+		return nil, nil
+	}
+
+	count := uint64(t.order.Uint32(entry[4:]))
+	raw := entry[8:12]
+	if count*uint64(size) > 4 {
+		offset := uint64(t.order.Uint32(entry[8:]))
+		if offset+count*uint64(size) > uint64(len(t.data)) {
+			return nil, fmt.Errorf("value out of range")
+		}
+		raw = t.data[offset : offset+count*uint64(size)]
+	}
+
+	values := make([]uint32, count)
+	for i := range values {
+		switch size {
+		case 1:
+			values[i] = uint32(raw[i])
+		case 2:
+			values[i] = uint32(t.order.Uint16(raw[2*i:]))
+		case 4:
+			values[i] = t.order.Uint32(raw[4*i:])
+		}
+	}
+	return values, nil
+}
+
+// decodePage decodes the strips of a page and converts them to an image.
+func (t *tiffFile) decodePage(ifd tiffIFD) (image.Image, error) {
+	width := int(ifd.value(tiffImageWidth, 0))
+	height := int(ifd.value(tiffImageLength, 0))
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid dimensions %dx%d", width, height)
+	}
+	if err := checkImageSize(width, height); err != nil {
+		return nil, err
+	}
+	if _, tiled := ifd[tiffTileWidth]; tiled {
+		return nil, fmt.Errorf("tiled images are not supported")
+	}
+
+	bitsPerSample := int(ifd.value(tiffBitsPerSample, 1))
+	samplesPerPixel := int(ifd.value(tiffSamplesPerPixel, 1))
+	for _, bps := range ifd[tiffBitsPerSample] {
+		if int(bps) != bitsPerSample {
+			return nil, fmt.Errorf("mixed bits per sample are not supported")
+		}
+	}
+	switch bitsPerSample {
+	case 1, 2, 4, 8, 16:
+	default:
+		return nil, fmt.Errorf("unsupported bits per sample %d", bitsPerSample)
+	}
+	if samplesPerPixel < 1 || samplesPerPixel > 8 {
+		return nil, fmt.Errorf("unsupported samples per pixel %d", samplesPerPixel)
+	}
+	if samplesPerPixel > 1 && ifd.value(tiffPlanarConfig, 1) != 1 {
+		return nil, fmt.Errorf("planar images are not supported")
+	}
+
+	compression := ifd.value(tiffCompression, tiffCompressionNone)
+	stride := (width*bitsPerSample*samplesPerPixel + 7) / 8
+	rowsPerStrip := int(ifd.value(tiffRowsPerStrip, uint32(height)))
+	if rowsPerStrip <= 0 || rowsPerStrip > height {
+		rowsPerStrip = height
+	}
+	offsets, counts := ifd[tiffStripOffsets], ifd[tiffStripByteCounts]
+	if len(offsets) == 0 || len(offsets) != len(counts) {
+		return nil, fmt.Errorf("invalid strip offsets")
+	}
+
+	// uncompressed strips hold all bytes, PackBits repeats a byte at most 128 times for 2 bytes
+	available := int64(0)
+	for _, count := range counts {
+		available += int64(count)
+	}
+	switch compression {
+	case tiffCompressionNone:
+		if available < int64(stride*height) {
+			return nil, fmt.Errorf("image data too short")
+		}
+	case tiffCompressionPackBits:
+		if available*64 < int64(stride*height) {
+			return nil, fmt.Errorf("image data too short")
+		}
+	}
+
+	pixels := make([]byte, 0, stride*height)
+	for i, offset := range offsets {
+		rows := height - i*rowsPerStrip
+		if rows <= 0 {
+			break
+		}
+		if rows > rowsPerStrip {
+			rows = rowsPerStrip
+		}
+		if uint64(offset)+uint64(counts[i]) > uint64(len(t.data)) {
+			return nil, fmt.Errorf("strip %d out of range", i)
+		}
+		strip := t.data[offset : offset+counts[i]]
+
+		var decoded []byte
+		var err error
+		switch compression {
+		case tiffCompressionNone:
+			decoded = strip
+		case tiffCompressionPackBits:
+			decoded, err = unpackBits(strip, stride*rows)
+		case tiffCompressionCCITTRLE, tiffCompressionGroup3, tiffCompressionGroup4:
+			if bitsPerSample != 1 || samplesPerPixel != 1 {
+				return nil, fmt.Errorf("CCITT compression requires bilevel images")
+			}
+			decoded, err = decodeCCITT(strip, width, rows, compression, ifd.value(tiffT4Options, 0), ifd.value(tiffFillOrder, 1) == 2)
+		default:
+			return nil, fmt.Errorf("unsupported compression %d", compression)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("strip %d: %w", i, err)
+		}
+		if len(decoded) < stride*rows {
+			return nil, fmt.Errorf("strip %d too short", i)
+		}
+		pixels = append(pixels, decoded[:stride*rows]...)
+	}
+	if len(pixels) < stride*height {
+		return nil, fmt.Errorf("image data too short")
+	}
+
+	samples := tiffSamples{data: pixels, stride: stride, bits: bitsPerSample, perPixel: samplesPerPixel, order: t.order}
+	return samples.image(ifd, width, height)
+}
+
+// tiffSamples reads the samples of uncompressed pixel rows.
+type tiffSamples struct {
+	data     []byte
+	stride   int
+	bits     int
+	perPixel int
+	order    binary.ByteOrder
+}
+
+// at returns sample s of pixel x in row y, scaled to 16 bits.
+func (s tiffSamples) at(x, y, sample int) uint16 {
+	index := x*s.perPixel + sample
+	row := s.data[y*s.stride:]
+	switch s.bits {
+	case 16:
+		return s.order.Uint16(row[2*index:])
+	case 8:
+		return uint16(row[index]) * 0x101
+	default:
+		bit := index * s.bits
+		max := uint16(1)<<uint(s.bits) - 1
+		value := uint16(row[bit/8]>>(8-uint(s.bits)-uint(bit%8))) & max
+		return value * (0xffff / max)
+	}
+}
+
+// index returns the raw value of the first sample of pixel x in row y.
+func (s tiffSamples) index(x, y int) uint8 {
+	return uint8(s.at(x, y, 0) >> (16 - uint(s.bits)))
+}
+
+func (s tiffSamples) image(ifd tiffIFD, width, height int) (image.Image, error) {
+	bounds := image.Rect(0, 0, width, height)
+	switch photometric := ifd.value(tiffPhotometric, tiffWhiteIsZero); photometric {
+	case tiffWhiteIsZero, tiffBlackIsZero:
+		img := image.NewGray16(bounds)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				value := s.at(x, y, 0)
+				if photometric == tiffWhiteIsZero {
+					value = 0xffff - value
+				}
+				img.SetGray16(x, y, color.Gray16{Y: value})
+			}
+		}
+		if s.bits <= 8 {
+			gray := image.NewGray(bounds)
+			for i := range gray.Pix {
+				gray.Pix[i] = img.Pix[2*i]
+			}
+			return gray, nil
+		}
+		return img, nil
+	case tiffRGB:
+		if s.perPixel < 3 || s.bits < 8 {
+			return nil, fmt.Errorf("unsupported RGB layout")
+		}
+		alpha := s.perPixel > 3 && ifd.value(tiffExtraSamples, 0) != 0
+		premultiplied := ifd.value(tiffExtraSamples, 0) == 1
+		img := image.NewNRGBA(bounds)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				c := color.RGBA64{R: s.at(x, y, 0), G: s.at(x, y, 1), B: s.at(x, y, 2), A: 0xffff}
+				if alpha {
+					c.A = s.at(x, y, 3)
+				}
+				if alpha && premultiplied {
+					img.Set(x, y, c)
+				} else {
+					img.SetNRGBA(x, y, color.NRGBA{R: uint8(c.R >> 8), G: uint8(c.G >> 8), B: uint8(c.B >> 8), A: uint8(c.A >> 8)})
+				}
+			}
+		}
+		return img, nil
+	case tiffPalette:
+		if s.bits > 8 {
+			return nil, fmt.Errorf("unsupported palette depth %d", s.bits)
+		}
+		palette := tiffColorPalette(ifd, s.bits)
+		if palette == nil {
+			return nil, fmt.Errorf("missing color map")
+		}
+		img := image.NewPaletted(bounds, palette)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				img.SetColorIndex(x, y, s.index(x, y))
+			}
+		}
+		return img, nil
+	default:
+		return nil, fmt.Errorf("unsupported photometric interpretation %d", photometric)
+	}
+}
+
+// tiffColorPalette converts the 16 bit TIFF color map to a palette.
+func tiffColorPalette(ifd tiffIFD, bitsPerSample int) color.Palette {
+	colorMap := ifd[tiffColorMap]
+	size := 1 << uint(bitsPerSample)
+	if len(colorMap) < 3*size {
+		return nil
+	}
+	palette := make(color.Palette, size)
+	for i := range palette {
+		palette[i] = color.RGBA64{R: uint16(colorMap[i]), G: uint16(colorMap[size+i]), B: uint16(colorMap[2*size+i]), A: 0xffff}
+	}
+	return palette
+}
+
+// unpackBits decodes PackBits compressed data up to the expected length.
+func unpackBits(data []byte, expected int) ([]byte, error) {
+	out := make([]byte, 0, expected)
+	for i := 0; i < len(data) && len(out) < expected; {
+		n := int(int8(data[i]))
+		i++
+		switch {
+		case n >= 0:
+			if i+n+1 > len(data) {
+				return nil, fmt.Errorf("PackBits literal run too short")
+			}
+			out = append(out, data[i:i+n+1]...)
+			i += n + 1
+		case n != -128:
+			if i >= len(data) {
+				return nil, fmt.Errorf("PackBits repeat run too short")
+			}
+			out = append(out, bytes.Repeat(data[i:i+1], 1-n)...)
+			i++
+		}
+	}
+	return out, nil
+}
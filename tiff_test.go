@@ -0,0 +1,449 @@
+package zplgfa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+type tiffTestPage struct {
+	tags  map[uint16][]uint32
+	strip []byte
+}
+
+// buildTIFF creates a little-endian TIFF file with one strip per page.
+func buildTIFF(pages ...tiffTestPage) []byte {
+	out := []byte("II*\x00\x00\x00\x00\x00")
+	linkOffset := 4
+	for _, page := range pages {
+		stripOffset := len(out)
+		out = append(out, page.strip...)
+		if len(out)%2 == 1 {
+			out = append(out, 0)
+		}
+
+		tags := map[uint16][]uint32{
+			tiffStripOffsets:    {uint32(stripOffset)},
+			tiffStripByteCounts: {uint32(len(page.strip))},
+		}
+		for tag, values := range page.tags {
+			tags[tag] = values
+		}
+
+		// values that do not fit into an entry are stored before the directory
+		external := map[uint16]uint32{}
+		for tag, values := range tags {
+			if len(values) > 1 {
+				external[tag] = uint32(len(out))
+				for _, value := range values {
+					out = binary.LittleEndian.AppendUint32(out, value)
+				}
+			}
+		}
+
+		binary.LittleEndian.PutUint32(out[linkOffset:], uint32(len(out)))
+		out = binary.LittleEndian.AppendUint16(out, uint16(len(tags)))
+		for tag := uint16(0); tag < 0xffff; tag++ {
+			values, ok := tags[tag]
+			if !ok {
+				continue
+			}
+			out = binary.LittleEndian.AppendUint16(out, tag)
+			out = binary.LittleEndian.AppendUint16(out, 4)
+			out = binary.LittleEndian.AppendUint32(out, uint32(len(values)))
+			if offset, ok := external[tag]; ok {
+				out = binary.LittleEndian.AppendUint32(out, offset)
+			} else {
+				out = binary.LittleEndian.AppendUint32(out, values[0])
+			}
+		}
+		linkOffset = len(out)
+		out = binary.LittleEndian.AppendUint32(out, 0)
+	}
+	return out
+}
+
+func bilevelTags(width, height int, compression uint32, extra map[uint16][]uint32) map[uint16][]uint32 {
+	tags := map[uint16][]uint32{
+		tiffImageWidth:  {uint32(width)},
+		tiffImageLength: {uint32(height)},
+		tiffCompression: {compression},
+		tiffPhotometric: {tiffWhiteIsZero},
+	}
+	for tag, values := range extra {
+		tags[tag] = values
+	}
+	return tags
+}
+
+// testBitWriter collects bits, most significant bit first.
+type testBitWriter struct {
+	data []byte
+	bits int
+}
+
+func (w *testBitWriter) write(code string) {
+	for _, c := range code {
+		if w.bits%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if c == '1' {
+			w.data[len(w.data)-1] |= 1 << (7 - uint(w.bits)%8)
+		}
+		w.bits++
+	}
+}
+
+func (w *testBitWriter) align() {
+	w.bits = (w.bits + 7) / 8 * 8
+}
+
+func (w *testBitWriter) run(length int, white bool) {
+	terminating, makeup := ccittBlackTerminating[:], ccittBlackMakeup[:]
+	if white {
+		terminating, makeup = ccittWhiteTerminating[:], ccittWhiteMakeup[:]
+	}
+	for length >= 2560 {
+		w.write(ccittExtendedMakeup[12])
+		length -= 2560
+	}
+	if length >= 1792 {
+		w.write(ccittExtendedMakeup[length/64-28])
+		length %= 64
+	} else if length >= 64 {
+		w.write(makeup[length/64-1])
+		length %= 64
+	}
+	w.write(terminating[length])
+}
+
+func (w *testBitWriter) encode1D(changes []int, width int) {
+	pos, white := 0, true
+	for _, change := range append(changes, width) {
+		w.run(change-pos, white)
+		pos, white = change, !white
+	}
+}
+
+func (w *testBitWriter) encode2D(changes, reference []int, width int) {
+	modes := map[int]string{}
+	for code, mode := range ccittModeCodes {
+		modes[mode] = code
+	}
+	vertical := map[int]string{}
+	for mode, offset := range ccittVerticalOffsets {
+		vertical[offset] = modes[mode]
+	}
+	next := func(after int) int {
+		for _, change := range changes {
+			if change > after {
+				return change
+			}
+		}
+		return width
+	}
+
+	a0, white := -1, true
+	for a0 < width {
+		a1 := next(a0)
+		b1, b2 := ccittReferenceChanges(reference, a0, white, width)
+		switch {
+		case b2 < a1:
+			w.write(modes[ccittPass])
+			a0 = b2
+		case a1-b1 >= -3 && a1-b1 <= 3:
+			w.write(vertical[a1-b1])
+			a0, white = a1, !white
+		default:
+			a2 := next(a1)
+			start := a0
+			if start < 0 {
+				start = 0
+			}
+			w.write(modes[ccittHorizontal])
+			w.run(a1-start, white)
+			w.run(a2-a1, !white)
+			a0 = a2
+		}
+	}
+}
+
+func rowChanges(raw []byte, width int) []int {
+	var changes []int
+	black := false
+	for x := 0; x < width; x++ {
+		if bit := raw[x/8]>>(7-uint(x)%8)&1 == 1; bit != black {
+			changes = append(changes, x)
+			black = bit
+		}
+	}
+	return changes
+}
+
+func encodeTestCCITT(raw []byte, width, height int, compression uint32, twoDimensional bool) []byte {
+	w := &testBitWriter{}
+	bytesPerRow := (width + 7) / 8
+	var reference []int
+	for y := 0; y < height; y++ {
+		changes := rowChanges(raw[y*bytesPerRow:], width)
+		switch {
+		case compression == tiffCompressionCCITTRLE:
+			w.encode1D(changes, width)
+			w.align()
+		case compression == tiffCompressionGroup3 && twoDimensional:
+			w.write("000000000001")
+			if y == 0 {
+				w.write("1")
+				w.encode1D(changes, width)
+			} else {
+				w.write("0")
+				w.encode2D(changes, reference, width)
+			}
+		case compression == tiffCompressionGroup3:
+			w.write("000000000001")
+			w.encode1D(changes, width)
+		default:
+			w.encode2D(changes, reference, width)
+		}
+		reference = changes
+	}
+	return w.data
+}
+
+func randomBilevel(width, height int, seed int64) []byte {
+	rnd := rand.New(rand.NewSource(seed))
+	bytesPerRow := (width + 7) / 8
+	raw := make([]byte, bytesPerRow*height)
+	black := false
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if rnd.Intn(6) == 0 {
+				black = !black
+			}
+			if black {
+				raw[y*bytesPerRow+x/8] |= 1 << (7 - uint(x)%8)
+			}
+		}
+	}
+	return raw
+}
+
+func Test_CCITTCodesArePrefixFree(t *testing.T) {
+	tables := map[string][]string{
+		"white": append(append(append([]string{}, ccittWhiteTerminating[:]...), ccittWhiteMakeup[:]...), ccittExtendedMakeup[:]...),
+		"black": append(append(append([]string{}, ccittBlackTerminating[:]...), ccittBlackMakeup[:]...), ccittExtendedMakeup[:]...),
+	}
+	for code := range ccittModeCodes {
+		tables["modes"] = append(tables["modes"], code)
+	}
+	for name, codes := range tables {
+		for i, a := range codes {
+			for j, b := range codes {
+				if i != j && strings.HasPrefix(b, a) {
+					t.Fatalf("%s code %q is a prefix of %q", name, a, b)
+				}
+			}
+		}
+	}
+}
+
+func Test_DecodeTIFFCCITT(t *testing.T) {
+	tests := []struct {
+		name        string
+		width       int
+		compression uint32
+		t4Options   uint32
+	}{
+		{"modified huffman", 61, tiffCompressionCCITTRLE, 0},
+		{"group 3 1D", 61, tiffCompressionGroup3, 0},
+		{"group 3 2D", 61, tiffCompressionGroup3, 1},
+		{"group 4", 61, tiffCompressionGroup4, 0},
+		{"group 4 wide", 3000, tiffCompressionGroup4, 0},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			height := 9
+			raw := randomBilevel(tt.width, height, int64(i))
+			// a full black row exercises the makeup codes
+			for x := 0; x < tt.width; x++ {
+				raw[4*((tt.width+7)/8)+x/8] |= 1 << (7 - uint(x)%8)
+			}
+			strip := encodeTestCCITT(raw, tt.width, height, tt.compression, tt.t4Options&1 != 0)
+			data := buildTIFF(tiffTestPage{
+				tags:  bilevelTags(tt.width, height, tt.compression, map[uint16][]uint32{tiffT4Options: {tt.t4Options}}),
+				strip: strip,
+			})
+
+			img, format, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("Decode failed: %s", err)
+			}
+			if format != "tiff" {
+				t.Fatalf("Decode format failed: got %s", format)
+			}
+			if packed, _ := packImage(img); !bytes.Equal(packed, raw) {
+				t.Fatalf("Decode failed:\ngot  % X\nwant % X", packed, raw)
+			}
+		})
+	}
+}
+
+func Test_DecodeTIFFCCITTFiles(t *testing.T) {
+	// the TIFF files and their PNG twin come from the test data of golang.org/x/image, not from encodeTestCCITT
+	decodeFile := func(filename string) image.Image {
+		file, err := os.Open(filename)
+		if err != nil {
+			t.Fatalf("Open failed: %s", err)
+		}
+		defer file.Close()
+		img, _, err := image.Decode(file)
+		if err != nil {
+			t.Fatalf("Decode failed for %s: %s", filename, err)
+		}
+		return img
+	}
+
+	want := decodeFile("./tests/bw-gopher.png")
+	for _, filename := range []string{"./tests/bw-gopher_ccittGroup3.tiff", "./tests/bw-gopher_ccittGroup4.tiff"} {
+		got := decodeFile(filename)
+		if got.Bounds() != want.Bounds() {
+			t.Fatalf("Decode failed for %s: got %v, want %v", filename, got.Bounds(), want.Bounds())
+		}
+		assertBilevelEqual(t, got, want)
+	}
+}
+
+func Test_DecodeTIFFFillOrder(t *testing.T) {
+	raw := randomBilevel(16, 3, 7)
+	strip := encodeTestCCITT(raw, 16, 3, tiffCompressionGroup4, false)
+	for i, b := range strip {
+		strip[i] = b>>7&1 | b>>5&2 | b>>3&4 | b>>1&8 | b<<1&16 | b<<3&32 | b<<5&64 | b<<7&128
+	}
+	img, err := DecodeTIFF(bytes.NewReader(buildTIFF(tiffTestPage{
+		tags:  bilevelTags(16, 3, tiffCompressionGroup4, map[uint16][]uint32{tiffFillOrder: {2}}),
+		strip: strip,
+	})))
+	if err != nil {
+		t.Fatalf("DecodeTIFF failed: %s", err)
+	}
+	if packed, _ := packImage(img); !bytes.Equal(packed, raw) {
+		t.Fatalf("DecodeTIFF failed: got % X, want % X", packed, raw)
+	}
+}
+
+func Test_DecodeTIFFFormats(t *testing.T) {
+	colorMap := make([]uint32, 3*4)
+	colorMap[1], colorMap[4+1], colorMap[8+1] = 0xffff, 0xffff, 0xffff
+
+	tests := []struct {
+		name string
+		page tiffTestPage
+		want []color.Gray
+	}{
+		{
+			name: "8 bit black is zero",
+			page: tiffTestPage{
+				tags:  map[uint16][]uint32{tiffImageWidth: {3}, tiffImageLength: {1}, tiffBitsPerSample: {8}, tiffPhotometric: {tiffBlackIsZero}},
+				strip: []byte{0x00, 0x80, 0xff},
+			},
+			want: []color.Gray{{0x00}, {0x80}, {0xff}},
+		},
+		{
+			name: "PackBits bilevel",
+			page: tiffTestPage{
+				tags:  bilevelTags(16, 1, tiffCompressionPackBits, nil),
+				strip: []byte{0xff, 0xf0},
+			},
+			want: []color.Gray{{0x00}, {0x00}, {0x00}, {0x00}, {0xff}},
+		},
+		{
+			name: "2 bit palette",
+			page: tiffTestPage{
+				tags:  map[uint16][]uint32{tiffImageWidth: {2}, tiffImageLength: {1}, tiffBitsPerSample: {2}, tiffPhotometric: {tiffPalette}, tiffColorMap: colorMap},
+				strip: []byte{0x40},
+			},
+			want: []color.Gray{{0xff}, {0x00}},
+		},
+		{
+			name: "RGB",
+			page: tiffTestPage{
+				tags:  map[uint16][]uint32{tiffImageWidth: {2}, tiffImageLength: {1}, tiffBitsPerSample: {8, 8, 8}, tiffSamplesPerPixel: {3}, tiffPhotometric: {tiffRGB}},
+				strip: []byte{0xff, 0xff, 0xff, 0x00, 0x00, 0x00},
+			},
+			want: []color.Gray{{0xff}, {0x00}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := DecodeTIFF(bytes.NewReader(buildTIFF(tt.page)))
+			if err != nil {
+				t.Fatalf("DecodeTIFF failed: %s", err)
+			}
+			for x, want := range tt.want {
+				if got := color.GrayModel.Convert(img.At(x, 0)).(color.Gray); got != want {
+					t.Fatalf("pixel %d failed: got %v, want %v", x, got, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_DecodeTIFFPages(t *testing.T) {
+	page := func(value byte) tiffTestPage {
+		return tiffTestPage{tags: bilevelTags(8, 1, tiffCompressionNone, nil), strip: []byte{value}}
+	}
+	data := buildTIFF(page(0xff), page(0x0f), page(0x00))
+
+	pages, format, err := DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("DecodeAll failed: %s", err)
+	}
+	if format != "tiff" || len(pages) != 3 {
+		t.Fatalf("DecodeAll failed: got %s with %d pages", format, len(pages))
+	}
+
+	got, err := ConvertReaderToZPLPages(bytes.NewReader(data), ASCII)
+	if err != nil {
+		t.Fatalf("ConvertReaderToZPLPages failed: %s", err)
+	}
+	want := "^XA,^FS\n^FO0,0\n^GFA,3,1,1,\nFF\n^FS,^XZ\n" +
+		"^XA,^FS\n^FO0,0\n^GFA,3,1,1,\n0F\n^FS,^XZ\n" +
+		"^XA,^FS\n^FO0,0\n^GFA,3,1,1,\n00\n^FS,^XZ\n"
+	if got != want {
+		t.Fatalf("ConvertReaderToZPLPages failed:\nExpected:\n%s\nGot:\n%s", want, got)
+	}
+
+	config, err := DecodeTIFFConfig(bytes.NewReader(data))
+	if err != nil || config.Width != 8 || config.Height != 1 {
+		t.Fatalf("DecodeTIFFConfig failed: %v %v", config, err)
+	}
+}
+
+func Test_DecodeTIFFError(t *testing.T) {
+	if _, err := DecodeTIFF(strings.NewReader("II*\x00\xff\xff\xff\xff")); err == nil {
+		t.Fatal("DecodeTIFF should fail for invalid directory offsets")
+	}
+	data := buildTIFF(tiffTestPage{tags: bilevelTags(8, 2, tiffCompressionGroup4, nil), strip: []byte{0x00}})
+	if _, err := DecodeTIFF(bytes.NewReader(data)); err == nil {
+		t.Fatal("DecodeTIFF should fail for invalid CCITT data")
+	}
+
+	// forged headers must fail before the pixels are allocated
+	huge := buildTIFF(tiffTestPage{tags: bilevelTags(0xffffffff, 0xffffffff, tiffCompressionNone, nil), strip: []byte{0x00}})
+	if _, err := DecodeTIFF(bytes.NewReader(huge)); err == nil || !strings.Contains(err.Error(), "maximum") {
+		t.Fatalf("DecodeTIFF failed: got %v, want a size error", err)
+	}
+	for _, compression := range []uint32{tiffCompressionNone, tiffCompressionPackBits} {
+		data := buildTIFF(tiffTestPage{tags: bilevelTags(8000, 8000, compression, nil), strip: []byte{0x00}})
+		if _, err := DecodeTIFF(bytes.NewReader(data)); err == nil || !strings.Contains(err.Error(), "too short") {
+			t.Fatalf("DecodeTIFF failed for compression %d: got %v, want too short data", compression, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package zplgfa
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// TranscodedField describes a graphic field rewritten by TranscodeGraphicFields.
+type TranscodedField struct {
+	// Index counts the ^GF fields of the document from 0.
+	Index int
+	// From is the encoding of the field in the document and To the encoding it is written with.
+	// They are equal if the field was kept as it is.
+	From, To GraphicType
+	// Before and After are the sizes of the ^GF command in bytes.
+	Before, After int
+}
+
+// TranscodeResult is a document rewritten by TranscodeGraphicFields.
+type TranscodeResult struct {
+	ZPL    string
+	Fields []TranscodedField
+}
+
+// Saved returns the number of bytes the rewritten graphic fields are smaller than the original ones.
+func (r TranscodeResult) Saved() int {
+	saved := 0
+	for _, field := range r.Fields {
+		saved += field.Before - field.After
+	}
+	return saved
+}
+
+// TranscodeGraphicFields rewrites every ^GF field of a ZPL document with the smallest of the given encodings,
+// leaving all other commands as they are. A field keeps its data if its encoding is one of graphicTypes and
+// none of them is smaller. Fields are decoded and encoded again without changing a dot, so the document prints
+// the same. A field that can't be decoded stops the rewrite with its *DecodeError.
+func TranscodeGraphicFields(zpl string, graphicTypes ...GraphicType) (TranscodeResult, error) {
+	return TranscodeGraphicFieldsContext(context.Background(), zpl, Limits{}, graphicTypes...)
+}
+
+// TranscodeGraphicFieldsContext is TranscodeGraphicFields with limits for decoding the fields and for the size
+// of the rewritten document, stopping with the error of ctx once ctx is done.
+func TranscodeGraphicFieldsContext(ctx context.Context, zpl string, limits Limits, graphicTypes ...GraphicType) (TranscodeResult, error) {
+	if len(graphicTypes) == 0 {
+		return TranscodeResult{}, fmt.Errorf("no graphic type to transcode to")
+	}
+
+	buffers := getSharedBuffers()
+	defer sharedBuffers.Put(buffers)
+
+	var result TranscodeResult
+	var out strings.Builder
+	out.Grow(len(zpl))
+	last := 0
+	for _, command := range ParseCommands(zpl) {
+		if !command.Is("^GF") {
+			continue
+		}
+		index := len(result.Fields)
+		text := zpl[command.Offset:command.End]
+		img, err := ConvertGraphicFieldToImageContext(ctx, text, limits)
+		if err != nil {
+			var decodeErr *DecodeError
+			if errors.As(err, &decodeErr) {
+				decodeErr.Offset += command.Offset
+				decodeErr.Field = index
+			}
+			return TranscodeResult{}, err
+		}
+		field, _ := parseGraphicField(text)
+		from := field.encoding()
+
+		// whitespace after text data belongs to the layout of the document, not to the field
+		body := text
+		if from != Binary {
+			body = strings.TrimRightFunc(text, unicode.IsSpace)
+		}
+		best, to := "", from
+		if slices.Contains(graphicTypes, from) {
+			best = body
+		}
+		for _, graphicType := range graphicTypes {
+			encoded, err := buffers.encodeGraphicField(ctx, img.Pix, img.Stride, img.Bounds().Dy(), command.Prefix, graphicType, limits)
+			if err != nil {
+				return TranscodeResult{}, err
+			}
+			if best == "" || len(encoded) < len(best) {
+				best, to = encoded, graphicType
+			}
+		}
+
+		out.WriteString(zpl[last:command.Offset])
+		out.WriteString(best)
+		out.WriteString(text[len(body):])
+		last = command.End
+		result.Fields = append(result.Fields, TranscodedField{Index: index, From: from, To: to, Before: len(body), After: len(best)})
+	}
+	out.WriteString(zpl[last:])
+
+	if err := limits.checkOutput(out.Len()); err != nil {
+		return TranscodeResult{}, err
+	}
+	result.ZPL = out.String()
+	return result, nil
+}
+
+// encodeGraphicField returns packed rows as a ^GF command of the given type written with prefix, which ^CC
+// may have changed. Text data ends without whitespace, which belongs to the layout of the document.
+func (b *encodeBuffers) encodeGraphicField(ctx context.Context, raw []byte, bytesPerRow, height int, prefix byte, graphicType GraphicType, limits Limits) (string, error) {
+	b.out.Reset()
+	if err := b.writeGraphicCommand(ctx, &b.out, raw, bytesPerRow, height, graphicType, 1, limits); err != nil {
+		return "", err
+	}
+	encoded := b.out.Bytes()
+	encoded[0] = prefix
+	if graphicType != Binary {
+		encoded = bytes.TrimRightFunc(encoded, unicode.IsSpace)
+	}
+	return string(encoded), nil
+}
+
+// reencodeGraphicField returns img as a ^GF command with the encoding and prefix of command.
+// Whitespace after text data is kept for the layout of the document.
+func (b *encodeBuffers) reencodeGraphicField(ctx context.Context, command Command, img image.Image, limits Limits) (string, error) {
+	text := command.String()
+	field, _ := parseGraphicField(text)
+	graphicType := field.encoding()
+	tail := ""
+	if graphicType != Binary {
+		tail = text[len(strings.TrimRightFunc(text, unicode.IsSpace)):]
+	}
+	raw, bytesPerRow := packImage(img)
+	encoded, err := b.encodeGraphicField(ctx, raw, bytesPerRow, img.Bounds().Dy(), command.Prefix, graphicType, limits)
+	if err != nil {
+		return "", err
+	}
+	return encoded + tail, nil
+}
@@ -0,0 +1,97 @@
+package zplgfa
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// transcodeDocument returns a document with a text field between two ASCII graphic fields.
+func transcodeDocument() string {
+	return "^XA\n^FO10,10" + ConvertToGraphicField(repeatedRowsImage(200, 80), ASCII) + "^FS\n" +
+		"^FO20,200^A0N,30,30^FDHello ~ World^FS\n" +
+		"^FO10,300" + ConvertToGraphicField(checkerImage(37, 21), ASCII) + "^FS\n^XZ\n"
+}
+
+// otherCommands returns the commands of zpl that are no graphic fields.
+func otherCommands(zpl string) []string {
+	var commands []string
+	for _, command := range ParseCommands(zpl) {
+		if !command.Is("^GF") {
+			commands = append(commands, zpl[command.Offset:command.End])
+		}
+	}
+	return commands
+}
+
+func assertSameGraphics(t *testing.T, got, want string) {
+	t.Helper()
+	gotFields, wantFields := ScanGraphicFields(got), ScanGraphicFields(want)
+	if len(gotFields) != len(wantFields) {
+		t.Fatalf("TranscodeGraphicFields failed: got %d graphic fields, want %d", len(gotFields), len(wantFields))
+	}
+	for i := range gotFields {
+		if gotFields[i].Err != nil || !bytes.Equal(gotFields[i].Image.Pix, wantFields[i].Image.Pix) || gotFields[i].Width != wantFields[i].Width {
+			t.Fatalf("TranscodeGraphicFields failed: field %d changed (%v)", i, gotFields[i].Err)
+		}
+	}
+	if strings.Join(otherCommands(got), "") != strings.Join(otherCommands(want), "") {
+		t.Fatalf("TranscodeGraphicFields failed: other commands changed")
+	}
+}
+
+func Test_TranscodeGraphicFields(t *testing.T) {
+	zpl := transcodeDocument()
+	for _, graphicTypes := range [][]GraphicType{{CompressedASCII}, {Z64}, {B64}, {Binary}, {CompressedASCII, Z64}} {
+		result, err := TranscodeGraphicFields(zpl, graphicTypes...)
+		if err != nil {
+			t.Fatalf("TranscodeGraphicFields failed for %v: %v", graphicTypes, err)
+		}
+		assertSameGraphics(t, result.ZPL, zpl)
+		if len(result.Fields) != 2 || result.Saved() != len(zpl)-len(result.ZPL) {
+			t.Fatalf("TranscodeGraphicFields failed for %v: got %+v, saved %d of %d", graphicTypes, result.Fields, result.Saved(), len(zpl)-len(result.ZPL))
+		}
+		for _, field := range result.Fields {
+			if field.From != ASCII || (len(graphicTypes) == 1 && field.To != graphicTypes[0]) {
+				t.Fatalf("TranscodeGraphicFields failed for %v: got %+v", graphicTypes, field)
+			}
+		}
+	}
+
+	smallest, _ := TranscodeGraphicFields(zpl, CompressedASCII, Z64)
+	for _, graphicType := range []GraphicType{CompressedASCII, Z64} {
+		single, _ := TranscodeGraphicFields(zpl, graphicType)
+		if len(smallest.ZPL) > len(single.ZPL) {
+			t.Fatalf("TranscodeGraphicFields failed: the smallest encoding is larger than %s", graphicType)
+		}
+	}
+
+	// fields already in the smallest form are kept byte for byte
+	again, err := TranscodeGraphicFields(smallest.ZPL, CompressedASCII, Z64)
+	if err != nil || again.ZPL != smallest.ZPL || again.Saved() != 0 {
+		t.Fatalf("TranscodeGraphicFields failed: transcoding twice changed the document (%v)", err)
+	}
+}
+
+func Test_TranscodeGraphicFieldsPrefix(t *testing.T) {
+	field := ConvertToGraphicField(checkerImage(16, 4), ASCII)
+	zpl := "^XA^CC+" + "+FO0,0+" + field[1:] + "+FS+XZ"
+	result, err := TranscodeGraphicFields(zpl, Z64)
+	if err != nil || !strings.Contains(result.ZPL, "+GFA,") || !strings.Contains(result.ZPL, ":Z64:") {
+		t.Fatalf("TranscodeGraphicFields failed: got %q, %v", result.ZPL, err)
+	}
+	assertSameGraphics(t, result.ZPL, zpl)
+}
+
+func Test_TranscodeGraphicFieldsError(t *testing.T) {
+	zpl := transcodeDocument() + "^XA^FO0,0^GFA,4,4,2,\nFFFF\nFFZF^FS^XZ"
+	_, err := TranscodeGraphicFields(zpl, Z64)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Field != 2 || decodeErr.Offset != strings.Index(zpl, "FFZF")+2 {
+		t.Fatalf("TranscodeGraphicFields failed: got %v, want an invalid character in field 2", err)
+	}
+	if _, err := TranscodeGraphicFields(zpl); err == nil {
+		t.Fatalf("TranscodeGraphicFields should fail without a graphic type")
+	}
+}
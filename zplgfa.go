@@ -3,8 +3,10 @@ package zplgfa
 import (
 	"bytes"
 	"compress/zlib"
+	"context"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
@@ -12,10 +14,10 @@ import (
 	_ "image/jpeg"
 	_ "image/png"
 	"io"
-	"math"
 	"os"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 // GraphicType is a type to select the graphic format
@@ -30,14 +32,62 @@ const (
 	CompressedASCII
 	// Z64 compresses the binary data with zlib and encodes it as base64 with a CRC
 	Z64
+	// B64 encodes the binary data as base64 with a CRC, without compressing it
+	B64
 )
 
+func (t GraphicType) String() string {
+	switch t {
+	case ASCII:
+		return "ASCII"
+	case Binary:
+		return "Binary"
+	case CompressedASCII:
+		return "CompressedASCII"
+	case Z64:
+		return "Z64"
+	case B64:
+		return "B64"
+	}
+	return fmt.Sprintf("GraphicType(%d)", int(t))
+}
+
 // ConvertOptions configures ZPL output created by ConvertToZPLWithOptions.
 type ConvertOptions struct {
 	GraphicType GraphicType
 	X           int
 	Y           int
 	Reverse     bool
+	// IgnoreOrientation disables applying the EXIF orientation when decoding images from readers.
+	IgnoreOrientation bool
+	// Morphology is applied in order to the thresholded bitmap just before encoding.
+	Morphology []Morphology
+	// ThinBlack shortens horizontal and vertical black runs by this many dots to compensate for dot gain.
+	ThinBlack int
+	// Scale resizes the image by this factor before encoding, zero means no scaling.
+	Scale float64
+	// Resampling selects the scaling method, ResampleBilevel keeps narrow bars and gaps of barcodes.
+	Resampling Resampling
+	// Hybrid dithers continuous-tone regions and thresholds bilevel regions instead of thresholding the whole image.
+	Hybrid *HybridOptions
+	// Flatten configures how transparent pixels are printed. Images decoded from readers are always flattened,
+	// other images only if Flatten is set. Options that fail FlattenOptions.Validate stop the conversion.
+	Flatten FlattenOptions
+	// Workers packs and encodes the rows of the graphic on this many goroutines, zero or one encodes sequentially
+	// and a negative value uses one worker per CPU. The output is the same for every worker count.
+	Workers int
+	// Limits bounds the size of images and of the created ZPL, exceeding them returns a *LimitError.
+	Limits Limits
+}
+
+// ConvertResult describes a label created by ConvertReaderToZPLWithOptions.
+type ConvertResult struct {
+	ZPL    string
+	Format string
+	Width  int
+	Height int
+	// Orientation is the EXIF orientation that was applied before converting the image.
+	Orientation Orientation
 }
 
 // ConvertToZPL wraps ConvertToGraphicField, adding ZPL start and end codes.
@@ -56,12 +106,21 @@ func ConvertToZPLWithOptions(img image.Image, options ConvertOptions) string {
 		return ""
 	}
 
-	reverseField := ""
-	if options.Reverse {
-		reverseField = "^FR\n"
+	zpl, err := convertToZPL(img, options)
+	if err != nil {
+		return ""
 	}
+	return zpl
+}
+
+// convertToZPL is the error returning implementation of ConvertToZPLWithOptions.
+func convertToZPL(img image.Image, options ConvertOptions) (string, error) {
+	return sharedConverter(options).ConvertToZPL(img)
+}
 
-	return fmt.Sprintf("^XA,^FS\n^FO%d,%d\n%s%s^FS,^XZ\n", options.X, options.Y, reverseField, ConvertToGraphicField(img, options.GraphicType))
+// ConvertToZPLContext is the error returning ConvertToZPLWithOptions, stopping with the error of ctx once ctx is done.
+func ConvertToZPLContext(ctx context.Context, img image.Image, options ConvertOptions) (string, error) {
+	return sharedConverter(options).ConvertToZPLContext(ctx, img)
 }
 
 // ConvertToZPLLines converts black pixel runs to ZPL line/box commands.
@@ -100,23 +159,23 @@ func ConvertToLineFields(img image.Image, options ConvertOptions) string {
 		reverseField = "^FR\n"
 	}
 
+	read := newBlackRowReader(img)
+	row := make([]bool, bounds.Dx())
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		read(y, row)
 		runStart := -1
-		for x := bounds.Min.X; x <= bounds.Max.X; x++ {
-			black := false
-			if x < bounds.Max.X {
-				black = color.Gray16Model.Convert(img.At(x, y)).(color.Gray16).Y < math.MaxUint16/2
-			}
+		for x := 0; x <= len(row); x++ {
+			black := x < len(row) && row[x]
 			if black && runStart == -1 {
 				runStart = x
 			}
-			if (!black || x == bounds.Max.X) && runStart != -1 {
-				fields.WriteString(fmt.Sprintf("^FO%d,%d\n%s^GB%d,1,1^FS\n",
-					options.X+runStart-bounds.Min.X,
+			if (!black || x == len(row)) && runStart != -1 {
+				fmt.Fprintf(&fields, "^FO%d,%d\n%s^GB%d,1,1^FS\n",
+					options.X+runStart,
 					options.Y+y-bounds.Min.Y,
 					reverseField,
 					x-runStart,
-				))
+				)
 				runStart = -1
 			}
 		}
@@ -125,14 +184,26 @@ func ConvertToLineFields(img image.Image, options ConvertOptions) string {
 	return fields.String()
 }
 
-// ConvertReaderToZPL decodes PNG, JPEG or GIF image data from reader and converts it to ZPL.
+// ConvertReaderToZPL decodes PNG, JPEG, GIF, BMP, TIFF, GRF or PCX image data from reader and converts it to ZPL.
+// The image is rotated or mirrored according to its EXIF orientation.
 func ConvertReaderToZPL(reader io.Reader, graphicType GraphicType) (string, error) {
-	img, _, err := image.Decode(reader)
+	result, err := ConvertReaderToZPLWithOptions(reader, ConvertOptions{GraphicType: graphicType})
 	if err != nil {
 		return "", err
 	}
+	return result.ZPL, nil
+}
+
+// ConvertReaderToZPLWithOptions decodes image data from reader, applies its EXIF orientation
+// unless disabled in the options and converts it to ZPL.
+func ConvertReaderToZPLWithOptions(reader io.Reader, options ConvertOptions) (ConvertResult, error) {
+	return ConvertReaderToZPLContext(context.Background(), reader, options)
+}
 
-	return ConvertToZPL(FlattenImage(img), graphicType), nil
+// ConvertReaderToZPLContext is ConvertReaderToZPLWithOptions, stopping with the error of ctx once ctx is done.
+// Images with more pixels than options.Limits allows are rejected before they are decoded.
+func ConvertReaderToZPLContext(ctx context.Context, reader io.Reader, options ConvertOptions) (ConvertResult, error) {
+	return sharedConverter(options).ConvertReaderToZPLContext(ctx, reader)
 }
 
 // ConvertFileToZPL opens an image file, decodes it and converts it to ZPL.
@@ -146,392 +217,684 @@ func ConvertFileToZPL(filename string, graphicType GraphicType) (string, error)
 	return ConvertReaderToZPL(file, graphicType)
 }
 
+// AlphaMode selects how FlattenImageWithOptions treats transparent pixels.
+type AlphaMode int
+
+const (
+	// AlphaLegacy blends with the bitwise OR blend FlattenImage has always used, always onto white
+	AlphaLegacy AlphaMode = iota
+	// AlphaComposite composites the pixels onto the background color
+	AlphaComposite
+	// AlphaMask prints every pixel with an alpha above the threshold black, regardless of its color
+	AlphaMask
+)
+
+// FlattenOptions configures FlattenImageWithOptions.
+type FlattenOptions struct {
+	// Background is the color behind transparent pixels, nil means white. AlphaLegacy always uses white.
+	Background color.Color
+	Alpha      AlphaMode
+	// AlphaThreshold is the alpha value pixels must exceed to print black in AlphaMask mode.
+	AlphaThreshold uint8
+}
+
+// Validate returns an error if the options combine AlphaLegacy with a background that is not white.
+func (o FlattenOptions) Validate() error {
+	if o.Background == nil || o.Alpha != AlphaLegacy {
+		return nil
+	}
+	// transparent parts of the background are white
+	if r, g, b, a := o.Background.RGBA(); r+0xffff-a != 0xffff || g+0xffff-a != 0xffff || b+0xffff-a != 0xffff {
+		return fmt.Errorf("AlphaLegacy always blends onto white, use AlphaComposite or AlphaMask for another background")
+	}
+	return nil
+}
+
+// ParseAlphaMode returns the AlphaMode for the names legacy, composite and mask.
+func ParseAlphaMode(name string) (AlphaMode, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "legacy":
+		return AlphaLegacy, nil
+	case "composite":
+		return AlphaComposite, nil
+	case "mask":
+		return AlphaMask, nil
+	default:
+		return AlphaLegacy, fmt.Errorf("unknown alpha mode \"%s\"", name)
+	}
+}
+
+// ParseHexColor parses colors like "#ffffff", "fff" or "#ffffff80".
+func ParseHexColor(value string) (color.Color, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "#")
+	if len(value) == 3 {
+		value = string([]byte{value[0], value[0], value[1], value[1], value[2], value[2]})
+	}
+	if len(value) == 6 {
+		value += "ff"
+	}
+	c, err := hex.DecodeString(value)
+	if err != nil || len(c) != 4 {
+		return nil, fmt.Errorf("invalid color \"%s\"", value)
+	}
+	return color.NRGBA{R: c[0], G: c[1], B: c[2], A: c[3]}, nil
+}
+
 // FlattenImage optimizes an image for the converting process.
 func FlattenImage(source image.Image) *image.NRGBA {
-	size := source.Bounds().Size()
-	target := image.NewNRGBA(source.Bounds())
-	background := color.White
+	return FlattenImageWithOptions(source, FlattenOptions{})
+}
+
+// FlattenImageWithOptions removes the transparency of an image according to options.
+func FlattenImageWithOptions(source image.Image, options FlattenOptions) *image.NRGBA {
+	target, _ := flattenImageInto(context.Background(), nil, source, options)
+	return target
+}
 
-	for y := 0; y < size.Y; y++ {
-		for x := 0; x < size.X; x++ {
-			p := source.At(x, y)
-			target.Set(x, y, flatten(p, background))
+// flattenImageInto is FlattenImageWithOptions reusing the pixel memory of target if it is large enough.
+// It stops with the error of ctx once ctx is done.
+func flattenImageInto(ctx context.Context, target *image.NRGBA, source image.Image, options FlattenOptions) (*image.NRGBA, error) {
+	bounds := source.Bounds()
+	target = reuseNRGBA(target, bounds)
+	background := options.Background
+	if background == nil {
+		background = color.White
+	}
+
+	bgR, bgG, bgB, bgA := background.RGBA()
+	// Backgrounds are opaque, transparent parts of them are white.
+	opaqueR, opaqueG, opaqueB := bgR+0xffff-bgA, bgG+0xffff-bgA, bgB+0xffff-bgA
+	maskWhite := compositeRGBA64(bgR, bgG, bgB, bgA, 0xffff, 0xffff, 0xffff)
+
+	// pixel flattens premultiplied values, generic flattens any color.
+	var pixel func(r, g, b, a uint32) color.NRGBA
+	generic := func(c color.Color) color.NRGBA {
+		return pixel(c.RGBA())
+	}
+	switch options.Alpha {
+	case AlphaComposite:
+		pixel = func(r, g, b, a uint32) color.NRGBA {
+			return compositeRGBA64(r, g, b, a, opaqueR, opaqueG, opaqueB)
+		}
+	case AlphaMask:
+		pixel = func(_, _, _, a uint32) color.NRGBA {
+			if a>>8 > uint32(options.AlphaThreshold) {
+				return color.NRGBA{A: 0xff}
+			}
+			return maskWhite
+		}
+	default:
+		pixel = func(r, g, b, a uint32) color.NRGBA {
+			return flattenNRGBA64(toNRGBA64(r, g, b, a))
+		}
+		// color.NRGBA64Model returns NRGBA64 colors unchanged instead of converting their premultiplied values.
+		generic = func(c color.Color) color.NRGBA {
+			return flattenNRGBA64(color.NRGBA64Model.Convert(c).(color.NRGBA64))
 		}
 	}
-	return target
+
+	set := func(x int, pix []byte, c color.NRGBA) {
+		pix[4*x], pix[4*x+1], pix[4*x+2], pix[4*x+3] = c.R, c.G, c.B, c.A
+	}
+
+	if paletted, ok := source.(*image.Paletted); ok {
+		lookup := make([]color.NRGBA, 256)
+		for i, c := range paletted.Palette {
+			lookup[i] = generic(c)
+		}
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			if err := canceled(ctx); err != nil {
+				return nil, err
+			}
+			src := paletted.Pix[paletted.PixOffset(bounds.Min.X, y):]
+			pix := target.Pix[target.PixOffset(bounds.Min.X, y):]
+			for x := 0; x < bounds.Dx(); x++ {
+				set(x, pix, lookup[src[x]])
+			}
+		}
+		return target, nil
+	}
+
+	if read := newRGBA64Reader(source); read != nil {
+		row := make([]uint32, 4*bounds.Dx())
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			if err := canceled(ctx); err != nil {
+				return nil, err
+			}
+			read(y, row)
+			pix := target.Pix[target.PixOffset(bounds.Min.X, y):]
+			for x := 0; x < bounds.Dx(); x++ {
+				set(x, pix, pixel(row[4*x], row[4*x+1], row[4*x+2], row[4*x+3]))
+			}
+		}
+		return target, nil
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		if err := canceled(ctx); err != nil {
+			return nil, err
+		}
+		pix := target.Pix[target.PixOffset(bounds.Min.X, y):]
+		for x := 0; x < bounds.Dx(); x++ {
+			set(x, pix, generic(source.At(bounds.Min.X+x, y)))
+		}
+	}
+	return target, nil
+}
+
+// compositeRGBA64 draws premultiplied color values over an opaque background.
+func compositeRGBA64(r, g, b, a, bgR, bgG, bgB uint32) color.NRGBA {
+	blend := func(c, bg uint32) uint8 {
+		return uint8((c + bg*(0xffff-a)/0xffff) >> 8)
+	}
+	return color.NRGBA{R: blend(r, bgR), G: blend(g, bgG), B: blend(b, bgB), A: 0xff}
 }
 
-// flatten blends a pixel with the background color based on its alpha value.
-func flatten(input, background color.Color) color.Color {
-	src := color.NRGBA64Model.Convert(input).(color.NRGBA64)
+// toNRGBA64 converts premultiplied color values like color.NRGBA64Model.
+func toNRGBA64(r, g, b, a uint32) color.NRGBA64 {
+	switch a {
+	case 0xffff:
+		return color.NRGBA64{uint16(r), uint16(g), uint16(b), 0xffff}
+	case 0:
+		return color.NRGBA64{}
+	}
+	return color.NRGBA64{uint16(r * 0xffff / a), uint16(g * 0xffff / a), uint16(b * 0xffff / a), uint16(a)}
+}
+
+// flattenNRGBA64 blends a pixel with white based on its alpha value. The OR blend only works for white,
+// so AlphaLegacy ignores the background color.
+func flattenNRGBA64(src color.NRGBA64) color.NRGBA {
 	r, g, b, a := src.RGBA()
-	bgR, bgG, bgB, _ := background.RGBA()
 	alpha := float32(a) / 0xffff
 
-	blend := func(c, bg uint32) uint8 {
-		val := 0xffff - uint32(float32(bg)*alpha)
+	blend := func(c uint32) uint8 {
+		val := 0xffff - uint32(0xffff*alpha)
 		val |= uint32(float32(c) * alpha)
 		return uint8(val >> 8)
 	}
 
 	return color.NRGBA{
-		R: blend(r, bgR),
-		G: blend(g, bgG),
-		B: blend(b, bgB),
+		R: blend(r),
+		G: blend(g),
+		B: blend(b),
 		A: 0xff,
 	}
 }
 
-// getRepeatCode generates ZPL repeat codes for character compression.
-func getRepeatCode(repeatCount int, char string) string {
+// appendRepeatCode appends the ZPL repeat code for count repetitions of char to dst.
+func appendRepeatCode(dst []byte, count int, char byte) []byte {
 	const maxRepeat = 419
 	highString := " ghijklmnopqrstuvwxyz"
 	lowString := " GHIJKLMNOPQRSTUVWXY"
 
-	encode := func(count int) string {
-		var singleRepeatStr strings.Builder
-		high := count / 20
-		low := count % 20
-
-		if high > 0 {
-			singleRepeatStr.WriteByte(highString[high])
+	encode := func(dst []byte, count int) []byte {
+		if high := count / 20; high > 0 {
+			dst = append(dst, highString[high])
 		}
-		if low > 0 {
-			singleRepeatStr.WriteByte(lowString[low])
+		if low := count % 20; low > 0 {
+			dst = append(dst, lowString[low])
 		}
-
-		singleRepeatStr.WriteString(char)
-		return singleRepeatStr.String()
+		return append(dst, char)
 	}
 
-	if repeatCount > maxRepeat {
-		var repeatStr strings.Builder
-		remainder := repeatCount % maxRepeat
-		quotient := repeatCount / maxRepeat
-
-		if remainder > 0 {
-			repeatStr.WriteString(encode(remainder))
+	if count > maxRepeat {
+		if remainder := count % maxRepeat; remainder > 0 {
+			dst = encode(dst, remainder)
 		}
-
-		maxEncoding := encode(maxRepeat)
-		for i := 0; i < quotient; i++ {
-			repeatStr.WriteString(maxEncoding)
+		for i := 0; i < count/maxRepeat; i++ {
+			dst = encode(dst, maxRepeat)
 		}
-
-		return repeatStr.String()
+		return dst
 	}
-
-	return encode(repeatCount)
+	return encode(dst, count)
 }
 
 // CompressASCII compresses the ASCII data of a ZPL Graphic Field using RLE.
 func CompressASCII(input string) string {
-	if input == "" {
-		return ""
-	}
-
-	var output strings.Builder
-	var lastChar string
-	var lastCharSince int
-
-	for i := 0; i <= len(input); i++ {
-		curChar := ""
-		if i < len(input) {
-			curChar = string(input[i])
-		}
+	return string(appendCompressedASCII(nil, []byte(input)))
+}
 
-		if lastChar != curChar {
-			if i-lastCharSince > 4 {
-				output.WriteString(getRepeatCode(i-lastCharSince, lastChar))
-			} else {
-				output.WriteString(strings.Repeat(lastChar, i-lastCharSince))
-			}
-			lastChar = curChar
-			lastCharSince = i
+// appendCompressedASCII appends the run-length compressed form of a line of hex digits to dst.
+// Runs of more than four equal digits are replaced by repeat codes.
+func appendCompressedASCII(dst, line []byte) []byte {
+	for i := 0; i < len(line); {
+		run := 1
+		for i+run < len(line) && line[i+run] == line[i] {
+			run++
 		}
-
-		if curChar == "" && lastCharSince == 0 {
-			switch lastChar {
-			case "0":
-				return ","
-			case "F":
-				return "!"
-			}
+		if run > 4 {
+			dst = appendRepeatCode(dst, run, line[i])
+		} else {
+			dst = append(dst, line[i:i+run]...)
 		}
+		i += run
 	}
-
-	return output.String()
+	return dst
 }
 
 // EncodeZ64 compresses binary graphic data and formats it as a Z64 payload.
 func EncodeZ64(input []byte) (string, error) {
-	var compressed bytes.Buffer
-	writer := zlib.NewWriter(&compressed)
-	if _, err := writer.Write(input); err != nil {
-		if closeErr := writer.Close(); closeErr != nil {
-			return "", fmt.Errorf("zlib write failed: %w (close also failed: %v)", err, closeErr)
-		}
+	var buffers encodeBuffers
+	if err := buffers.writeZ64(&buffers.data, input); err != nil {
 		return "", err
 	}
-	if err := writer.Close(); err != nil {
-		return "", err
-	}
-
-	compressedBytes := compressed.Bytes()
-	return fmt.Sprintf(":Z64:%s:%04X", base64.StdEncoding.EncodeToString(compressedBytes), crc16CCITT(compressedBytes)), nil
+	return buffers.data.String(), nil
 }
 
 // ConvertZPLToImage extracts the first ^GF field from a ZPL string and converts it to an image.
-func ConvertZPLToImage(zpl string) (*image.Gray, error) {
-	start := strings.Index(zpl, "^GF")
-	if start == -1 {
-		return nil, fmt.Errorf("no ^GF field found")
+func ConvertZPLToImage(zpl string) (*Monochrome, error) {
+	return ConvertZPLToImageContext(context.Background(), zpl, Limits{})
+}
+
+// ConvertZPLToImageContext is ConvertZPLToImage with limits for the size of the image and the inflated Z64 data,
+// stopping with the error of ctx once ctx is done.
+func ConvertZPLToImageContext(ctx context.Context, zpl string, limits Limits) (*Monochrome, error) {
+	command, err := firstGraphicField(zpl)
+	if err != nil {
+		return nil, err
+	}
+	img, err := ConvertGraphicFieldToImageContext(ctx, zpl[command.Offset:command.End], limits)
+	return img, shiftDecodeError(err, command.Offset)
+}
+
+// firstGraphicField returns the first ^GF command of zpl or a *DecodeError if it has none.
+func firstGraphicField(zpl string) (Command, error) {
+	for _, command := range ParseCommands(zpl) {
+		if command.Is("^GF") {
+			return command, nil
+		}
 	}
-	return ConvertGraphicFieldToImage(zpl[start:])
+	return Command{}, decodeError(DecodeNoField, 0, "the document has no ^GF command")
 }
 
 // ConvertGraphicFieldToImage converts a ZPL ^GF graphic field to a black and white image.
-func ConvertGraphicFieldToImage(graphicField string) (*image.Gray, error) {
-	gfType, bytesUsed, bytesPerRow, data, err := parseGraphicField(graphicField)
+// Malformed fields return a *DecodeError with the position of the error.
+func ConvertGraphicFieldToImage(graphicField string) (*Monochrome, error) {
+	return ConvertGraphicFieldToImageContext(context.Background(), graphicField, Limits{})
+}
+
+// ConvertGraphicFieldToImageContext is ConvertGraphicFieldToImage with limits for the size of the image and
+// the inflated Z64 data, stopping with the error of ctx once ctx is done.
+// The pixel limit is checked with the dimensions of the field before its data is decoded.
+func ConvertGraphicFieldToImageContext(ctx context.Context, graphicField string, limits Limits) (*Monochrome, error) {
+	field, err := parseGraphicField(graphicField)
 	if err != nil {
 		return nil, err
 	}
-	if bytesPerRow <= 0 || bytesUsed < 0 || bytesUsed%bytesPerRow != 0 {
-		return nil, fmt.Errorf("invalid ^GF dimensions")
+	width, height, err := field.size()
+	if err != nil {
+		return nil, err
+	}
+	if err := limits.checkPixels(width, height); err != nil {
+		return nil, err
 	}
 
 	var raw []byte
-	switch gfType {
+	switch field.format {
 	case 'A', 'C':
-		raw, err = decodeASCIIData(data, bytesUsed, bytesPerRow)
+		raw, err = decodeASCIIData(ctx, field.data, field.total, field.bytesPerRow, limits)
 	case 'B':
-		raw, err = decodeBinaryData(data, bytesUsed)
+		raw, err = decodeBinaryData(field.data, field.total, field.bytesPerRow)
 	default:
-		err = fmt.Errorf("unsupported ^GF type %q", string(gfType))
+		return nil, decodeError(DecodeHeader, 3, "unsupported ^GF type %q", string(field.format))
 	}
 	if err != nil {
-		return nil, err
+		return nil, shiftDecodeError(err, field.dataOffset)
 	}
 
-	return imageFromGraphicData(raw, bytesPerRow), nil
+	return imageFromGraphicData(raw, field.bytesPerRow), nil
 }
 
-func parseGraphicField(graphicField string) (byte, int, int, string, error) {
-	if len(graphicField) < 4 || graphicField[:3] != "^GF" {
-		return 0, 0, 0, "", fmt.Errorf("graphic field must start with ^GF")
-	}
+// maxGraphicFieldDots caps the width and height a graphic field may declare, maxGraphicFieldBytes its packed
+// rows: 16 MiB hold 4194 rows of the 32000 dots of the widest ^PW. They are far beyond any label, but keep
+// forged headers from making the decoder allocate or loop for absurd sizes.
+const (
+	maxGraphicFieldDots  = 1 << 16
+	maxGraphicFieldBytes = 1 << 24
+)
 
-	gfType := graphicField[3]
-	rest := graphicField[4:]
-	parts := strings.SplitN(rest, ",", 5)
-	if len(parts) != 5 {
-		return 0, 0, 0, "", fmt.Errorf("invalid ^GF field")
+// checkGraphicSize returns an error if a graphic of width x height dots exceeds the maximum size.
+func checkGraphicSize(width, height int) error {
+	if width > maxGraphicFieldDots || height > maxGraphicFieldDots {
+		return fmt.Errorf("%dx%d dots exceed the maximum of %dx%d", width, height, maxGraphicFieldDots, maxGraphicFieldDots)
+	}
+	if bytes := (max(width, 0) + 7) / 8 * max(height, 0); bytes > maxGraphicFieldBytes {
+		return fmt.Errorf("%dx%d dots need %d bytes, more than the maximum of %d", width, height, bytes, maxGraphicFieldBytes)
 	}
+	return nil
+}
 
-	bytesUsed, err := strconv.Atoi(strings.TrimSpace(parts[2]))
-	if err != nil {
-		return 0, 0, 0, "", fmt.Errorf("invalid ^GF byte count: %w", err)
+// encoding returns the GraphicType of the data of the field.
+func (f graphicField) encoding() GraphicType {
+	switch {
+	case f.format == 'B':
+		return Binary
+	case strings.HasPrefix(f.data, ":Z64:"):
+		return Z64
+	case strings.HasPrefix(f.data, ":B64:"):
+		return B64
+	case strings.ContainsFunc(f.data, func(r rune) bool {
+		_, hex := hexNibble(byte(r))
+		return r >= 0x80 || !(hex || unicode.IsSpace(r))
+	}):
+		return CompressedASCII
+	}
+	return ASCII
+}
+
+// maxImageDots caps the width and height of decoded BMP and TIFF images and maxImagePixels their area,
+// so that forged headers fail before the pixels are allocated.
+const (
+	maxImageDots   = 1 << 16
+	maxImagePixels = 1 << 27
+)
+
+// checkImageSize returns an error if an image of width x height pixels exceeds the maximum size.
+func checkImageSize(width, height int) error {
+	if width > maxImageDots || height > maxImageDots {
+		return fmt.Errorf("%dx%d pixels exceed the maximum of %dx%d", width, height, maxImageDots, maxImageDots)
 	}
-	bytesPerRow, err := strconv.Atoi(strings.TrimSpace(parts[3]))
-	if err != nil {
-		return 0, 0, 0, "", fmt.Errorf("invalid ^GF bytes per row: %w", err)
+	if pixels := int64(width) * int64(height); pixels > maxImagePixels {
+		return fmt.Errorf("%dx%d pixels exceed the maximum of %d pixels", width, height, maxImagePixels)
 	}
+	return nil
+}
 
-	data := parts[4]
-	if end := strings.Index(data, "^FS"); end != -1 {
-		data = data[:end]
-	} else if end := strings.Index(data, "^XZ"); end != -1 {
-		data = data[:end]
-	}
-	if gfType == 'B' {
-		data = strings.TrimPrefix(data, "\r\n")
-		data = strings.TrimPrefix(data, "\n")
-	} else {
-		data = strings.TrimSpace(data)
+// graphicFieldSize returns the size in dots of a graphic field with the declared total and row byte counts.
+func graphicFieldSize(total, bytesPerRow int) (int, int, error) {
+	if bytesPerRow <= 0 || total < 0 || total%bytesPerRow != 0 {
+		return 0, 0, fmt.Errorf("%d bytes are no multiple of %d bytes per row", total, bytesPerRow)
+	}
+	width, height := bytesPerRow*8, total/bytesPerRow
+	if err := checkGraphicSize(width, height); err != nil {
+		return 0, 0, err
 	}
+	return width, height, nil
+}
 
-	return gfType, bytesUsed, bytesPerRow, data, nil
+// graphicField is a parsed ^GF command. The offsets are byte offsets in the parsed string.
+type graphicField struct {
+	format rune
+	// declared is the binary byte count, or -1 if it is no number
+	declared           int
+	total, bytesPerRow int
+	totalOffset        int
+	data               string
+	dataOffset         int
 }
 
-func decodeBinaryData(data string, bytesUsed int) ([]byte, error) {
-	raw := []byte(data)
-	if len(raw) < bytesUsed {
-		return nil, fmt.Errorf("binary ^GF data too short")
+// size returns the size in dots of the field or a *DecodeError for invalid dimensions.
+func (f graphicField) size() (int, int, error) {
+	width, height, err := graphicFieldSize(f.total, f.bytesPerRow)
+	if err != nil {
+		return 0, 0, &DecodeError{Kind: DecodeDimensions, Offset: f.totalOffset, Row: -1, Err: err}
 	}
-	return raw[:bytesUsed], nil
+	return width, height, nil
 }
 
-func decodeASCIIData(data string, bytesUsed, bytesPerRow int) ([]byte, error) {
-	if strings.HasPrefix(data, ":Z64:") {
-		return decodeZ64Data(data, bytesUsed)
+func parseGraphicField(command string) (graphicField, error) {
+	if len(command) < 4 || !strings.EqualFold(command[1:3], "GF") {
+		return graphicField{}, decodeError(DecodeHeader, 0, "graphic field must start with ^GF")
 	}
 
-	rowHexLen := bytesPerRow * 2
-	rows, err := expandCompressedASCII(data, rowHexLen, bytesUsed/bytesPerRow)
-	if err != nil {
-		return nil, err
+	parts := strings.SplitN(command[4:], ",", 5)
+	if len(parts) != 5 {
+		// point at the last parameter present, the end of the command may be on the next line
+		last := len(command[4:]) - len(parts[len(parts)-1])
+		return graphicField{}, decodeError(DecodeHeader, 4+last, "^GF needs 4 parameters before its data")
+	}
+	var offsets [5]int
+	offsets[0] = 4
+	for i := 1; i < len(parts); i++ {
+		offsets[i] = offsets[i-1] + len(parts[i-1]) + 1
 	}
 
-	raw := make([]byte, 0, bytesUsed)
-	for _, row := range rows {
-		bytes, err := hex.DecodeString(row)
-		if err != nil {
-			return nil, err
+	field := graphicField{format: unicode.ToUpper(rune(command[3])), totalOffset: offsets[2]}
+	var err error
+	if field.declared, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil {
+		field.declared = -1
+	}
+	if field.total, err = strconv.Atoi(strings.TrimSpace(parts[2])); err != nil {
+		return graphicField{}, decodeError(DecodeHeader, offsets[2], "^GF byte count %q is not a number", parts[2])
+	}
+	if field.bytesPerRow, err = strconv.Atoi(strings.TrimSpace(parts[3])); err != nil {
+		return graphicField{}, decodeError(DecodeHeader, offsets[3], "^GF bytes per row %q is not a number", parts[3])
+	}
+
+	// binary data may contain any byte, it ends after the declared number of bytes
+	data := parts[4]
+	if field.format != 'B' {
+		if end := strings.Index(data, "^FS"); end != -1 {
+			data = data[:end]
+		} else if end := strings.Index(data, "^XZ"); end != -1 {
+			data = data[:end]
 		}
-		raw = append(raw, bytes...)
 	}
-	if len(raw) != bytesUsed {
-		return nil, fmt.Errorf("^GF data size mismatch: got %d bytes, want %d", len(raw), bytesUsed)
+	if field.format == 'B' {
+		field.data = strings.TrimPrefix(data, "\r\n")
+		field.data = strings.TrimPrefix(field.data, "\n")
+		field.dataOffset = offsets[4] + len(data) - len(field.data)
+	} else {
+		field.data = strings.TrimSpace(data)
+		field.dataOffset = offsets[4] + len(data) - len(strings.TrimLeftFunc(data, unicode.IsSpace))
 	}
-	return raw, nil
+	return field, nil
+}
+
+func decodeBinaryData(data string, bytesUsed, bytesPerRow int) ([]byte, error) {
+	raw := []byte(data)
+	if len(raw) < bytesUsed {
+		return nil, &DecodeError{Kind: DecodeSize, Offset: len(data), Row: len(data) / max(bytesPerRow, 1), Err: fmt.Errorf("got %d bytes, want %d", len(data), bytesUsed)}
+	}
+	return raw[:bytesUsed], nil
 }
 
-func decodeZ64Data(data string, bytesUsed int) ([]byte, error) {
+// decodeASCIIData decodes ASCII, CompressedASCII, Z64 or B64 data into bytesUsed bytes of packed rows.
+func decodeASCIIData(ctx context.Context, data string, bytesUsed, bytesPerRow int, limits Limits) ([]byte, error) {
+	if strings.HasPrefix(data, ":Z64:") || strings.HasPrefix(data, ":B64:") {
+		return decodeZ64Data(data, bytesUsed, limits)
+	}
+	return expandCompressedASCII(ctx, data, bytesPerRow, bytesUsed/bytesPerRow)
+}
+
+// decodeZ64Data inflates a Z64 payload or decodes a B64 payload. It reads at most one byte more than declared,
+// so a small payload can't inflate to more memory than the field claims to need.
+// The offsets of errors point into the base64 data or at the CRC.
+func decodeZ64Data(data string, bytesUsed int, limits Limits) ([]byte, error) {
 	parts := strings.Split(data, ":")
-	if len(parts) < 4 || parts[1] != "Z64" {
-		return nil, fmt.Errorf("invalid Z64 payload")
+	if len(parts) < 4 || (parts[1] != "Z64" && parts[1] != "B64") {
+		return nil, decodeError(DecodeZ64, 0, "data must be :Z64:data:crc or :B64:data:crc")
 	}
+	const dataOffset = len(":Z64:")
 	compressed, err := base64.StdEncoding.DecodeString(parts[2])
 	if err != nil {
-		return nil, err
+		offset := dataOffset
+		var corrupt base64.CorruptInputError
+		if errors.As(err, &corrupt) {
+			offset += int(corrupt)
+		}
+		return nil, &DecodeError{Kind: DecodeZ64, Offset: offset, Row: -1, Err: err}
 	}
 	if len(parts[3]) >= 4 {
 		want := strings.ToUpper(parts[3][:4])
 		got := fmt.Sprintf("%04X", crc16CCITT(compressed))
 		if want != got {
-			return nil, fmt.Errorf("Z64 CRC mismatch: got %s, want %s", got, want)
+			return nil, decodeError(DecodeChecksum, dataOffset+len(parts[2])+1, "got %s, want %s", got, want)
+		}
+	}
+	if parts[1] == "B64" {
+		if len(compressed) != bytesUsed {
+			return nil, decodeError(DecodeSize, dataOffset, "B64 data has %d bytes, want %d", len(compressed), bytesUsed)
 		}
+		return compressed, nil
 	}
 
 	reader, err := zlib.NewReader(bytes.NewReader(compressed))
 	if err != nil {
-		return nil, err
+		return nil, &DecodeError{Kind: DecodeZ64, Offset: dataOffset, Row: -1, Err: err}
 	}
 	defer reader.Close()
-	raw, err := io.ReadAll(reader)
+	limit := int64(bytesUsed)
+	if limits.MaxInflatedBytes > 0 {
+		limit = min(limit, limits.MaxInflatedBytes)
+	}
+	raw, err := io.ReadAll(io.LimitReader(reader, limit+1))
 	if err != nil {
-		return nil, err
+		return nil, &DecodeError{Kind: DecodeZ64, Offset: dataOffset, Row: -1, Err: err}
+	}
+	if limits.MaxInflatedBytes > 0 && int64(len(raw)) > limits.MaxInflatedBytes {
+		return nil, &LimitError{Kind: LimitInflatedBytes, Limit: limits.MaxInflatedBytes, Size: int64(len(raw))}
+	}
+	if len(raw) > bytesUsed {
+		return nil, decodeError(DecodeSize, dataOffset, "Z64 data inflates to more than the declared %d bytes", bytesUsed)
 	}
 	if len(raw) != bytesUsed {
-		return nil, fmt.Errorf("Z64 data size mismatch: got %d bytes, want %d", len(raw), bytesUsed)
+		return nil, decodeError(DecodeSize, dataOffset, "Z64 data inflates to %d bytes, want %d", len(raw), bytesUsed)
 	}
 	return raw, nil
 }
 
-func expandCompressedASCII(data string, rowHexLen, expectedRows int) ([]string, error) {
-	// Index 0 is unused so each character maps directly to its repeat count:
-	// 'g'=1*20, 'h'=2*20, ... and 'G'=1, 'H'=2, ...
-	highRepeat := " ghijklmnopqrstuvwxyz"
-	lowRepeat := " GHIJKLMNOPQRSTUVWXY"
-	rows := make([]string, 0, expectedRows)
-	var current strings.Builder
-	lastRow := ""
-	repeat := 0
-
-	appendRow := func(row string) error {
-		if len(row) != rowHexLen {
-			return fmt.Errorf("^GF row length mismatch: got %d nibbles, want %d", len(row), rowHexLen)
+// expandCompressedASCII decodes ASCII and CompressedASCII data into expectedRows packed rows of bytesPerRow bytes.
+// Every hex digit may be preceded by a repeat count of high characters g to z (20 to 400 each) followed by at most
+// one low character G to Y (1 to 19). A ',' or '!' fills the rest of the row with 0 or F and a ':' at the start of
+// a row repeats the previous row. The output grows with the decoded rows and decoding stops as soon as the data
+// exceeds the expected rows, so the declared size is never allocated up front.
+// Errors are *DecodeError with the offset in data and the row being decoded.
+func expandCompressedASCII(ctx context.Context, data string, bytesPerRow, expectedRows int) ([]byte, error) {
+	// Compressed data is rarely larger than its rows, so its length is a first guess
+	// for the output that doesn't trust the declared size.
+	raw := make([]byte, 0, min(bytesPerRow*expectedRows, len(data)))
+	rows := 0
+	nibble := 0 // nibbles written to the current row
+	high, low := 0, 0
+	countStart := 0 // offset of the pending repeat count
+	i := 0
+
+	fail := func(kind DecodeKind, offset int, format string, args ...any) error {
+		return &DecodeError{Kind: kind, Offset: offset, Row: rows, Err: fmt.Errorf(format, args...)}
+	}
+	// startRow appends a cleared row if the current one is complete
+	startRow := func() error {
+		if nibble != 0 {
+			return nil
+		}
+		if rows == expectedRows {
+			return fail(DecodeSize, i, "more than the declared %d rows", expectedRows)
 		}
-		rows = append(rows, row)
-		lastRow = row
-		current.Reset()
+		if err := canceled(ctx); err != nil {
+			return err
+		}
+		raw = append(raw, make([]byte, bytesPerRow)...)
 		return nil
 	}
+	writeNibble := func(value byte) {
+		i := rows*bytesPerRow + nibble/2
+		if nibble%2 == 0 {
+			raw[i] |= value << 4
+		} else {
+			raw[i] |= value
+		}
+		if nibble++; nibble == 2*bytesPerRow {
+			rows, nibble = rows+1, 0
+		}
+	}
 
-	for _, r := range data {
-		if r == '\n' || r == '\r' || r == '\t' || r == ' ' {
+	for ; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '\n' || c == '\r' || c == '\t' || c == ' ':
 			continue
-		}
-		if r == ':' {
-			if current.Len() != 0 {
-				return nil, fmt.Errorf("repeat-line marker inside a row")
+		case c >= 'g' && c <= 'z':
+			if low != 0 {
+				return nil, fail(DecodeRepeatCount, i, "high repeat count %q after low repeat count", c)
 			}
-			if lastRow == "" {
-				return nil, fmt.Errorf("repeat-line marker without previous row")
+			if high == 0 {
+				countStart = i
 			}
-			rows = append(rows, lastRow)
+			high += int(c-'g'+1) * 20
 			continue
-		}
-		if r == ',' || r == '!' {
-			if current.Len() != 0 {
-				return nil, fmt.Errorf("full-line marker inside a row")
-			}
-			fill := "0"
-			if r == '!' {
-				fill = "F"
+		case c >= 'G' && c <= 'Y':
+			if low != 0 {
+				return nil, fail(DecodeRepeatCount, i, "second low repeat count %q", c)
 			}
-			if err := appendRow(strings.Repeat(fill, rowHexLen)); err != nil {
-				return nil, err
+			if high == 0 {
+				countStart = i
 			}
+			low = int(c - 'G' + 1)
 			continue
 		}
-		if idx := strings.IndexRune(highRepeat, r); idx > 0 {
-			repeat += idx * 20
-			continue
-		}
-		if idx := strings.IndexRune(lowRepeat, r); idx > 0 {
-			repeat += idx
-			continue
-		}
-		if !isHexRune(r) {
-			return nil, fmt.Errorf("invalid ^GF data character %q", r)
+		if _, ok := hexNibble(c); !ok && (high != 0 || low != 0) {
+			return nil, fail(DecodeRepeatCount, i, "repeat count followed by %q instead of a hex digit", c)
 		}
 
-		count := repeat
-		if count == 0 {
-			count = 1
-		}
-		current.WriteString(strings.Repeat(strings.ToUpper(string(r)), count))
-		repeat = 0
-		currentData := current.String()
-		current.Reset()
-		for len(currentData) >= rowHexLen {
-			row := currentData[:rowHexLen]
-			currentData = currentData[rowHexLen:]
-			if err := appendRow(row); err != nil {
+		switch c {
+		case ':':
+			if nibble != 0 {
+				return nil, fail(DecodeRowMarker, i, "':' inside a row after %d of %d nibbles", nibble, 2*bytesPerRow)
+			}
+			if rows == 0 {
+				return nil, fail(DecodeRowMarker, i, "':' without a previous row")
+			}
+			if err := startRow(); err != nil {
+				return nil, err
+			}
+			copy(raw[rows*bytesPerRow:], raw[(rows-1)*bytesPerRow:rows*bytesPerRow])
+			rows++
+		case ',', '!':
+			if err := startRow(); err != nil {
 				return nil, err
 			}
+			var fill byte
+			if c == '!' {
+				fill = 0xf
+			}
+			for row := rows; rows == row; {
+				writeNibble(fill)
+			}
+		default:
+			value, ok := hexNibble(c)
+			if !ok {
+				return nil, fail(DecodeCharacter, i, "%q is no hex digit, repeat count or row marker", c)
+			}
+			count := max(high+low, 1)
+			high, low = 0, 0
+			for ; count > 0; count-- {
+				if err := startRow(); err != nil {
+					return nil, err
+				}
+				writeNibble(value)
+			}
 		}
-		current.WriteString(currentData)
 	}
 
-	if current.Len() != 0 {
-		if err := appendRow(current.String()); err != nil {
-			return nil, err
-		}
+	if high != 0 || low != 0 {
+		return nil, fail(DecodeRepeatCount, countStart, "repeat count at the end of the data")
 	}
-	if repeat != 0 {
-		return nil, fmt.Errorf("dangling repeat count in ^GF data")
+	if nibble != 0 {
+		return nil, fail(DecodeSize, len(data), "the last row has %d of %d nibbles", nibble, 2*bytesPerRow)
 	}
-	if len(rows) != expectedRows {
-		return nil, fmt.Errorf("^GF row count mismatch: got %d rows, want %d", len(rows), expectedRows)
+	if rows != expectedRows {
+		return nil, fail(DecodeSize, len(data), "got %d rows, want %d", rows, expectedRows)
 	}
-	return rows, nil
+	return raw, nil
 }
 
-func isHexRune(r rune) bool {
-	return (r >= '0' && r <= '9') || (r >= 'A' && r <= 'F') || (r >= 'a' && r <= 'f')
+// hexNibble returns the value of the hex digit c.
+func hexNibble(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	}
+	return 0, false
 }
 
-func imageFromGraphicData(raw []byte, bytesPerRow int) *image.Gray {
-	const (
-		bitsPerByte = 8
-		maxBitIndex = bitsPerByte - 1
-	)
-	height := len(raw) / bytesPerRow
-	width := bytesPerRow * bitsPerByte
-	img := image.NewGray(image.Rect(0, 0, width, height))
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			pixel := color.White
-			bitIndex := uint(maxBitIndex - x%bitsPerByte)
-			if raw[y*bytesPerRow+x/bitsPerByte]&(1<<bitIndex) != 0 {
-				pixel = color.Black
-			}
-			img.Set(x, y, pixel)
-		}
-	}
-	return img
+// imageFromGraphicData wraps decoded rows in a Monochrome image without copying them.
+func imageFromGraphicData(raw []byte, bytesPerRow int) *Monochrome {
+	return &Monochrome{Pix: raw, Stride: bytesPerRow, Rect: image.Rect(0, 0, bytesPerRow*8, len(raw)/bytesPerRow)}
 }
 
 func crc16CCITT(data []byte) uint16 {
@@ -562,64 +925,68 @@ func ConvertToGraphicField(source image.Image, graphicType GraphicType) string {
 
 // ConvertToGraphicFieldWithError converts an image.Image to a ZPL compatible Graphic Field and returns encoding errors.
 func ConvertToGraphicFieldWithError(source image.Image, graphicType GraphicType) (string, error) {
-	var gfType, graphicFieldData string
-	size := source.Bounds().Size()
-	width := (size.X + 7) / 8 // round up division
-	height := size.Y
-	var lastLine string
-	var rawGraphicData []byte
-	if graphicType == Z64 {
-		rawGraphicData = make([]byte, 0, width*height)
-	}
-
-	for y := 0; y < height; y++ {
-		line := make([]uint8, width)
-		for x := 0; x < size.X; x++ {
-			if x%8 == 0 {
-				line[x/8] = 0
-			}
-			if lum := color.Gray16Model.Convert(source.At(x, y)).(color.Gray16).Y; lum < math.MaxUint16/2 {
-				line[x/8] |= 1 << (7 - uint(x)%8)
-			}
-		}
+	return ConvertToGraphicFieldWithOptions(source, ConvertOptions{GraphicType: graphicType})
+}
 
-		if graphicType == Z64 {
-			rawGraphicData = append(rawGraphicData, line...)
-			continue
-		}
+// ConvertToGraphicFieldWithOptions converts an image.Image to a ZPL compatible Graphic Field,
+// flattening, scaling and dithering it and applying the morphological operations and black thinning of options to the thresholded bitmap.
+func ConvertToGraphicFieldWithOptions(source image.Image, options ConvertOptions) (string, error) {
+	return sharedConverter(options).ConvertToGraphicField(source)
+}
 
-		hexStr := strings.ToUpper(hex.EncodeToString(line))
-		switch graphicType {
-		case ASCII:
-			graphicFieldData += fmt.Sprintln(hexStr)
-		case CompressedASCII:
-			curLine := CompressASCII(hexStr)
-			if lastLine == curLine {
-				graphicFieldData += ":"
-			} else {
-				graphicFieldData += curLine
-			}
-			lastLine = curLine
-		case Binary:
-			graphicFieldData += string(line)
-		}
+// ConvertToGraphicFieldContext is ConvertToGraphicFieldWithOptions, stopping with the error of ctx once ctx is done.
+func ConvertToGraphicFieldContext(ctx context.Context, source image.Image, options ConvertOptions) (string, error) {
+	return sharedConverter(options).ConvertToGraphicFieldContext(ctx, source)
+}
+
+// encodeGraphicData encodes packed rows as graphic field data of the given type.
+func encodeGraphicData(raw []byte, width, height int, graphicType GraphicType) (string, error) {
+	var buffers encodeBuffers
+	if err := buffers.writeGraphicData(context.Background(), raw, width, height, graphicType, 1, Limits{}); err != nil {
+		return "", err
+	}
+	return buffers.data.String(), nil
+}
+
+// encodeHexLine writes src as upper case hex digits to the start of dst.
+func encodeHexLine(dst, src []byte) {
+	const digits = "0123456789ABCDEF"
+	for i, v := range src {
+		dst[2*i] = digits[v>>4]
+		dst[2*i+1] = digits[v&0x0f]
 	}
+}
 
-	totalBytes := len(graphicFieldData)
-	switch graphicType {
-	case ASCII, CompressedASCII:
-		gfType = "A"
-	case Binary:
-		gfType = "B"
-	case Z64:
-		gfType = "A"
-		totalBytes = len(rawGraphicData)
-		encoded, err := EncodeZ64(rawGraphicData)
-		if err != nil {
-			return "", err
-		}
-		graphicFieldData = encoded
+// packImage thresholds an image and packs it into rows of one bit per dot,
+// most significant bit first, with black dots set. It returns the packed data
+// and the number of bytes per row.
+func packImage(source image.Image) ([]byte, int) {
+	raw, width, _ := packImageInto(context.Background(), nil, source, 1)
+	return raw, width
+}
+
+// packRows packs the rows start to end, relative to the top of the image, into raw.
+// It stops with the error of ctx once ctx is done.
+func packRows(ctx context.Context, source image.Image, raw []byte, width, start, end int) error {
+	if m, ok := source.(*Monochrome); ok {
+		m.packRows(raw, width, start, end)
+		return nil
 	}
 
-	return fmt.Sprintf("^GF%s,%d,%d,%d,\n%s", gfType, totalBytes, width*height, width, graphicFieldData), nil
+	bounds := source.Bounds()
+	read := newBlackRowReader(source)
+	black := make([]bool, bounds.Dx())
+	for y := start; y < end; y++ {
+		if err := canceled(ctx); err != nil {
+			return err
+		}
+		read(bounds.Min.Y+y, black)
+		line := raw[y*width : (y+1)*width]
+		for x, isBlack := range black {
+			if isBlack {
+				line[x/8] |= 1 << (7 - uint(x)%8)
+			}
+		}
+	}
+	return nil
 }
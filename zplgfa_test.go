@@ -3,6 +3,7 @@ package zplgfa
 import (
 	"bytes"
 	"compress/zlib"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -122,7 +123,7 @@ func Test_ConvertGraphicFieldToImage(t *testing.T) {
 			if err != nil {
 				t.Fatalf("ConvertZPLToImage failed: %s", err)
 			}
-			assertGrayImageEqual(t, got, img)
+			assertGrayImageEqual(t, got.Gray(), img)
 		})
 	}
 }
@@ -139,7 +140,7 @@ func Test_ConvertGraphicFieldToImageCompressedRepeatLine(t *testing.T) {
 			want.Set(x, y, color.Black)
 		}
 	}
-	assertGrayImageEqual(t, got, want)
+	assertGrayImageEqual(t, got.Gray(), want)
 }
 
 func Test_ConvertGraphicFieldToImageError(t *testing.T) {
@@ -151,6 +152,57 @@ func Test_ConvertGraphicFieldToImageError(t *testing.T) {
 	}
 }
 
+func Test_ExpandCompressedASCII(t *testing.T) {
+	valid := map[string][]byte{
+		"FF\n00":   {0xff, 0x00},
+		"gF,":      {0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00},
+		"HF,:":     {0xff, 0x00, 0x00, 0xff, 0x00, 0x00},
+		"!F0,":     {0xff, 0xff, 0xff, 0xf0, 0x00, 0x00},
+		"IFG0\n,,": {0xff, 0xf0, 0x00, 0x00, 0x00, 0x00},
+	}
+	for data, want := range valid {
+		got, err := expandCompressedASCII(context.Background(), data, len(want)/2, 2)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("expandCompressedASCII failed for %q: got %X, %v, want %X", data, got, err, want)
+		}
+	}
+
+	for _, data := range []string{"GG0,", "Gg0,", "G:,", "H,,", "0:", ":,", "FFF", "FF,,", "FFG", "FX,"} {
+		if _, err := expandCompressedASCII(context.Background(), data, 1, 2); err == nil {
+			t.Fatalf("expandCompressedASCII should fail for %q", data)
+		}
+	}
+}
+
+func Test_ConvertGraphicFieldToImageBounds(t *testing.T) {
+	for _, field := range []string{
+		"^GFA,0,99999999999,99999999,\n,",
+		"^GFA,0,9000,9000,\n,",
+		"^GFA,0,700000000,1,\n,",
+		"^GFA,0,1000000,1,\nFF",
+		// every colon repeats a whole row, a small field could declare hundreds of megabytes
+		"^GFA,536870912,536870912,8192,\n," + strings.Repeat(":", 65535),
+	} {
+		if _, err := ConvertGraphicFieldToImage(field); err == nil {
+			t.Fatalf("ConvertGraphicFieldToImage should fail for %q", field)
+		}
+	}
+
+	// a Z64 payload that inflates to far more than its declared size
+	var inflated bytes.Buffer
+	writer := zlib.NewWriter(&inflated)
+	writer.Write(make([]byte, 1<<20))
+	writer.Close()
+	payload := ":Z64:" + base64.StdEncoding.EncodeToString(inflated.Bytes()) + fmt.Sprintf(":%04X", crc16CCITT(inflated.Bytes()))
+	if _, err := ConvertGraphicFieldToImage("^GFA,0,1,1,\n" + payload); err == nil || !strings.Contains(err.Error(), "more than the declared") {
+		t.Fatalf("ConvertGraphicFieldToImage failed: got %v, want a size mismatch", err)
+	}
+	// a Z64 payload is only read up to the declared size, which must not be huge either
+	if _, err := ConvertGraphicFieldToImage("^GFA,536870912,536870912,8192,\n" + payload); err == nil || !strings.Contains(err.Error(), "maximum") {
+		t.Fatalf("ConvertGraphicFieldToImage failed: got %v, want a size error", err)
+	}
+}
+
 func Test_ConvertToGraphicFieldZ64(t *testing.T) {
 	img := image.NewGray(image.Rect(0, 0, 8, 1))
 	assertZ64GraphicField(t, img, 1, []byte{0xff})
@@ -394,3 +446,77 @@ func benchmarkImage(width, height int) image.Image {
 	}
 	return img
 }
+
+func Test_FlattenImageWithOptions(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(3, 3, 5, 4))
+	img.SetNRGBA(3, 3, color.NRGBA{0xff, 0, 0, 0x80})
+
+	legacy := FlattenImage(img)
+	if got := FlattenImageWithOptions(img, FlattenOptions{}); !bytes.Equal(got.Pix, legacy.Pix) {
+		t.Fatalf("FlattenImageWithOptions failed: legacy mode differs from FlattenImage")
+	}
+
+	// the OR blend of legacy mode only works for white, other backgrounds are ignored
+	opaque := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	opaque.SetNRGBA(1, 0, color.NRGBA{0x80, 0x80, 0x80, 0xff})
+	opaque.SetNRGBA(0, 0, color.NRGBA{0, 0, 0, 0xff})
+	flat := FlattenImageWithOptions(opaque, FlattenOptions{Background: color.Black})
+	if got := [2]color.NRGBA{flat.NRGBAAt(0, 0), flat.NRGBAAt(1, 0)}; got != [2]color.NRGBA{{0, 0, 0, 0xff}, {0x80, 0x80, 0x80, 0xff}} {
+		t.Fatalf("FlattenImageWithOptions failed: legacy mode with a black background gives %v", got)
+	}
+	if got := FlattenImageWithOptions(img, FlattenOptions{Background: color.Black}); !bytes.Equal(got.Pix, legacy.Pix) {
+		t.Fatalf("FlattenImageWithOptions failed: legacy mode uses the background color")
+	}
+
+	tests := []struct {
+		options FlattenOptions
+		want    [2]color.NRGBA
+	}{
+		{FlattenOptions{Alpha: AlphaComposite}, [2]color.NRGBA{{0xff, 0x7f, 0x7f, 0xff}, {0xff, 0xff, 0xff, 0xff}}},
+		{FlattenOptions{Alpha: AlphaComposite, Background: color.Black}, [2]color.NRGBA{{0x80, 0, 0, 0xff}, {0, 0, 0, 0xff}}},
+		{FlattenOptions{Alpha: AlphaMask, AlphaThreshold: 0x7f}, [2]color.NRGBA{{0, 0, 0, 0xff}, {0xff, 0xff, 0xff, 0xff}}},
+		{FlattenOptions{Alpha: AlphaMask, AlphaThreshold: 0x80}, [2]color.NRGBA{{0xff, 0xff, 0xff, 0xff}, {0xff, 0xff, 0xff, 0xff}}},
+	}
+	for i, tt := range tests {
+		flat := FlattenImageWithOptions(img, tt.options)
+		if got := [2]color.NRGBA{flat.NRGBAAt(3, 3), flat.NRGBAAt(4, 3)}; got != tt.want {
+			t.Fatalf("FlattenImageWithOptions %d failed: got %v, want %v", i, got, tt.want)
+		}
+	}
+
+	zpl := ConvertToZPLWithOptions(img, ConvertOptions{GraphicType: ASCII, Flatten: FlattenOptions{Alpha: AlphaMask}})
+	if !strings.Contains(zpl, "^GFA,3,1,1,\n80\n") {
+		t.Fatalf("ConvertToZPLWithOptions failed: alpha mask is missing in %q", zpl)
+	}
+}
+
+func Test_ParseFlattenOptions(t *testing.T) {
+	if mode, err := ParseAlphaMode("Mask"); err != nil || mode != AlphaMask {
+		t.Fatalf("ParseAlphaMode failed: got %v, %v", mode, err)
+	}
+	if _, err := ParseAlphaMode("premultiplied"); err == nil {
+		t.Fatalf("ParseAlphaMode failed: unknown mode was accepted")
+	}
+
+	for _, options := range []FlattenOptions{{}, {Background: color.White}, {Background: color.Transparent}, {Alpha: AlphaComposite, Background: color.Black}} {
+		if err := options.Validate(); err != nil {
+			t.Fatalf("Validate failed for %+v: %v", options, err)
+		}
+	}
+	legacy := FlattenOptions{Background: color.Black}
+	if err := legacy.Validate(); err == nil {
+		t.Fatalf("Validate failed: legacy mode with a black background was accepted")
+	}
+	if _, err := ConvertToZPLContext(context.Background(), checkerImage(8, 2), ConvertOptions{Flatten: legacy}); err == nil {
+		t.Fatalf("ConvertToZPLContext failed: legacy mode with a black background was accepted")
+	}
+
+	for value, want := range map[string]color.NRGBA{"#ff8000": {0xff, 0x80, 0, 0xff}, "0f0": {0, 0xff, 0, 0xff}, "#00000080": {0, 0, 0, 0x80}} {
+		if got, err := ParseHexColor(value); err != nil || got != want {
+			t.Fatalf("ParseHexColor(%q) failed: got %v, %v", value, got, err)
+		}
+	}
+	if _, err := ParseHexColor("#12345"); err == nil {
+		t.Fatalf("ParseHexColor failed: invalid color was accepted")
+	}
+}